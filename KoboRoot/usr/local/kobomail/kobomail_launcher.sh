@@ -12,7 +12,8 @@ UNINSTALL=/mnt/onboard/.adds/kobomail/UNINSTALL
 if [ -f "$UNINSTALL" ]; then
     echo "$UNINSTALL exists, removing KoboMail..."
     logger -t "KoboMail" -p daemon.warning "Launcher: KoboMail UNINSTALL file located, removing KoboMail..."
-    ./usr/local/kobomail/uninstall.sh
+    # fall back to the uninstall script if the binary cannot run
+    /usr/local/kobomail/kobomail uninstall || ./usr/local/kobomail/uninstall.sh
     logger -t "KoboMail" -p daemon.warning "Launcher: KoboMail removed"
 else
     echo "Running KoboMail..."
@@ -0,0 +1,185 @@
+// Package config implements all commands of KoboMail
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	toml "github.com/knadh/koanf/parsers/toml"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	configShowCmd.Flags().StringP("output", "o", "toml", "Output format (toml, json)")
+	configValidateCmd.Flags().StringP("output", "o", "text", "Output format (text, json)")
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and change the KoboMail configuration",
+	Long:  "Inspect and change the KoboMail configuration.",
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration",
+	Long: `Show the effective configuration.
+This is the configuration KoboMail runs with after applying the defaults, the configuration file,
+KOBOMAIL_ environment variables and command line flags. Passwords are redacted.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		switch output {
+		case "json":
+			return printJSON(conf.Effective())
+		case "toml":
+			out, err := toml.Parser().Marshal(conf.Effective())
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(out))
+			return nil
+		}
+		return fmt.Errorf("invalid output format %s, must be one of: toml, json", output)
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:          "validate",
+	Short:        "Check the configuration for errors",
+	Long:         "Check the configuration for errors, every problem is reported with the setting and a hint on how to fix it.",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			return fmt.Errorf("invalid output format %s, must be one of: text, json", output)
+		}
+		if !helpers.FileExists(configFile) {
+			return fmt.Errorf("configuration file %s not found, run `kobomail init` to create it", configFile)
+		}
+
+		errs := conf.Validate()
+		if output == "json" {
+			if errs == nil {
+				errs = config.ValidationErrors{}
+			}
+			if err := printJSON(struct {
+				Valid  bool                    `json:"valid"`
+				Errors config.ValidationErrors `json:"errors"`
+			}{Valid: len(errs) == 0, Errors: errs}); err != nil {
+				return err
+			}
+		} else if len(errs) == 0 {
+			fmt.Println(configFile + " is valid")
+		}
+
+		if len(errs) > 0 {
+			if output == "json" {
+				return fmt.Errorf("the configuration contains %d error(s)", len(errs))
+			}
+			return errs
+		}
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key>=<value>...",
+	Short: "Change settings in the configuration file",
+	Long: `Change settings in the configuration file, keeping the rest of the file including comments.
+Keys are written as section.setting, for example imap_config.imap_host=imap.gmail.com.
+Lists are comma separated, for example processing_config.filetypes=epub,kepub.
+Accounts and rules can only be changed by editing the configuration file.`,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !helpers.FileExists(configFile) {
+			return fmt.Errorf("configuration file %s not found, run `kobomail init` to create it", configFile)
+		}
+		for _, arg := range args {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return fmt.Errorf("invalid argument %s, expected key=value", arg)
+			}
+			if err := config.SetValue(configFile, strings.TrimSpace(key), value); err != nil {
+				return err
+			}
+			fmt.Printf("Set %s in %s\n", strings.TrimSpace(key), configFile)
+		}
+		return nil
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print a JSON Schema of the configuration",
+	Long: `Print a JSON Schema of the configuration.
+Editors with TOML support can use it to offer completion and validation while editing kobomail_cfg.toml.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printJSON(config.JSONSchema())
+	},
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Rewrite the configuration file in the current layout",
+	Long: `Rewrite a configuration file written for an older KoboMail version in the current layout.
+Other commands read older layouts as well, only run and init rewrite the file themselves.
+Comments are not kept, the original file is kept next to it with a .bak extension.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !helpers.FileExists(configFile) {
+			return fmt.Errorf("configuration file %s not found, run `kobomail init` to create it", configFile)
+		}
+		backup, err := config.MigrateConfigFile(configFile)
+		if err != nil {
+			return err
+		}
+		if backup == "" {
+			fmt.Println(configFile + " already uses the current layout")
+			return nil
+		}
+		fmt.Printf("Migrated %s to config_version %d, the original file is kept as %s\n", configFile, config.CurrentConfigVersion, backup)
+		return nil
+	},
+}
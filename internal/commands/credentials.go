@@ -0,0 +1,126 @@
+// Package config implements all commands of KoboMail
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/credentials"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func init() {
+	credentialsCmd.AddCommand(credentialsSetCmd)
+	credentialsCmd.AddCommand(credentialsDeleteCmd)
+	credentialsCmd.AddCommand(credentialsListCmd)
+	rootCmd.AddCommand(credentialsCmd)
+}
+
+var credentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "Manage the encrypted IMAP passwords",
+	Long: `Manage the encrypted IMAP passwords.
+Passwords are stored encrypted with a key bound to this device, outside of the partition
+that is visible when the Kobo is connected to a computer. Set imap_pwd_encrypted = true
+in the configuration to use them. Passwords are stored by account name, which defaults to imap_user.`,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+}
+
+var credentialsSetCmd = &cobra.Command{
+	Use:          "set <account>",
+	Short:        "Store the encrypted password of an account, read from stdin",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pwd, err := readPassword("Password for " + args[0] + ": ")
+		if err != nil {
+			return err
+		}
+		if pwd == "" {
+			return fmt.Errorf("password cannot be empty")
+		}
+
+		store, err := credentials.Load(config.CredentialsFile, config.CredentialsKeyFile)
+		if err != nil {
+			return err
+		}
+		if err := store.Set(args[0], pwd); err != nil {
+			return err
+		}
+		if err := store.Save(); err != nil {
+			return err
+		}
+		fmt.Println("Stored password for " + args[0])
+		return nil
+	},
+}
+
+var credentialsDeleteCmd = &cobra.Command{
+	Use:          "delete <account>",
+	Short:        "Delete the encrypted password of an account",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := credentials.Load(config.CredentialsFile, config.CredentialsKeyFile)
+		if err != nil {
+			return err
+		}
+		if !store.Delete(args[0]) {
+			return fmt.Errorf("no password stored for %s", args[0])
+		}
+		if err := store.Save(); err != nil {
+			return err
+		}
+		fmt.Println("Deleted password for " + args[0])
+		return nil
+	},
+}
+
+var credentialsListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List the accounts with an encrypted password",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := credentials.Load(config.CredentialsFile, config.CredentialsKeyFile)
+		if err != nil {
+			return err
+		}
+		for _, name := range store.Names() {
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+// readPassword reads a password without echoing it on a terminal, or a single line from piped input
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		pwd, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(pwd), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,63 @@
+// Package config implements all commands of KoboMail
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bjw-s/kobomail/internal/kobomail"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	doctorCmd.Flags().StringP("output", "o", "text", "Output format (text, json)")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the device environment for common problems",
+	Long:  "Check the configuration and the device environment for common problems and suggest how to fix them.",
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kobomail.KoboMailConfig = conf
+
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			return fmt.Errorf("invalid output format %s, must be one of: text, json", output)
+		}
+
+		results := kobomail.Doctor(configFile)
+
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(results); err != nil {
+				return err
+			}
+		} else {
+			for _, result := range results {
+				fmt.Printf("[%s] %s: %s\n", strings.ToUpper(string(result.Status)), result.Name, result.Message)
+				if result.Fix != "" && result.Status != kobomail.CheckStatusPass {
+					fmt.Printf("       fix: %s\n", result.Fix)
+				}
+			}
+		}
+
+		failed := 0
+		for _, result := range results {
+			if result.Status == kobomail.CheckStatusFail {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d checks failed", failed)
+		}
+		return nil
+	},
+}
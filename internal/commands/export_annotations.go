@@ -0,0 +1,38 @@
+// Package config implements all commands of KoboMail
+package commands
+
+import (
+	"fmt"
+
+	"github.com/bjw-s/kobomail/internal/kobomail"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func init() {
+	exportAnnotationsCmd.Flags().Bool("all", false, "Export all highlights instead of only the ones created since the last export")
+	rootCmd.AddCommand(exportAnnotationsCmd)
+}
+
+var exportAnnotationsCmd = &cobra.Command{
+	Use:   "export-annotations",
+	Short: "Export highlights and notes by email",
+	Long:  "Export all highlights and notes made since the last export by email, grouped by book.",
+	Annotations: map[string]string{
+		annotationConnects: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kobomail.KoboMailConfig = conf
+		zap.S().Debugw("Running with configuration",
+			zap.Any("configuration", conf),
+		)
+
+		all, _ := cmd.Flags().GetBool("all")
+		exported, err := kobomail.ExportAnnotations(all)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d highlights.\n", exported)
+		return nil
+	},
+}
@@ -0,0 +1,40 @@
+// Package config implements all commands of KoboMail
+package commands
+
+import (
+	"fmt"
+
+	"github.com/bjw-s/kobomail/internal/kobomail"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Prepare the device for KoboMail",
+	Long: `Prepare the device for KoboMail.
+Creates the configuration and library folders, deploys the configuration template
+and installs CA certificates when they are missing. Existing files are never overwritten.`,
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+		annotationMigratesConfig: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kobomail.KoboMailConfig = conf
+
+		actions, err := kobomail.Init()
+		for _, action := range actions {
+			fmt.Println(action)
+		}
+		if err != nil {
+			return err
+		}
+		if len(actions) == 0 {
+			fmt.Println("Everything already in place, nothing to be done.")
+		}
+		return nil
+	},
+}
@@ -0,0 +1,97 @@
+// Package config implements all commands of KoboMail
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bjw-s/kobomail/internal/kobomail"
+	"github.com/bjw-s/kobomail/pkg/imap"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func init() {
+	listCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+	rootCmd.AddCommand(listCmd)
+}
+
+var listCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List the emails KoboMail would process",
+	Long:         "List the emails KoboMail would process and their attachments, without downloading or modifying anything.",
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConnects: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kobomail.KoboMailConfig = conf
+		zap.S().Debugw("Running with configuration",
+			zap.Any("configuration", conf),
+		)
+
+		output, _ := cmd.Flags().GetString("output")
+		if output != "table" && output != "json" {
+			return fmt.Errorf("invalid output format %s, must be one of: table, json", output)
+		}
+
+		mailboxMessages, err := kobomail.ListMessages()
+		if err != nil {
+			return err
+		}
+
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(mailboxMessages)
+		}
+		printMessagePreviews(os.Stdout, mailboxMessages)
+		return nil
+	},
+}
+
+func formatSize(size uint32) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint32(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMG"[exp])
+}
+
+func printMessagePreviews(out io.Writer, mailboxMessages []kobomail.MailboxMessages) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tFOLDER\tUID\tDATE\tFROM\tSUBJECT\tATTACHMENT\tSIZE\tMATCHES")
+	for _, mm := range mailboxMessages {
+		for _, p := range mm.Messages {
+			printMessagePreview(w, mm.Account, mm.Folder, p)
+		}
+	}
+	w.Flush()
+}
+
+func printMessagePreview(w io.Writer, account string, folder string, p imap.MessagePreview) {
+	date := p.Date.Format("2006-01-02 15:04")
+	if len(p.Attachments) == 0 {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t-\t-\t-\n", account, folder, p.UID, date, p.Sender, p.Subject)
+		return
+	}
+	for i, a := range p.Attachments {
+		matches := "no"
+		if a.Matches {
+			matches = "yes"
+		}
+		if i == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", account, folder, p.UID, date, p.Sender, p.Subject, a.Filename, formatSize(a.Size), matches)
+		} else {
+			fmt.Fprintf(w, "\t\t\t\t\t\t%s\t%s\t%s\n", a.Filename, formatSize(a.Size), matches)
+		}
+	}
+}
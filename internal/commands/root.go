@@ -2,67 +2,135 @@
 package commands
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/internal/kobomail"
+	"github.com/bjw-s/kobomail/pkg/helpers"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// annotationConfigOptional marks commands that can run without a (valid) configuration file
+const annotationConfigOptional = "kobomail/config-optional"
+
+// annotationMigratesConfig marks commands that rewrite a configuration file in an older layout,
+// every other command only migrates the configuration in memory
+const annotationMigratesConfig = "kobomail/migrates-config"
+
+// annotationConnects marks commands that connect to a server, only they read the passwords from their source
+const annotationConnects = "kobomail/connects"
+
 var (
-	conf = &config.Config{}
+	conf       = &config.Config{}
+	configFile string
 
 	rootCmd = &cobra.Command{
 		Use:   "kobomail",
 		Short: "KoboMail is an email attachment downloader for Kobo devices",
 		Long: `KoboMail is an email attachment downloader for Kobo devices.
 More information available at the Github Repo (https://github.com/bjw-s/KoboMail)`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := initConfig(cmd); err != nil {
+				// The arguments are fine, the usage would only hide the configuration errors
+				cmd.SilenceUsage = true
+				return err
+			}
+			// A dry run leaves the configuration file alone as well
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if conf.NeedsMigration() && cmd.Annotations[annotationMigratesConfig] == "true" && !dryRun {
+				migrateConfigFile()
+			}
+			return nil
+		},
 	}
 )
 
-func Execute() error {
-	return rootCmd.Execute()
+// Execute runs the KoboMail command line and returns the exit code
+func Execute() int {
+	return kobomail.ExitCode(rootCmd.Execute())
 }
 
 func init() {
-	cobra.OnInitialize(initConfig, initLogger)
 	cobra.OnFinalize(finalizeLogger)
 
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
-	rootCmd.PersistentFlags().StringP("config", "c", config.DefaultAddonPath+"/kobomail_cfg.toml", "config.toml file for parsing authentication information")
-	rootCmd.PersistentFlags().String("log-file", config.DefaultAddonPath+"/kobomail.log", "Log file location")
+	rootCmd.PersistentFlags().StringP("config", "c", config.DefaultConfigFile, "config.toml file for parsing authentication information")
+	rootCmd.PersistentFlags().String("log-file", config.DefaultLogFile, "Log file location")
 	rootCmd.PersistentFlags().StringP("log-level", "l", "info", "Log level (debug, info, warn, error, fatal, panic)")
 	rootCmd.PersistentFlags().String("log-format", "console", "Log format (console, json)")
 	rootCmd.PersistentFlags().String("library-path", config.DefaultLibraryPath, "KoboMail library location")
 }
 
-func initConfig() {
+func initConfig(cmd *cobra.Command) error {
 	var err error
-	conf, err = config.LoadConfig(rootCmd.PersistentFlags())
+	flags := cmd.Root().PersistentFlags()
+
+	// Commands that don't need a configuration can run before it has been created
+	configOptional := cmd.Annotations[annotationConfigOptional] == "true"
+	configFile, _ = flags.GetString("config")
+	if configOptional && !helpers.FileExists(configFile) {
+		flags.Set("config", "")
+	}
+
+	conf, err = config.LoadConfig(flags)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		// Without a configuration the logger is set up from the command line flags, so the failure ends up in the log
+		logFile, _ := flags.GetString("log-file")
+		logFormat, _ := flags.GetString("log-format")
+		logLevel, _ := flags.GetString("log-level")
+		initLogger(logFile, logFormat, logLevel)
+		zap.S().Errorw("Failed to load configuration", zap.String("file", configFile), zap.Error(err))
+		return &kobomail.RunError{Code: kobomail.ExitConfigError, Message: "Failed to load configuration", Err: err}
+	}
+	// The logger is set up before validating, so the dialog can refer to the log for the errors
+	initLogger(conf.ApplicationConfig.LogFile, conf.ApplicationConfig.LogFormat, conf.ApplicationConfig.LogLevel)
+	if cmd.Annotations[annotationConnects] == "true" {
+		conf.ResolvePasswords()
 	}
 
-	if err := conf.Validate(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	if configOptional {
+		return nil
+	}
+
+	if errs := conf.Validate(); errs != nil {
+		for _, e := range errs {
+			zap.S().Errorw("Invalid configuration", zap.String("key", e.Key), zap.String("error", e.Message), zap.String("hint", e.Hint))
+		}
+		kobomail.ShowConfigErrors(errs)
+		return errs
+	}
+	return nil
+}
+
+// migrateConfigFile rewrites the configuration file in the current layout, the configuration was already
+// migrated in memory so a failure only means the file is migrated again on the next run
+func migrateConfigFile() {
+	backup, err := config.MigrateConfigFile(configFile)
+	if err != nil {
+		zap.S().Warnw("Failed to migrate the configuration file to the current layout", zap.String("file", configFile), zap.Error(err))
+		return
 	}
+	zap.S().Infow("Migrated configuration to the current layout",
+		zap.String("file", configFile),
+		zap.String("backup", backup),
+		zap.Int("config_version", config.CurrentConfigVersion),
+	)
 }
 
-func initLogger() {
+// initLogger sets up the global logger, invalid settings fall back to the defaults so errors can always be logged
+func initLogger(logFilePath string, logFormat string, logLevel string) {
 	atom := zap.NewAtomicLevel()
 
 	encoder := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
-	if conf.ApplicationConfig.LogFormat == "json" {
+	if logFormat == "json" {
 		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
 	}
 
 	var core zapcore.Core
-	if conf.ApplicationConfig.LogFile != "/dev/stdout" {
-		logFile, _ := os.OpenFile(conf.ApplicationConfig.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if logFilePath != "/dev/stdout" {
+		logFile, _ := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 		core = zapcore.NewTee(
 			zapcore.NewCore(encoder, zapcore.Lock(logFile), atom),
 			zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), atom),
@@ -78,9 +146,9 @@ func initLogger() {
 	atom.SetLevel(zapcore.InfoLevel)
 	zap.ReplaceGlobals(logger)
 
-	lvl, err := zapcore.ParseLevel(conf.ApplicationConfig.LogLevel)
+	lvl, err := zapcore.ParseLevel(logLevel)
 	if err != nil {
-		zap.S().Errorf("Invalid log level %s, using default level: info", conf.ApplicationConfig.LogLevel)
+		zap.S().Errorf("Invalid log level %s, using default level: info", logLevel)
 		lvl = zapcore.InfoLevel
 	}
 	atom.SetLevel(lvl)
@@ -8,20 +8,41 @@ import (
 )
 
 func init() {
+	runCmd.Flags().Bool("dry-run", false, "Only report which files would be saved and which emails would be modified")
 	rootCmd.AddCommand(runCmd)
 }
 
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Run KoboMail processing",
-	Long:  "Run KoboMail processing.",
+	Long: `Run KoboMail processing.
+The exit code tells what went wrong: 2 for an invalid configuration, 3 when the IMAP server could not be reached
+or the login failed, 4 when a mailbox could not be read, 5 when one or more emails could not be processed
+and 6 when the integration with the device could not be set up.`,
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConnects:       "true",
+		annotationMigratesConfig: "true",
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		kobomail.KoboMailConfig = conf
 		zap.S().Debugw("Running with configuration",
 			zap.Any("configuration", conf),
 		)
-		kobomail.PreparePrerequisites()
-		kobomail.Run()
-		return nil
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if dryRun {
+			return kobomail.Run(kobomail.RunOptions{DryRun: true})
+		}
+
+		if err := kobomail.PreparePrerequisites(); err != nil {
+			return &kobomail.RunError{Code: kobomail.ExitSetupError, Message: "Failed to set up the device integration", Err: err}
+		}
+		if conf.AnnotationsConfig.ExportOnRun {
+			if _, err := kobomail.ExportAnnotations(false); err != nil {
+				zap.S().Errorw("Could not export annotations", zap.Error(err))
+			}
+		}
+		return kobomail.Run(kobomail.RunOptions{})
 	},
 }
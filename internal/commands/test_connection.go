@@ -0,0 +1,31 @@
+// Package config implements all commands of KoboMail
+package commands
+
+import (
+	"os"
+
+	"github.com/bjw-s/kobomail/internal/kobomail"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func init() {
+	rootCmd.AddCommand(testConnectionCmd)
+}
+
+var testConnectionCmd = &cobra.Command{
+	Use:          "test-connection",
+	Short:        "Test the connection to the IMAP server",
+	Long:         "Test the connection to the IMAP server step by step and report which stage fails.",
+	SilenceUsage: true,
+	Annotations: map[string]string{
+		annotationConnects: "true",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kobomail.KoboMailConfig = conf
+		zap.S().Debugw("Running with configuration",
+			zap.Any("configuration", conf),
+		)
+		return kobomail.TestConnection(os.Stdout)
+	},
+}
@@ -2,12 +2,17 @@
 package commands
 
 import (
+	"fmt"
+
 	"github.com/bjw-s/kobomail/internal/kobomail"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
 
 func init() {
+	uninstallCmd.Flags().Bool("remove-library", false, "Also remove the KoboMail library folder and the books in it")
+	uninstallCmd.Flags().Bool("keep-config", false, "Keep the KoboMail configuration folder")
+	uninstallCmd.Flags().Bool("dry-run", false, "Only list what would be removed")
 	rootCmd.AddCommand(uninstallCmd)
 }
 
@@ -15,11 +20,28 @@ var uninstallCmd = &cobra.Command{
 	Use:   "uninstall",
 	Short: "Uninstall KoboMail completely",
 	Long:  "Uninstall KoboMail completely.",
+	// Uninstalling must work with a broken configuration as well
+	Annotations: map[string]string{
+		annotationConfigOptional: "true",
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		kobomail.KoboMailConfig = conf
 		zap.S().Debugw("Running with configuration",
 			zap.Any("configuration", conf),
 		)
-		return nil
+
+		opts := kobomail.UninstallOptions{}
+		opts.RemoveLibrary, _ = cmd.Flags().GetBool("remove-library")
+		opts.KeepConfig, _ = cmd.Flags().GetBool("keep-config")
+		opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+
+		removed, err := kobomail.Uninstall(opts)
+		if opts.DryRun {
+			fmt.Println("The following paths would be removed:")
+		}
+		for _, path := range removed {
+			fmt.Println(path)
+		}
+		return err
 	},
 }
@@ -0,0 +1,183 @@
+// Package config implements all configuration aspects of KoboMail
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	gotoml "github.com/pelletier/go-toml"
+)
+
+// settingType returns the type of the setting with the dotted key.
+// Only settings in tables can be set, settings in arrays of tables like accounts and rules cannot.
+func settingType(key string) (reflect.Type, error) {
+	t := reflect.TypeOf(Config{})
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		keyType, ok := tableKeys(t)[part]
+		if !ok {
+			hint := ""
+			if suggestion := suggestKey(key); suggestion != "" {
+				hint = ", did you mean " + suggestion + "?"
+			}
+			return nil, fmt.Errorf("unknown setting %s%s", key, hint)
+		}
+		last := i == len(parts)-1
+		switch {
+		case keyType.Kind() == reflect.Struct && !last:
+			t = keyType
+		case keyType.Kind() == reflect.Slice && keyType.Elem().Kind() == reflect.Struct:
+			return nil, fmt.Errorf("%s is a list of tables, edit the configuration file to change it", strings.Join(parts[:i+1], "."))
+		case keyType.Kind() == reflect.Struct || !last || i == 0:
+			return nil, fmt.Errorf("%s is not a setting, use section.setting like imap_config.imap_host", key)
+		default:
+			return keyType, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a setting", key)
+}
+
+// formatSetting parses the value for a setting of the given type and formats it as TOML
+func formatSetting(t reflect.Type, value string) (string, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not true or false", value)
+		}
+		return strconv.FormatBool(b), nil
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a number", value)
+		}
+		return strconv.Itoa(n), nil
+	case reflect.Uint32:
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a positive number", value)
+		}
+		return strconv.FormatUint(n, 10), nil
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, strconv.Quote(item))
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	}
+	return strconv.Quote(value), nil
+}
+
+// valueEnd returns the index of the last line of the value starting on the given line,
+// arrays can span multiple lines
+func valueEnd(lines []string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(lines); i++ {
+		line := lines[i]
+		if i == start {
+			line = line[strings.Index(line, "=")+1:]
+			if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, `"""`) || strings.HasPrefix(trimmed, "'''") {
+				return 0, fmt.Errorf("multi-line strings are not supported, edit the configuration file to change it")
+			}
+		}
+		var quote rune
+		escaped := false
+	scan:
+		for _, r := range line {
+			switch {
+			case escaped:
+				escaped = false
+			case quote == '"' && r == '\\':
+				escaped = true
+			case quote != 0:
+				if r == quote {
+					quote = 0
+				}
+			case r == '"' || r == '\'':
+				quote = r
+			case r == '#':
+				break scan
+			case r == '[' || r == '{':
+				depth++
+			case r == ']' || r == '}':
+				depth--
+			}
+		}
+		if depth <= 0 {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated value")
+}
+
+// SetValue changes a single setting in the TOML configuration file, keeping the rest of the file including comments.
+// The key is the dotted TOML key like imap_config.imap_host, lists are given comma separated.
+// Files in an older layout have to be migrated first.
+func SetValue(path string, key string, value string) error {
+	keyType, err := settingType(key)
+	if err != nil {
+		return err
+	}
+	formatted, err := formatSetting(keyType, value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	tree, err := gotoml.LoadBytes(content)
+	if err != nil {
+		return err
+	}
+	// Keys are only known in the current layout, other layouts are not changed
+	version, err := configVersion(tree.ToMap())
+	if err != nil {
+		return err
+	}
+	if version > CurrentConfigVersion {
+		return fmt.Errorf("config_version %d is not supported, the configuration was written by a newer KoboMail version", version)
+	}
+	if version < CurrentConfigVersion {
+		return fmt.Errorf("%s uses an older configuration layout, run `kobomail config migrate` first", path)
+	}
+	lines := strings.Split(string(content), "\n")
+
+	parent, name := key[:strings.LastIndex(key, ".")], key[strings.LastIndex(key, ".")+1:]
+	if pos := tree.GetPosition(key); tree.Has(key) && !pos.Invalid() {
+		// Replace the existing value, keeping the key as it is written
+		start := pos.Line - 1
+		end, err := valueEnd(lines, start)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		prefix := lines[start][:strings.Index(lines[start], "=")]
+		updated := append([]string{}, lines[:start]...)
+		updated = append(updated, strings.TrimRight(prefix, " ")+" = "+formatted)
+		lines = append(updated, lines[end+1:]...)
+	} else if pos := tree.GetPosition(parent); tree.Has(parent) && !pos.Invalid() {
+		// Add the setting right below the table header
+		header := pos.Line - 1
+		indent := lines[header][:len(lines[header])-len(strings.TrimLeft(lines[header], " \t"))]
+		updated := append([]string{}, lines[:header+1]...)
+		updated = append(updated, indent+"    "+name+" = "+formatted)
+		lines = append(updated, lines[header+1:]...)
+	} else {
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+		lines = append(lines, "", "["+parent+"]", "    "+name+" = "+formatted, "")
+	}
+
+	updated := []byte(strings.Join(lines, "\n"))
+	if _, err := gotoml.LoadBytes(updated); err != nil {
+		return fmt.Errorf("changing %s would result in an invalid configuration file: %w", key, err)
+	}
+	return os.WriteFile(path, updated, 0644)
+}
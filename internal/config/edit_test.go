@@ -0,0 +1,159 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const editConfig = `config_version = 1
+
+# Mail account
+[imap_config]
+    imap_host = "imap.gmail.com" # the server
+    "imap_port" = 993
+
+[processing_config]
+    filetypes = [
+        "epub",
+        "kepub", # e-books
+    ]
+    email_delete = false
+`
+
+func setValue(t *testing.T, content string, key string, value string) (string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kobomail_cfg.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := SetValue(path, key, value)
+	updated, _ := os.ReadFile(path)
+	return string(updated), err
+}
+
+func TestSetValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		key     string
+		value   string
+		want    string
+	}{
+		{
+			name:  "string",
+			key:   "imap_config.imap_host",
+			value: `mail.example.org`,
+			want:  strings.Replace(editConfig, `imap_host = "imap.gmail.com" # the server`, `imap_host = "mail.example.org"`, 1),
+		},
+		{
+			name:  "quoted key",
+			key:   "imap_config.imap_port",
+			value: "143",
+			want:  strings.Replace(editConfig, `"imap_port" = 993`, `"imap_port" = 143`, 1),
+		},
+		{
+			name:  "multi-line array",
+			key:   "processing_config.filetypes",
+			value: "epub, pdf,",
+			want: strings.Replace(editConfig, `filetypes = [
+        "epub",
+        "kepub", # e-books
+    ]`, `filetypes = ["epub", "pdf"]`, 1),
+		},
+		{
+			name:  "boolean",
+			key:   "processing_config.email_delete",
+			value: "yes",
+		},
+		{
+			name:  "new setting in an existing table",
+			key:   "processing_config.kepubify",
+			value: "true",
+			want:  strings.Replace(editConfig, "[processing_config]\n", "[processing_config]\n    kepubify = true\n", 1),
+		},
+		{
+			name:  "new table",
+			key:   "smtp_config.smtp_host",
+			value: "smtp.example.org",
+			want:  editConfig + "\n[smtp_config]\n    smtp_host = \"smtp.example.org\"\n",
+		},
+		{
+			name:  "nested table",
+			key:   "imap_config.search.larger",
+			value: "1000",
+			want:  editConfig + "\n[imap_config.search]\n    larger = 1000\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := setValue(t, editConfig, tt.key, tt.value)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("SetValue(%s, %q) succeeded, want an error", tt.key, tt.value)
+				}
+				if got != editConfig {
+					t.Errorf("SetValue(%s, %q) changed the file on error:\n%s", tt.key, tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetValue(%s, %q): %v", tt.key, tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("SetValue(%s, %q)\n got:\n%s\nwant:\n%s", tt.key, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetValueUnsupported(t *testing.T) {
+	for _, key := range []string{
+		"imap_config.imap_hots",
+		"imap_config",
+		"config_version",
+		"imap_config.search",
+		"rules.name",
+		"imap_config.imap_folders.name",
+	} {
+		if _, err := setValue(t, editConfig, key, "x"); err == nil {
+			t.Errorf("SetValue(%s) succeeded, want an error", key)
+		}
+	}
+	for _, content := range []string{
+		"[IMAPConfig]\nimap_host = \"imap.gmail.com\"\n",
+		"config_version = 0\n[imap_config]\nimap_host = \"imap.gmail.com\"\n",
+		"config_version = 2\n[imap_config]\nimap_host = \"imap.gmail.com\"\n",
+	} {
+		if got, err := setValue(t, content, "imap_config.imap_host", "x"); err == nil || got != content {
+			t.Errorf("SetValue() changed a configuration in another layout:\n%s", got)
+		}
+	}
+	if _, err := setValue(t, "[imap_config]\n    imap_host = \"\"\"imap\n.gmail.com\"\"\"\n", "imap_config.imap_host", "x"); err == nil {
+		t.Error("SetValue() replaced a multi-line string")
+	}
+}
+
+func TestValueEnd(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  int
+	}{
+		{[]string{`a = 1`, `b = 2`}, 0},
+		{[]string{`a = [1,`, `2]`, `b = 2`}, 1},
+		{[]string{`a = [`, `[1, 2],`, `[3],`, `]`}, 3},
+		{[]string{`a = "[" # [`, `b = 2`}, 0},
+		{[]string{`a = ["]\"", '[']`, `b = 2`}, 0},
+		{[]string{`a = { b = [1, 2] }`}, 0},
+		{[]string{`a = [ # ]`, `1 ]`}, 1},
+	}
+	for _, tt := range tests {
+		if got, err := valueEnd(tt.lines, 0); err != nil || got != tt.want {
+			t.Errorf("valueEnd(%q) = %d, %v, want %d", tt.lines, got, err, tt.want)
+		}
+	}
+	if _, err := valueEnd([]string{`a = [1,`, `2`}, 0); err == nil {
+		t.Error("valueEnd() of an unterminated array succeeded")
+	}
+}
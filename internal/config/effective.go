@@ -0,0 +1,84 @@
+// Package config implements all configuration aspects of KoboMail
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+var sensitiveStringType = reflect.TypeOf(sensitiveString(""))
+
+// Effective returns the configuration after merging the defaults, the TOML config, the environment and the flags,
+// keyed by the TOML keys. Secrets are redacted.
+func (c *Config) Effective() map[string]interface{} {
+	return effectiveTable(reflect.ValueOf(*c))
+}
+
+func effectiveTable(v reflect.Value) map[string]interface{} {
+	table := map[string]interface{}{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if strings.HasSuffix(field.Tag.Get("koanf"), ",squash") {
+			for key, value := range effectiveTable(v.Field(i)) {
+				table[key] = value
+			}
+			continue
+		}
+		key := koanfKey(field)
+		if key == "" && t == reflect.TypeOf(Config{}) && field.Name == "Accounts" {
+			key = "accounts"
+		}
+		if key == "" {
+			continue
+		}
+		if value, ok := effectiveValue(v.Field(i)); ok {
+			table[key] = value
+		}
+	}
+	return table
+}
+
+// effectiveValue converts the value to a plain TOML value, empty lists and tables are left out
+func effectiveValue(v reflect.Value) (interface{}, bool) {
+	if v.Type() == sensitiveStringType {
+		if v.String() == "" {
+			return "", true
+		}
+		return sensitiveString(v.String()).String(), true
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return effectiveTable(v), true
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil, false
+		}
+		if v.Type().Elem().Kind() == reflect.Struct {
+			tables := make([]map[string]interface{}, 0, v.Len())
+			for i := 0; i < v.Len(); i++ {
+				tables = append(tables, effectiveTable(v.Index(i)))
+			}
+			return tables, true
+		}
+		values := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, _ := effectiveValue(v.Index(i))
+			values = append(values, value)
+		}
+		return values, true
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return v.Bool(), true
+	case reflect.Int:
+		return v.Int(), true
+	case reflect.Uint32:
+		return int64(v.Uint()), true
+	}
+	return v.Interface(), true
+}
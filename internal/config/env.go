@@ -0,0 +1,98 @@
+// Package config implements all configuration aspects of KoboMail
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+)
+
+// EnvPrefix is the prefix of all environment variables read by KoboMail
+const EnvPrefix = "KOBOMAIL_"
+
+// envField is a configuration key that can be set through an environment variable
+type envField struct {
+	key  string
+	list bool
+}
+
+// envFields maps every supported environment variable to its configuration key.
+// Nested tables and lists of tables like accounts and rules cannot be set from the environment.
+func envFields() map[string]envField {
+	fields := map[string]envField{}
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		section := configType.Field(i)
+		sectionKey := koanfKey(section)
+		if sectionKey == "" || section.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			field := section.Type.Field(j)
+			key := koanfKey(field)
+			if key == "" || !envSupported(field.Type) {
+				continue
+			}
+			fields[EnvPrefix+strings.ToUpper(sectionKey+"_"+key)] = envField{
+				key:  sectionKey + "." + key,
+				list: field.Type.Kind() == reflect.Slice,
+			}
+		}
+	}
+	return fields
+}
+
+// EnvVariables maps every supported environment variable to its configuration key.
+// The variable name is the prefix followed by the section and key in upper case,
+// for example KOBOMAIL_IMAP_CONFIG_IMAP_HOST sets imap_host in the imap_config section.
+func EnvVariables() map[string]string {
+	vars := map[string]string{}
+	for name, field := range envFields() {
+		vars[name] = field.key
+	}
+	return vars
+}
+
+// koanfKey returns the configuration key of the struct field, or an empty string when it has none
+func koanfKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("koanf"), ",")
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// envSupported returns if values of the type can be parsed from an environment variable
+func envSupported(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Uint32:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// envValues returns the configuration set through environment variables, keyed by configuration key.
+// Lists are comma separated.
+func envValues() map[string]interface{} {
+	values := map[string]interface{}{}
+	for name, field := range envFields() {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if !field.list {
+			values[field.key] = value
+			continue
+		}
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		values[field.key] = items
+	}
+	return values
+}
@@ -0,0 +1,131 @@
+// Package config implements all configuration aspects of KoboMail
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// tableKeys returns the configuration keys of the struct type and the type of their values.
+// Squashed structs contribute their keys to the parent table.
+func tableKeys(t reflect.Type) map[string]reflect.Type {
+	keys := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("koanf")
+		if strings.HasSuffix(tag, ",squash") {
+			for key, keyType := range tableKeys(field.Type) {
+				keys[key] = keyType
+			}
+			continue
+		}
+		if key := koanfKey(field); key != "" {
+			keys[key] = field.Type
+		}
+	}
+	// Accounts are loaded separately, on top of the imap_config section
+	if t == reflect.TypeOf(Config{}) {
+		keys["accounts"] = reflect.TypeOf([]AccountConfig{})
+	}
+	return keys
+}
+
+// unknownKeys returns the keys of the raw configuration that do not match any setting
+func unknownKeys(raw map[string]interface{}, t reflect.Type, prefix string) []string {
+	var unknown []string
+	known := tableKeys(t)
+	for key, value := range raw {
+		fullKey := prefix + key
+		keyType, ok := known[key]
+		if !ok {
+			unknown = append(unknown, fullKey)
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if keyType.Kind() == reflect.Struct {
+				unknown = append(unknown, unknownKeys(v, keyType, fullKey+".")...)
+			}
+		case []interface{}:
+			if keyType.Kind() != reflect.Slice || keyType.Elem().Kind() != reflect.Struct {
+				continue
+			}
+			for i, item := range v {
+				if table, ok := item.(map[string]interface{}); ok {
+					unknown = append(unknown, unknownKeys(table, keyType.Elem(), fmt.Sprintf("%s[%d].", fullKey, i))...)
+				}
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// allKeyNames returns the names of all settings in any table
+func allKeyNames(t reflect.Type, names map[string]bool) {
+	for key, keyType := range tableKeys(t) {
+		if names[key] {
+			continue
+		}
+		names[key] = true
+		if keyType.Kind() == reflect.Slice {
+			keyType = keyType.Elem()
+		}
+		if keyType.Kind() == reflect.Struct {
+			allKeyNames(keyType, names)
+		}
+	}
+}
+
+// suggestKey returns the unknown key with its last part replaced by the closest known setting,
+// or an empty string when no setting is close enough
+func suggestKey(key string) string {
+	prefix, name := "", key
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		prefix, name = key[:i+1], key[i+1:]
+	}
+
+	names := map[string]bool{}
+	allKeyNames(reflect.TypeOf(Config{}), names)
+
+	best, bestDistance := "", 3
+	for candidate := range names {
+		distance := levenshtein(strings.ToLower(name), candidate)
+		if distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return prefix + best
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -3,10 +3,13 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 
 	toml "github.com/knadh/koanf/parsers/toml"
 	"github.com/knadh/koanf/providers/confmap"
-	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/providers/posflag"
 	"github.com/knadh/koanf/v2"
 	flag "github.com/spf13/pflag"
@@ -14,8 +17,17 @@ import (
 
 // Set default paths
 const (
+	InstallPath        = "/usr/local/kobomail"
+	CACertificatesPath = "/etc/ssl/certs/ca-certificates.crt"
 	DefaultAddonPath   = "/mnt/onboard/.adds/kobomail"
 	DefaultLibraryPath = "/mnt/onboard/KoboMailLibrary"
+	DefaultConfigFile  = DefaultAddonPath + "/kobomail_cfg.toml"
+	DefaultLogFile     = DefaultAddonPath + "/kobomail.log"
+	DefaultStateFile   = DefaultAddonPath + "/kobomail_state.json"
+	ImportPath         = DefaultAddonPath + "/import"
+	ImportDonePath     = ImportPath + "/done"
+	CredentialsFile    = InstallPath + "/credentials.json"
+	CredentialsKeyFile = InstallPath + "/credentials.key"
 )
 
 type sensitiveString string
@@ -29,23 +41,112 @@ func (s sensitiveString) MarshalJSON() ([]byte, error) {
 
 // Config config struct
 type Config struct {
-	IMAPConfig        imapConfigSection        `koanf:"imap_config" validate:"required"`
-	ProcessingConfig  processingConfigSection  `koanf:"processing_config" validate:"required"`
-	ApplicationConfig applicationConfigSection `koanf:"application_config" validate:"required"`
+	ConfigVersion     int                      `koanf:"config_version"`
+	IMAPConfig        imapConfigSection        `koanf:"imap_config"`
+	ProcessingConfig  processingConfigSection  `koanf:"processing_config"`
+	ApplicationConfig applicationConfigSection `koanf:"application_config"`
+	Accounts          []AccountConfig          `koanf:"-"`
+	Rules             []RuleConfig             `koanf:"rules"`
+	WebDAV            []WebDAVConfig           `koanf:"webdav"`
+	OPDS              []OPDSConfig             `koanf:"opds"`
+	Feeds             []FeedConfig             `koanf:"feeds"`
+	FeedsConfig       feedsConfigSection       `koanf:"feeds_config"`
+	SMTPConfig        smtpConfigSection        `koanf:"smtp_config"`
+	AnnotationsConfig annotationsConfigSection `koanf:"annotations_config"`
 	k                 *koanf.Koanf
+	// migrated is set when the configuration file uses an older layout, it was migrated while loading
+	migrated bool
+	// unknownKeys are the keys in the TOML config that do not match any setting
+	unknownKeys []string
+	// passwordErrors are the IMAP password sources that failed
+	passwordErrors ValidationErrors
 }
 
 type imapConfigSection struct {
-	IMAPHost      string          `koanf:"imap_host"`
-	IMAPPort      int             `koanf:"imap_port"`
-	IMAPUser      string          `koanf:"imap_user"`
-	IMAPPwd       sensitiveString `koanf:"imap_pwd"`
-	IMAPFolder    string          `koanf:"imap_folder"`
-	EmailFlagType EmailFlagType   `koanf:"email_flag_type" validate:"required|in:plus,subject"`
-	EmailFlag     string          `koanf:"email_flag"`
-	EmailUnseen   bool            `koanf:"email_unseen"`
+	Protocol          MailProtocol    `koanf:"protocol"`
+	IMAPHost          string          `koanf:"imap_host"`
+	IMAPPort          int             `koanf:"imap_port"`
+	IMAPUser          string          `koanf:"imap_user"`
+	IMAPPwd           sensitiveString `koanf:"imap_pwd"`
+	IMAPPwdFile       string          `koanf:"imap_pwd_file"`
+	IMAPPwdEnv        string          `koanf:"imap_pwd_env"`
+	IMAPPwdCommand    string          `koanf:"imap_pwd_command"`
+	IMAPPwdEncrypted  bool            `koanf:"imap_pwd_encrypted"`
+	IMAPFolder        string          `koanf:"imap_folder"`
+	IMAPFolders       []FolderConfig  `koanf:"imap_folders"`
+	EmailFlagType     EmailFlagType   `koanf:"email_flag_type"`
+	EmailFlag         string          `koanf:"email_flag"`
+	EmailUnseen       bool            `koanf:"email_unseen"`
+	ProcessedKeyword  string          `koanf:"processed_keyword"`
+	FailedFolder      string          `koanf:"failed_folder"`
+	Search            SearchConfig    `koanf:"search"`
+	POP3Security      string          `koanf:"pop3_security"`
+	POP3LeaveOnServer bool            `koanf:"pop3_leave_on_server"`
+	JMAPSessionURL    string          `koanf:"jmap_session_url"`
+	JMAPAuth          string          `koanf:"jmap_auth"`
 }
 
+// AccountConfig is a single mail account processed by KoboMail.
+// Any IMAP setting not set on the account is inherited from the imap_config section.
+type AccountConfig struct {
+	Name             string            `koanf:"name"`
+	IMAPConfig       imapConfigSection `koanf:",squash"`
+	LibrarySubfolder string            `koanf:"library_subfolder"`
+}
+
+// FolderConfig is an IMAP folder processed by KoboMail.
+// The name can contain the IMAP wildcards * and % to match multiple folders.
+type FolderConfig struct {
+	Name             string   `koanf:"name"`
+	LibrarySubfolder string   `koanf:"library_subfolder"`
+	Filetypes        []string `koanf:"filetypes"`
+}
+
+// WebDAVConfig is a folder on a WebDAV server, like a Nextcloud folder, KoboMail downloads new ebooks from.
+// Downloaded files are tracked by their ETag, a file is downloaded again when it changes.
+type WebDAVConfig struct {
+	Name             string          `koanf:"name"`
+	URL              string          `koanf:"url"`
+	User             string          `koanf:"user"`
+	Password         sensitiveString `koanf:"password"`
+	LibrarySubfolder string          `koanf:"library_subfolder"`
+	Filetypes        []string        `koanf:"filetypes"`
+	// Delete or MoveTo remove downloaded files from the remote folder, MoveTo is relative to the folder
+	Delete bool   `koanf:"delete"`
+	MoveTo string `koanf:"move_to"`
+}
+
+// OPDSConfig is an OPDS catalog feed, like the ones of Calibre-Web, Kavita and Komga, KoboMail downloads new ebooks from.
+// Downloaded entries are tracked by their ID, an entry is never downloaded twice.
+type OPDSConfig struct {
+	Name     string          `koanf:"name"`
+	URL      string          `koanf:"url"`
+	User     string          `koanf:"user"`
+	Password sensitiveString `koanf:"password"`
+	// Shelf is a navigation entry of the feed to download from, nested entries are separated by a slash
+	Shelf            string   `koanf:"shelf"`
+	LibrarySubfolder string   `koanf:"library_subfolder"`
+	Filetypes        []string `koanf:"filetypes"`
+	// DownloadExisting downloads the entries already in the feed on the first run, instead of only remembering them
+	DownloadExisting bool `koanf:"download_existing"`
+}
+
+// FeedConfig is an RSS or Atom feed of which the new articles are added to the daily digest
+type FeedConfig struct {
+	Name string `koanf:"name"`
+	URL  string `koanf:"url"`
+}
+
+// MailProtocol enum
+type MailProtocol string
+
+// MailProtocol enum values
+const (
+	MailProtocolIMAP MailProtocol = "imap"
+	MailProtocolPOP3 MailProtocol = "pop3"
+	MailProtocolJMAP MailProtocol = "jmap"
+)
+
 // EmailFlagType enum
 type EmailFlagType string
 
@@ -55,6 +156,34 @@ const (
 	EmailFlagTypeSubject EmailFlagType = "subject"
 )
 
+// RuleConfig routes attachments, rules are evaluated in order and the first matching rule wins.
+// Empty conditions match everything, From, To and Subject are regular expressions.
+type RuleConfig struct {
+	Name       string     `koanf:"name"`
+	From       string     `koanf:"from"`
+	To         string     `koanf:"to"`
+	Subject    string     `koanf:"subject"`
+	Extensions []string   `koanf:"extensions"`
+	MIMETypes  []string   `koanf:"mime_types"`
+	MinSize    int        `koanf:"min_size"`
+	MaxSize    int        `koanf:"max_size"`
+	Action     RuleAction `koanf:"action"`
+	SaveTo     string     `koanf:"save_to"`
+	// RenameToKepub saves epub files as .kepub.epub, Nickel then opens them with the Kobo renderer
+	RenameToKepub bool   `koanf:"rename_to_kepub"`
+	Collection    string `koanf:"collection"`
+	MoveTo        string `koanf:"move_to"`
+}
+
+// RuleAction enum
+type RuleAction string
+
+// RuleAction enum values
+const (
+	RuleActionSave RuleAction = "save"
+	RuleActionSkip RuleAction = "skip"
+)
+
 type processingConfigSection struct {
 	EmailDelete bool     `koanf:"email_delete"`
 	Filetypes   []string `koanf:"filetypes"`
@@ -66,14 +195,56 @@ type applicationConfigSection struct {
 	CreateNickelMenuEntry bool   `koanf:"create_nickelmenu_entry"`
 	RunOnWifiConnect      bool   `koanf:"run_on_wifi_connect"`
 	ShowNotifications     bool   `koanf:"show_notifications"`
-	ConfigPath            string `koanf:"config_path" validate:"ValidateFolder"`
-	LibraryPath           string `koanf:"library_path" validate:"ValidateFolder"`
+	ConfigPath            string `koanf:"config_path"`
+	LibraryPath           string `koanf:"library_path"`
 	LogFile               string `koanf:"logfile"`
-	LogFormat             string `koanf:"logformat" validate:"in:console,json"`
-	LogLevel              string `koanf:"loglevel" validate:"ValidateLogLevel"`
+	LogFormat             string `koanf:"logformat"`
+	LogLevel              string `koanf:"loglevel"`
+}
+
+type smtpConfigSection struct {
+	SMTPHost string          `koanf:"smtp_host"`
+	SMTPPort int             `koanf:"smtp_port"`
+	SMTPUser string          `koanf:"smtp_user"`
+	SMTPPwd  sensitiveString `koanf:"smtp_pwd"`
+	SMTPFrom string          `koanf:"smtp_from"`
+}
+
+// AnnotationsFormat enum
+type AnnotationsFormat string
+
+// AnnotationsFormat enum values
+const (
+	AnnotationsFormatMarkdown AnnotationsFormat = "markdown"
+	AnnotationsFormatHTML     AnnotationsFormat = "html"
+)
+
+type annotationsConfigSection struct {
+	ExportOnRun  bool              `koanf:"export_on_run"`
+	ExportTo     string            `koanf:"export_to"`
+	ExportFormat AnnotationsFormat `koanf:"export_format"`
+}
+
+// feedsConfigSection configures the daily digest of the [[feeds]]
+type feedsConfigSection struct {
+	DigestTitle      string `koanf:"digest_title"`
+	LibrarySubfolder string `koanf:"library_subfolder"`
+	MaxItems         int    `koanf:"max_items"`
+	Images           bool   `koanf:"images"`
+	FetchArticles    bool   `koanf:"fetch_articles"`
+}
+
+// flagKeys maps command line flags to the configuration key they override
+var flagKeys = map[string]string{
+	"library-path": "application_config.library_path",
+	"log-file":     "application_config.logfile",
+	"log-level":    "application_config.loglevel",
+	"log-format":   "application_config.logformat",
 }
 
-// LoadConfig instantiates a new Config
+// LoadConfig instantiates a new Config.
+// Settings are applied in order of precedence: defaults, the TOML config, KOBOMAIL_ environment variables
+// and finally command line flags.
 func LoadConfig(flags *flag.FlagSet) (*Config, error) {
 	var err error
 	var k = koanf.New(".")
@@ -85,6 +256,13 @@ func LoadConfig(flags *flag.FlagSet) (*Config, error) {
 
 	// Defaults
 	err = k.Load(confmap.Provider(map[string]interface{}{
+		"config_version": CurrentConfigVersion,
+		"imap_config": map[string]interface{}{
+			"protocol":             string(MailProtocolIMAP),
+			"pop3_security":        "tls",
+			"pop3_leave_on_server": true,
+			"jmap_auth":            "basic",
+		},
 		"application_config": map[string]interface{}{
 			"create_nickelmenu_entry": true,
 			"library_path":            DefaultLibraryPath,
@@ -94,30 +272,76 @@ func LoadConfig(flags *flag.FlagSet) (*Config, error) {
 			"email_delete": false,
 			"full_rescan":  false,
 		},
+		"smtp_config": map[string]interface{}{
+			"smtp_port": 587,
+		},
+		"annotations_config": map[string]interface{}{
+			"export_on_run": false,
+			"export_format": string(AnnotationsFormatMarkdown),
+		},
+		"feeds_config": map[string]interface{}{
+			"digest_title":   "KoboMail Digest",
+			"max_items":      20,
+			"images":         true,
+			"fetch_articles": true,
+		},
 	}, ""), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// TOML Config
+	// Flag defaults, these are overridden by the TOML config and the environment
+	flagValues := map[string]interface{}{}
+	flagOverrides := map[string]interface{}{}
+	for name, key := range flagKeys {
+		f := flags.Lookup(name)
+		if f == nil {
+			continue
+		}
+		if f.Changed {
+			flagOverrides[key] = f.Value.String()
+		} else {
+			flagValues[key] = f.Value.String()
+		}
+	}
+	if err = k.Load(confmap.Provider(flagValues, "."), nil); err != nil {
+		return nil, err
+	}
+
+	// TOML Config, older layouts are migrated in memory, the file is left untouched
 	tomlConfig := k.String("config")
+	var migrated bool
+	var unknown []string
 	if tomlConfig != "" {
-		err = k.Load(file.Provider(tomlConfig), toml.Parser())
+		content, err := os.ReadFile(tomlConfig)
 		if err != nil {
 			return nil, err
 		}
+		raw, err := toml.Parser().Unmarshal(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", tomlConfig, err)
+		}
+		raw, migrated, err = migrateConfig(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate %s: %w", tomlConfig, err)
+		}
+		tk := koanf.New(".")
+		if err = tk.Load(confmap.Provider(raw, ""), nil); err != nil {
+			return nil, err
+		}
+		unknown = unknownKeys(tk.Raw(), reflect.TypeOf(Config{}), "")
+		if err = k.Merge(tk); err != nil {
+			return nil, err
+		}
 	}
 
-	// Flag overrides
-	err = k.Load(confmap.Provider(map[string]interface{}{
-		"application_config": map[string]interface{}{
-			"library_path": k.String("library-path"),
-			"logfile":      k.String("log-file"),
-			"loglevel":     k.String("log-level"),
-			"logformat":    k.String("log-format"),
-		},
-	}, ""), nil)
-	if err != nil {
+	// Environment overrides
+	if err = k.Load(confmap.Provider(envValues(), "."), nil); err != nil {
+		return nil, err
+	}
+
+	// Flag overrides, only flags set on the command line
+	if err = k.Load(confmap.Provider(flagOverrides, "."), nil); err != nil {
 		return nil, err
 	}
 
@@ -127,6 +351,165 @@ func LoadConfig(flags *flag.FlagSet) (*Config, error) {
 		return nil, err
 	}
 
+	out.Accounts, err = loadAccounts(k)
+	if err != nil {
+		return nil, err
+	}
+
 	out.k = k
+	out.migrated = migrated
+	out.unknownKeys = unknown
 	return &out, nil
 }
+
+// NeedsMigration returns if the configuration file uses an older layout.
+// The configuration was migrated while loading, MigrateConfigFile writes the migrated file.
+func (c *Config) NeedsMigration() bool {
+	return c.migrated
+}
+
+// loadAccounts reads the [[accounts]] tables, using the imap_config section as defaults for every account
+func loadAccounts(k *koanf.Koanf) ([]AccountConfig, error) {
+	rawAccounts, ok := k.Get("accounts").([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	var accounts []AccountConfig
+	for i, rawAccount := range rawAccounts {
+		accountMap, ok := rawAccount.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("accounts[%d] must be a table", i)
+		}
+
+		// A password source set on the account replaces the one from imap_config
+		defaults := k.Cut("imap_config").Raw()
+		for _, key := range passwordKeys {
+			if _, ok := accountMap[key]; ok {
+				for _, key := range passwordKeys {
+					delete(defaults, key)
+				}
+				break
+			}
+		}
+
+		ak := koanf.New(".")
+		if err := ak.Load(confmap.Provider(defaults, ""), nil); err != nil {
+			return nil, err
+		}
+		if err := ak.Load(confmap.Provider(accountMap, ""), nil); err != nil {
+			return nil, err
+		}
+
+		var account AccountConfig
+		if err := ak.Unmarshal("", &account); err != nil {
+			return nil, fmt.Errorf("accounts[%d]: %w", i, err)
+		}
+		if account.Name == "" {
+			account.Name = account.IMAPConfig.IMAPUser
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// configured returns if the section sets up a mail server, configurations that only download from WebDAV,
+// OPDS or feeds leave the imap_config section empty
+func (s imapConfigSection) configured() bool {
+	return s.IMAPHost != "" || s.IMAPUser != "" || s.JMAPSessionURL != "" || len(s.passwordSources()) > 0
+}
+
+// HasMailAccounts returns if a mail account is configured
+func (c *Config) HasMailAccounts() bool {
+	return len(c.Accounts) > 0 || c.IMAPConfig.configured()
+}
+
+// hasOtherSources returns if ebooks are downloaded from WebDAV folders, OPDS catalogs or feeds
+func (c *Config) hasOtherSources() bool {
+	return len(c.WebDAV) > 0 || len(c.OPDS) > 0 || len(c.Feeds) > 0
+}
+
+// MailAccounts returns all accounts KoboMail should process.
+// When no [[accounts]] are configured the imap_config section is the only account, if it is configured.
+func (c *Config) MailAccounts() []AccountConfig {
+	if len(c.Accounts) > 0 {
+		return c.Accounts
+	}
+	if !c.IMAPConfig.configured() {
+		return nil
+	}
+	return []AccountConfig{{
+		Name:       c.IMAPConfig.IMAPUser,
+		IMAPConfig: c.IMAPConfig,
+	}}
+}
+
+// AccountLibraryPath returns the folder attachments from the given account are saved to
+func (c *Config) AccountLibraryPath(account AccountConfig) string {
+	if account.LibrarySubfolder == "" {
+		return c.ApplicationConfig.LibraryPath
+	}
+	return filepath.Join(c.ApplicationConfig.LibraryPath, account.LibrarySubfolder)
+}
+
+// MailFolders returns all IMAP folders of the account KoboMail should process.
+// When no imap_folders are configured imap_folder is the only folder.
+func (a AccountConfig) MailFolders() []FolderConfig {
+	if len(a.IMAPConfig.IMAPFolders) > 0 {
+		return a.IMAPConfig.IMAPFolders
+	}
+	return []FolderConfig{{Name: a.IMAPConfig.IMAPFolder}}
+}
+
+// JMAPSessionURL returns the URL of the JMAP session resource of the account.
+// When jmap_session_url is not configured the well-known URL on imap_host is used.
+func (a AccountConfig) JMAPSessionURL() string {
+	if a.IMAPConfig.JMAPSessionURL != "" {
+		return a.IMAPConfig.JMAPSessionURL
+	}
+	return "https://" + a.IMAPConfig.IMAPHost + "/.well-known/jmap"
+}
+
+// FolderLibraryPath returns the folder attachments from the given account and IMAP folder are saved to
+func (c *Config) FolderLibraryPath(account AccountConfig, folder FolderConfig) string {
+	return filepath.Join(c.AccountLibraryPath(account), folder.LibrarySubfolder)
+}
+
+// WebDAVLibraryPath returns the folder files downloaded from the given WebDAV folder are saved to
+func (c *Config) WebDAVLibraryPath(folder WebDAVConfig) string {
+	return filepath.Join(c.ApplicationConfig.LibraryPath, folder.LibrarySubfolder)
+}
+
+// WebDAVFiletypes returns the filetypes that are downloaded from the given WebDAV folder
+func (c *Config) WebDAVFiletypes(folder WebDAVConfig) []string {
+	if len(folder.Filetypes) > 0 {
+		return folder.Filetypes
+	}
+	return c.ProcessingConfig.Filetypes
+}
+
+// OPDSLibraryPath returns the folder ebooks downloaded from the given OPDS feed are saved to
+func (c *Config) OPDSLibraryPath(feed OPDSConfig) string {
+	return filepath.Join(c.ApplicationConfig.LibraryPath, feed.LibrarySubfolder)
+}
+
+// OPDSFiletypes returns the filetypes that are downloaded from the given OPDS feed, in order of preference
+func (c *Config) OPDSFiletypes(feed OPDSConfig) []string {
+	if len(feed.Filetypes) > 0 {
+		return feed.Filetypes
+	}
+	return c.ProcessingConfig.Filetypes
+}
+
+// DigestLibraryPath returns the folder the daily digest of the feeds is saved to
+func (c *Config) DigestLibraryPath() string {
+	return filepath.Join(c.ApplicationConfig.LibraryPath, c.FeedsConfig.LibrarySubfolder)
+}
+
+// FolderFiletypes returns the filetypes that are downloaded from the given IMAP folder
+func (c *Config) FolderFiletypes(folder FolderConfig) []string {
+	if len(folder.Filetypes) > 0 {
+		return folder.Filetypes
+	}
+	return c.ProcessingConfig.Filetypes
+}
@@ -0,0 +1,206 @@
+// Package config implements all configuration aspects of KoboMail
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	toml "github.com/knadh/koanf/parsers/toml"
+)
+
+// CurrentConfigVersion is the version of the configuration layout written by this KoboMail version
+const CurrentConfigVersion = 1
+
+// migration converts a configuration from the previous version to the next one
+type migration func(map[string]interface{}) (map[string]interface{}, error)
+
+// migrations holds the migration from every configuration version to the next one
+var migrations = map[int]migration{
+	0: migrateLegacyLayout,
+}
+
+// legacyKeys maps the keys of the pre 0.4.0 layout to their current section.
+// That layout used the IMAPConfig, ExecutionType and Kepubify sections and stored every value as a string.
+var legacyKeys = map[string]string{
+	"imap_host":               "imap_config",
+	"imap_port":               "imap_config",
+	"imap_user":               "imap_config",
+	"imap_pwd":                "imap_config",
+	"imap_folder":             "imap_config",
+	"email_flag_type":         "imap_config",
+	"email_flag":              "imap_config",
+	"email_unseen":            "imap_config",
+	"email_delete":            "processing_config",
+	"filetypes":               "processing_config",
+	"full_rescan":             "processing_config",
+	"kepubify":                "processing_config",
+	"create_nickelmenu_entry": "application_config",
+	"run_on_wifi_connect":     "application_config",
+	"show_notifications":      "application_config",
+	"library_path":            "application_config",
+}
+
+// legacyRenamedKeys maps keys of the pre 0.4.0 layout that were renamed
+var legacyRenamedKeys = map[string]string{
+	"execute_on_wifi": "run_on_wifi_connect",
+}
+
+// configVersion returns the version of the configuration layout.
+// Configurations without config_version are either the pre 0.4.0 layout (0) or the current layout (1).
+func configVersion(conf map[string]interface{}) (int, error) {
+	if raw, ok := conf["config_version"]; ok {
+		version, ok := raw.(int64)
+		if !ok {
+			return 0, fmt.Errorf("config_version must be a number")
+		}
+		return int(version), nil
+	}
+	if _, ok := conf["imap_config"]; ok {
+		return 1, nil
+	}
+	for _, section := range []string{"IMAPConfig", "ExecutionType", "Kepubify"} {
+		if _, ok := conf[section]; ok {
+			return 0, nil
+		}
+	}
+	return CurrentConfigVersion, nil
+}
+
+// migrateConfig converts a configuration in an older layout to the current layout and returns if it was migrated
+func migrateConfig(conf map[string]interface{}) (map[string]interface{}, bool, error) {
+	version, err := configVersion(conf)
+	if err != nil {
+		return nil, false, err
+	}
+	if version > CurrentConfigVersion {
+		return nil, false, fmt.Errorf("config_version %d is not supported, the configuration was written by a newer KoboMail version", version)
+	}
+	if version == CurrentConfigVersion {
+		return conf, false, nil
+	}
+
+	for ; version < CurrentConfigVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, false, fmt.Errorf("no migration from config_version %d", version)
+		}
+		if conf, err = migrate(conf); err != nil {
+			return nil, false, fmt.Errorf("failed to migrate configuration from config_version %d: %w", version, err)
+		}
+	}
+	conf["config_version"] = CurrentConfigVersion
+	return conf, true, nil
+}
+
+// backupPath returns a path next to the configuration file that is not used yet, so no earlier backup is overwritten
+func backupPath(path string) string {
+	backup := path + ".bak"
+	for i := 1; helpers.FileExists(backup); i++ {
+		backup = fmt.Sprintf("%s.bak.%d", path, i)
+	}
+	return backup
+}
+
+// MigrateConfigFile rewrites a configuration file in an older layout in the current layout.
+// Comments in the file are not kept, the original file is kept next to it with a .bak extension.
+// The path of this backup is returned when the file was migrated.
+func MigrateConfigFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	parser := toml.Parser()
+	conf, err := parser.Unmarshal(content)
+	if err != nil {
+		return "", err
+	}
+	conf, migrated, err := migrateConfig(conf)
+	if err != nil || !migrated {
+		return "", err
+	}
+	out, err := parser.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+
+	backup := backupPath(path)
+	if err := os.WriteFile(backup, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to back up configuration: %w", err)
+	}
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		return "", fmt.Errorf("failed to write migrated configuration: %w", err)
+	}
+	return backup, nil
+}
+
+// migrateLegacyLayout moves the keys of the pre 0.4.0 layout to their current section and converts their values
+func migrateLegacyLayout(conf map[string]interface{}) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	for _, rawSection := range conf {
+		section, ok := rawSection.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range section {
+			if renamed, ok := legacyRenamedKeys[key]; ok {
+				key = renamed
+			}
+			target, ok := legacyKeys[key]
+			if !ok {
+				continue
+			}
+			converted, err := convertLegacyValue(key, value)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := out[target]; !ok {
+				out[target] = map[string]interface{}{}
+			}
+			out[target].(map[string]interface{})[key] = converted
+		}
+	}
+
+	// The IMAP folder could not be configured before 0.4.0, KoboMail always processed the inbox
+	if _, ok := out["imap_config"]; !ok {
+		out["imap_config"] = map[string]interface{}{}
+	}
+	imapConfig := out["imap_config"].(map[string]interface{})
+	if _, ok := imapConfig["imap_folder"]; !ok {
+		imapConfig["imap_folder"] = "INBOX"
+	}
+	return out, nil
+}
+
+// convertLegacyValue converts the string values of the pre 0.4.0 layout to their proper type
+func convertLegacyValue(key string, value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	switch key {
+	case "imap_port":
+		port, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number: %w", key, err)
+		}
+		return port, nil
+	case "email_unseen", "email_delete", "full_rescan", "kepubify", "create_nickelmenu_entry", "run_on_wifi_connect", "show_notifications":
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false: %w", key, err)
+		}
+		return b, nil
+	case "filetypes":
+		filetypes := []interface{}{}
+		for _, filetype := range strings.Split(s, ",") {
+			if filetype = strings.TrimSpace(filetype); filetype != "" {
+				filetypes = append(filetypes, filetype)
+			}
+		}
+		return filetypes, nil
+	}
+	return s, nil
+}
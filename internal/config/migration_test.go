@@ -0,0 +1,155 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	toml "github.com/knadh/koanf/parsers/toml"
+)
+
+const legacyConfig = `
+[IMAPConfig]
+imap_host = "imap.gmail.com"
+imap_port = "993"
+imap_user = "user@gmail.com"
+imap_pwd = "secret"
+email_flag_type = "plus"
+email_flag = "kobo"
+email_unseen = "true"
+email_delete = "False"
+filetypes = "epub, kepub,,pdf"
+
+[ExecutionType]
+execute_on_wifi = "true"
+create_nickelmenu_entry = "false"
+unknown_setting = "dropped"
+
+[Kepubify]
+kepubify = "true"
+`
+
+func parseTOML(t *testing.T, content string) map[string]interface{} {
+	t.Helper()
+	conf, err := toml.Parser().Unmarshal([]byte(content))
+	if err != nil {
+		t.Fatalf("invalid TOML: %v", err)
+	}
+	return conf
+}
+
+func TestMigrateLegacyLayout(t *testing.T) {
+	got, err := migrateLegacyLayout(parseTOML(t, legacyConfig))
+	if err != nil {
+		t.Fatalf("migrateLegacyLayout: %v", err)
+	}
+	want := map[string]interface{}{
+		"imap_config": map[string]interface{}{
+			"imap_host":       "imap.gmail.com",
+			"imap_port":       993,
+			"imap_user":       "user@gmail.com",
+			"imap_pwd":        "secret",
+			"imap_folder":     "INBOX",
+			"email_flag_type": "plus",
+			"email_flag":      "kobo",
+			"email_unseen":    true,
+		},
+		"processing_config": map[string]interface{}{
+			"email_delete": false,
+			"filetypes":    []interface{}{"epub", "kepub", "pdf"},
+			"kepubify":     true,
+		},
+		"application_config": map[string]interface{}{
+			"run_on_wifi_connect":     true,
+			"create_nickelmenu_entry": false,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("migrateLegacyLayout()\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestMigrateLegacyLayoutInvalidValues(t *testing.T) {
+	for _, content := range []string{
+		"[IMAPConfig]\nimap_port = \"imaps\"",
+		"[IMAPConfig]\nemail_unseen = \"maybe\"",
+	} {
+		if _, err := migrateLegacyLayout(parseTOML(t, content)); err == nil {
+			t.Errorf("migrateLegacyLayout(%q) succeeded, want an error", content)
+		}
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		wantMigrated bool
+		wantErr      bool
+	}{
+		{name: "legacy layout", content: legacyConfig, wantMigrated: true},
+		{name: "current layout without version", content: "[imap_config]\nimap_host = \"imap.gmail.com\""},
+		{name: "current version", content: "config_version = 1\n[imap_config]\nimap_host = \"imap.gmail.com\""},
+		{name: "empty", content: ""},
+		{name: "newer version", content: "config_version = 2", wantErr: true},
+		{name: "invalid version", content: `config_version = "one"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, migrated, err := migrateConfig(parseTOML(t, tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("migrateConfig succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("migrateConfig: %v", err)
+			}
+			if migrated != tt.wantMigrated {
+				t.Errorf("migrateConfig() migrated = %v, want %v", migrated, tt.wantMigrated)
+			}
+			if migrated && conf["config_version"] != CurrentConfigVersion {
+				t.Errorf("config_version = %v, want %d", conf["config_version"], CurrentConfigVersion)
+			}
+		})
+	}
+}
+
+func TestMigrateConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kobomail_cfg.toml")
+	if err := os.WriteFile(path, []byte(legacyConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// An earlier backup is never overwritten
+	if err := os.WriteFile(path+".bak", []byte("earlier backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := MigrateConfigFile(path)
+	if err != nil {
+		t.Fatalf("MigrateConfigFile: %v", err)
+	}
+	if backup != path+".bak.1" {
+		t.Errorf("backup = %q, want %q", backup, path+".bak.1")
+	}
+	if content, _ := os.ReadFile(backup); string(content) != legacyConfig {
+		t.Errorf("backup does not hold the original configuration: %q", content)
+	}
+	if content, _ := os.ReadFile(path + ".bak"); string(content) != "earlier backup" {
+		t.Errorf("earlier backup was overwritten: %q", content)
+	}
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), "config_version = 1") || !strings.Contains(string(content), "[imap_config]") {
+		t.Errorf("migrated configuration is not in the current layout:\n%s", content)
+	}
+
+	// A configuration in the current layout is left alone
+	backup, err = MigrateConfigFile(path)
+	if err != nil || backup != "" {
+		t.Errorf("MigrateConfigFile() on a current configuration = %q, %v, want no migration", backup, err)
+	}
+}
@@ -0,0 +1,117 @@
+// Package config implements all configuration aspects of KoboMail
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// schemaEnums lists the allowed values of settings that are not an enum type
+var schemaEnums = map[string][]string{
+	"application_config.logformat": {"console", "json"},
+	"application_config.loglevel":  {"debug", "info", "warn", "error", "dpanic", "panic", "fatal"},
+	"pop3_security":                {"tls", "starttls"},
+	"jmap_auth":                    {"basic", "bearer"},
+}
+
+// itemEnums lists the allowed values of the items of list settings
+var itemEnums = map[string][]string{
+	"filetypes": KnownFiletypes,
+}
+
+// typeEnums lists the allowed values of the enum types
+var typeEnums = map[reflect.Type][]string{
+	reflect.TypeOf(MailProtocol("")):      {string(MailProtocolIMAP), string(MailProtocolPOP3), string(MailProtocolJMAP)},
+	reflect.TypeOf(EmailFlagType("")):     {string(EmailFlagTypePlus), string(EmailFlagTypeSubject)},
+	reflect.TypeOf(RuleAction("")):        {string(RuleActionSave), string(RuleActionSkip)},
+	reflect.TypeOf(AnnotationsFormat("")): {string(AnnotationsFormatMarkdown), string(AnnotationsFormatHTML)},
+}
+
+// schemaGenerator builds the JSON Schema, recursive tables like search are described once in $defs
+type schemaGenerator struct {
+	visiting  map[reflect.Type]bool
+	recursive map[reflect.Type]bool
+	defs      map[string]interface{}
+}
+
+// JSONSchema returns a JSON Schema describing the TOML configuration, editors can use it for completion and validation
+func JSONSchema() map[string]interface{} {
+	g := &schemaGenerator{
+		visiting:  map[reflect.Type]bool{},
+		recursive: map[reflect.Type]bool{},
+		defs:      map[string]interface{}{},
+	}
+	schema := g.object(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = "https://github.com/bjw-s/KoboMail/kobomail_cfg.schema.json"
+	schema["title"] = "KoboMail configuration"
+	if len(g.defs) > 0 {
+		schema["$defs"] = g.defs
+	}
+	return schema
+}
+
+// schemaRef returns a reference to the definition of the type in $defs
+func schemaRef(t reflect.Type) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+}
+
+// object describes the table of the struct type
+func (g *schemaGenerator) object(t reflect.Type, path string) map[string]interface{} {
+	if g.visiting[t] {
+		g.recursive[t] = true
+		return schemaRef(t)
+	}
+	g.visiting[t] = true
+	defer delete(g.visiting, t)
+
+	properties := map[string]interface{}{}
+	for key, keyType := range tableKeys(t) {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		property := g.schemaType(keyType, keyPath)
+		if values, ok := schemaEnums[keyPath]; ok {
+			property["enum"] = values
+		} else if values, ok := schemaEnums[key]; ok {
+			property["enum"] = values
+		}
+		properties[key] = property
+	}
+	object := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if g.recursive[t] {
+		g.defs[t.Name()] = object
+		return schemaRef(t)
+	}
+	return object
+}
+
+// schemaType describes a value of the given type
+func (g *schemaGenerator) schemaType(t reflect.Type, path string) map[string]interface{} {
+	if values, ok := typeEnums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": values}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return g.object(t, path)
+	case reflect.Slice:
+		items := g.schemaType(t.Elem(), path)
+		if values, ok := itemEnums[path[strings.LastIndex(path, ".")+1:]]; ok {
+			items["enum"] = values
+		}
+		return map[string]interface{}{"type": "array", "items": items}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	}
+	return map[string]interface{}{"type": "string"}
+}
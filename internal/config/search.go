@@ -0,0 +1,70 @@
+// Package config implements all configuration aspects of KoboMail
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchDateLayout is the layout of absolute dates in the search configuration
+const SearchDateLayout = "2006-01-02"
+
+// SearchConfig narrows down the emails KoboMail processes, on top of the email_flag criterium.
+// All criteria set must match, unless they are listed under Or in which case one of the alternatives must match.
+type SearchConfig struct {
+	From            string         `koanf:"from"`
+	To              string         `koanf:"to"`
+	Subject         string         `koanf:"subject"`
+	Since           string         `koanf:"since"`
+	Before          string         `koanf:"before"`
+	Larger          uint32         `koanf:"larger"`
+	Smaller         uint32         `koanf:"smaller"`
+	Keywords        []string       `koanf:"keywords"`
+	WithoutKeywords []string       `koanf:"without_keywords"`
+	Or              []SearchConfig `koanf:"or"`
+	// GmailRaw and GmailLabels are only used when the server supports the Gmail IMAP extensions,
+	// they cannot be combined using Or
+	GmailRaw    string   `koanf:"gmail_raw"`
+	GmailLabels []string `koanf:"gmail_labels"`
+}
+
+// ParseSearchDate parses a search date, either an absolute date like 2023-08-01
+// or a number of days before now like 30d
+func ParseSearchDate(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid relative date %q, expected a number of days like 30d", value)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	t, err := time.Parse(SearchDateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or a number of days like 30d", value)
+	}
+	return t, nil
+}
+
+// validateSearch checks the search configuration and all its alternatives
+func validateSearch(errs *ValidationErrors, key string, search SearchConfig) {
+	for _, field := range []struct{ key, value string }{{"since", search.Since}, {"before", search.Before}} {
+		if field.value == "" {
+			continue
+		}
+		if _, err := ParseSearchDate(field.value, time.Now()); err != nil {
+			errs.add(key+"."+field.key, err.Error(), `use a date like "2023-08-01" or a number of days like "30d"`)
+		}
+	}
+	if search.Larger > 0 && search.Smaller > 0 && search.Larger >= search.Smaller {
+		errs.add(key+".smaller", "must be greater than larger", "")
+	}
+	for i, alternative := range search.Or {
+		alternativeKey := fmt.Sprintf("%s.or[%d]", key, i)
+		if alternative.GmailRaw != "" || len(alternative.GmailLabels) > 0 {
+			errs.add(alternativeKey+".gmail_raw", "gmail_raw and gmail_labels cannot be combined using or", "move them to "+key)
+		}
+		validateSearch(errs, alternativeKey, alternative)
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSearchDate(t *testing.T) {
+	now := time.Date(2023, 8, 31, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2023-08-01", want: time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "30d", want: time.Date(2023, 8, 1, 12, 30, 0, 0, time.UTC)},
+		{value: "0d", want: now},
+		{value: "-1d", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "1w", wantErr: true},
+		{value: "2023-02-30", wantErr: true},
+		{value: "01-08-2023", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSearchDate(tt.value, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSearchDate(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseSearchDate(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateSearch(t *testing.T) {
+	search := SearchConfig{
+		Since:   "last week",
+		Larger:  1000,
+		Smaller: 100,
+		Or: []SearchConfig{
+			{From: "alice@example.org"},
+			{Before: "2023-13-01", GmailRaw: "has:attachment"},
+		},
+	}
+	errs := ValidationErrors{}
+	validateSearch(&errs, "imap_config.search", search)
+
+	want := []string{
+		"imap_config.search.since",
+		"imap_config.search.smaller",
+		"imap_config.search.or[1].gmail_raw",
+		"imap_config.search.or[1].before",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("validateSearch() returned %d errors, want %d: %v", len(errs), len(want), errs)
+	}
+	for i, key := range want {
+		if errs[i].Key != key {
+			t.Errorf("error %d is about %s, want %s", i, errs[i].Key, key)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package config implements all configuration aspects of KoboMail
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/pkg/credentials"
+)
+
+// passwordCommandTimeout limits how long imap_pwd_command can take
+const passwordCommandTimeout = 30 * time.Second
+
+// passwordKeys are the configuration keys that provide the IMAP password
+var passwordKeys = []string{"imap_pwd", "imap_pwd_file", "imap_pwd_env", "imap_pwd_command", "imap_pwd_encrypted"}
+
+// passwordSources returns the configuration keys used to provide the IMAP password
+func (s imapConfigSection) passwordSources() []string {
+	var sources []string
+	if s.IMAPPwd != "" {
+		sources = append(sources, "imap_pwd")
+	}
+	if s.IMAPPwdFile != "" {
+		sources = append(sources, "imap_pwd_file")
+	}
+	if s.IMAPPwdEnv != "" {
+		sources = append(sources, "imap_pwd_env")
+	}
+	if s.IMAPPwdCommand != "" {
+		sources = append(sources, "imap_pwd_command")
+	}
+	if s.IMAPPwdEncrypted {
+		sources = append(sources, "imap_pwd_encrypted")
+	}
+	return sources
+}
+
+// passwordHints explain how to fix a password source that failed
+var passwordHints = map[string]string{
+	"imap_pwd":         "keep only one way to provide the password",
+	"imap_pwd_file":    "check that the file exists and is readable",
+	"imap_pwd_env":     "set the environment variable before running KoboMail",
+	"imap_pwd_command": "run the command manually to check that it prints the password",
+}
+
+// passwordHint explains how to fix the password source of the account that failed
+func passwordHint(source string, name string) string {
+	if source == "imap_pwd_encrypted" {
+		return "store the password with: kobomail credentials set " + name
+	}
+	return passwordHints[source]
+}
+
+// ResolvePasswords sets the IMAP password of imap_config and every account from the configured source.
+// Only commands connecting to a server call it, so a password command does not run for every command.
+// Failures are reported by Validate, so the configuration can still be inspected and corrected.
+func (c *Config) ResolvePasswords() {
+	var store *credentials.Store
+	loadStore := func() (*credentials.Store, error) {
+		if store != nil {
+			return store, nil
+		}
+		var err error
+		store, err = credentials.Load(CredentialsFile, CredentialsKeyFile)
+		return store, err
+	}
+
+	if source, err := resolvePassword(&c.IMAPConfig, c.IMAPConfig.IMAPUser, loadStore); err != nil && len(c.Accounts) == 0 && c.IMAPConfig.configured() {
+		c.passwordErrors.add("imap_config."+source, err.Error(), passwordHint(source, c.IMAPConfig.IMAPUser))
+	}
+	for i := range c.Accounts {
+		account := &c.Accounts[i]
+		if source, err := resolvePassword(&account.IMAPConfig, account.Name, loadStore); err != nil {
+			c.passwordErrors.add(fmt.Sprintf("accounts[%d].%s", i, source), err.Error(), passwordHint(source, account.Name))
+		}
+	}
+}
+
+// resolvePassword reads the password from the file, environment variable, command or encrypted store.
+// On failure the key of the failing source is returned with the error.
+func resolvePassword(s *imapConfigSection, name string, loadStore func() (*credentials.Store, error)) (string, error) {
+	if sources := s.passwordSources(); len(sources) > 1 {
+		return "imap_pwd", fmt.Errorf("only one of %s can be set, found %s", strings.Join(passwordKeys, ", "), strings.Join(sources, ", "))
+	}
+
+	switch {
+	case s.IMAPPwdFile != "":
+		content, err := os.ReadFile(s.IMAPPwdFile)
+		if err != nil {
+			return "imap_pwd_file", fmt.Errorf("failed to read the password file: %w", err)
+		}
+		s.IMAPPwd = sensitiveString(strings.TrimRight(string(content), "\r\n"))
+
+	case s.IMAPPwdEnv != "":
+		pwd, ok := os.LookupEnv(s.IMAPPwdEnv)
+		if !ok {
+			return "imap_pwd_env", fmt.Errorf("environment variable %s is not set", s.IMAPPwdEnv)
+		}
+		s.IMAPPwd = sensitiveString(pwd)
+
+	case s.IMAPPwdCommand != "":
+		ctx, cancel := context.WithTimeout(context.Background(), passwordCommandTimeout)
+		defer cancel()
+		output, err := exec.CommandContext(ctx, "/bin/sh", "-c", s.IMAPPwdCommand).Output()
+		if err != nil {
+			return "imap_pwd_command", fmt.Errorf("password command failed: %w", err)
+		}
+		s.IMAPPwd = sensitiveString(strings.TrimRight(string(output), "\r\n"))
+
+	case s.IMAPPwdEncrypted:
+		store, err := loadStore()
+		if err != nil {
+			return "imap_pwd_encrypted", fmt.Errorf("failed to load credential store: %w", err)
+		}
+		pwd, found, err := store.Get(name)
+		if err != nil {
+			return "imap_pwd_encrypted", err
+		}
+		if !found {
+			return "imap_pwd_encrypted", fmt.Errorf("no encrypted password stored for %s", name)
+		}
+		s.IMAPPwd = sensitiveString(pwd)
+	}
+	return "", nil
+}
@@ -0,0 +1,12 @@
+// Package config implements all configuration aspects of KoboMail
+package config
+
+import (
+	// Needed to embed the configuration template
+	_ "embed"
+)
+
+// ConfigTemplate is the commented configuration file deployed on first boot
+//
+//go:embed kobomail_cfg.toml.tmpl
+var ConfigTemplate []byte
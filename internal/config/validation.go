@@ -2,14 +2,62 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
 	"github.com/bjw-s/kobomail/pkg/helpers"
-	"github.com/gookit/validate"
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/exp/slices"
+	"golang.org/x/sys/unix"
 )
 
-// ValidateLogLevel validates that the log level is one of the valid log levels
-func (c Config) ValidateLogLevel(val string) bool {
+// KnownFiletypes are the filetypes the Kobo can open
+var KnownFiletypes = []string{
+	"epub", "kepub", "pdf", "mobi", "txt", "rtf", "html", "htm",
+	"cbz", "cbr", "jpg", "jpeg", "png", "gif", "bmp", "tif", "tiff",
+}
+
+// ValidationError is a single invalid setting, identified by its TOML key
+type ValidationError struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Hint == "" {
+		return e.Key + ": " + e.Message
+	}
+	return e.Key + ": " + e.Message + " (" + e.Hint + ")"
+}
+
+// ValidationErrors lists all invalid settings of a configuration
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := []string{fmt.Sprintf("the configuration contains %d error(s):", len(e))}
+	for _, err := range e {
+		lines = append(lines, "  "+err.Key+": "+err.Message)
+		if err.Hint != "" {
+			lines = append(lines, "    hint: "+err.Hint)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *ValidationErrors) add(key string, message string, hint string) {
+	*e = append(*e, ValidationError{Key: key, Message: message, Hint: hint})
+}
+
+// validLogLevel returns if the log level is one of the zap log levels
+func validLogLevel(val string) bool {
 	validLogLevels := []string{}
 	for i := zapcore.DebugLevel; i < zapcore.InvalidLevel; i++ {
 		validLogLevels = append(validLogLevels, i.String())
@@ -17,21 +65,339 @@ func (c Config) ValidateLogLevel(val string) bool {
 	return slices.Contains(validLogLevels, val)
 }
 
-// ValidateFolder validates that the path is a valid folder
-func (c Config) ValidateFolder(val string) bool {
-	return helpers.FolderExists(val)
+// validKeyword returns if the keyword is a valid IMAP keyword, an empty keyword is valid
+func validKeyword(keyword string) bool {
+	return !strings.ContainsAny(keyword, " (){%*\"\\]")
+}
+
+// validPort returns if the port is a valid TCP port
+func validPort(port int) bool {
+	return port > 0 && port < 65536
+}
+
+// Validate returns all invalid settings of the configuration, or nil when it is valid
+func (c *Config) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	for _, key := range c.unknownKeys {
+		hint := "remove it or check the spelling"
+		if suggestion := suggestKey(key); suggestion != "" {
+			hint = "did you mean " + suggestion + "?"
+		}
+		errs.add(key, "unknown setting", hint)
+	}
+
+	errs = append(errs, c.passwordErrors...)
+
+	// Every account inherits the imap_config section, when accounts are configured only they are used.
+	// A configuration downloading only from WebDAV, OPDS or feeds does not need a mail account.
+	if len(c.Accounts) == 0 && (c.IMAPConfig.configured() || !c.hasOtherSources()) {
+		validateIMAP(&errs, "imap_config", c.IMAPConfig)
+	}
+	names := map[string]bool{}
+	for i, account := range c.Accounts {
+		key := fmt.Sprintf("accounts[%d]", i)
+		validateIMAP(&errs, key, account.IMAPConfig)
+		// The processed history is kept per account name, accounts sharing a name would skip each other's emails
+		if names[account.Name] {
+			errs.add(key+".name", fmt.Sprintf("%q is used by another account", account.Name),
+				"every account needs a unique name, it defaults to imap_user")
+		}
+		names[account.Name] = true
+		if account.LibrarySubfolder != "" && !filepath.IsLocal(account.LibrarySubfolder) {
+			errs.add(key+".library_subfolder", "must be a relative path inside library_path", "")
+		}
+	}
+
+	validateFiletypes(&errs, "processing_config.filetypes", c.ProcessingConfig.Filetypes)
+	if len(c.ProcessingConfig.Filetypes) == 0 {
+		errs.add("processing_config.filetypes", "no filetypes configured, no attachment would be downloaded",
+			`for example filetypes = ["epub", "kepub"]`)
+	}
+
+	validateApplication(&errs, c.ApplicationConfig)
+	validateSMTP(&errs, c.SMTPConfig)
+	validateAnnotations(&errs, c.AnnotationsConfig, c.SMTPConfig, c.HasMailAccounts())
+	validateRules(&errs, c.Rules)
+	validateWebDAV(&errs, c.WebDAV)
+	validateOPDS(&errs, c.OPDS)
+	validateFeeds(&errs, c.Feeds, c.FeedsConfig)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateIMAP checks the IMAP settings of imap_config or an account
+func validateIMAP(errs *ValidationErrors, section string, s imapConfigSection) {
+	// JMAP accounts can point to their session resource instead of the host
+	if s.IMAPHost == "" && (s.Protocol != MailProtocolJMAP || s.JMAPSessionURL == "") {
+		errs.add(section+".imap_host", "must not be empty", "set the host name of your IMAP server, for example imap.gmail.com")
+	}
+	switch s.Protocol {
+	case MailProtocolIMAP:
+		if !validPort(s.IMAPPort) {
+			errs.add(section+".imap_port", fmt.Sprintf("%d is not a valid port", s.IMAPPort), "IMAP over TLS uses port 993")
+		}
+	case MailProtocolPOP3:
+		if !validPort(s.IMAPPort) || s.IMAPPort == 993 {
+			errs.add(section+".imap_port", fmt.Sprintf("%d is not a valid POP3 port", s.IMAPPort), "POP3 uses port 995 for TLS or 110 for STARTTLS")
+		}
+		if s.POP3Security != "tls" && s.POP3Security != "starttls" {
+			errs.add(section+".pop3_security", fmt.Sprintf("%q is not supported", s.POP3Security), "must be one of: tls, starttls")
+		}
+	case MailProtocolJMAP:
+		// JMAP runs over HTTPS, imap_port is not used
+		if s.JMAPSessionURL != "" {
+			if u, err := url.Parse(s.JMAPSessionURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				errs.add(section+".jmap_session_url", fmt.Sprintf("%q is not a valid URL", s.JMAPSessionURL), "for example https://api.fastmail.com/jmap/session")
+			}
+		}
+		if s.JMAPAuth != "basic" && s.JMAPAuth != "bearer" {
+			errs.add(section+".jmap_auth", fmt.Sprintf("%q is not supported", s.JMAPAuth), "must be one of: basic, bearer")
+		}
+	default:
+		errs.add(section+".protocol", fmt.Sprintf("%q is not supported", s.Protocol), "must be one of: imap, pop3, jmap")
+	}
+	if s.IMAPUser == "" {
+		errs.add(section+".imap_user", "must not be empty", "set the user name of your mailbox, usually your email address")
+	}
+
+	if len(s.passwordSources()) == 0 {
+		errs.add(section+".imap_pwd", "no password configured", "set imap_pwd, imap_pwd_file, imap_pwd_env, imap_pwd_command or imap_pwd_encrypted")
+	}
+
+	// POP3 servers have a single maildrop, the folders are ignored
+	if s.Protocol != MailProtocolPOP3 && len(s.IMAPFolders) == 0 && s.IMAPFolder == "" {
+		errs.add(section+".imap_folder", "must not be empty", `set the folder to process, for example imap_folder = "INBOX"`)
+	}
+	for i, folder := range s.IMAPFolders {
+		key := fmt.Sprintf("%s.imap_folders[%d]", section, i)
+		if folder.Name == "" {
+			errs.add(key+".name", "must not be empty", "set the name of the IMAP folder, wildcards like Books/* are allowed")
+		}
+		if folder.LibrarySubfolder != "" && !filepath.IsLocal(folder.LibrarySubfolder) {
+			errs.add(key+".library_subfolder", "must be a relative path inside library_path", "")
+		}
+		validateFiletypes(errs, key+".filetypes", folder.Filetypes)
+	}
+
+	switch s.EmailFlagType {
+	case EmailFlagTypePlus:
+		if !strings.Contains(s.IMAPUser, "@") {
+			errs.add(section+".email_flag_type", "plus addressing needs imap_user to be an email address",
+				`use email_flag_type = "subject" or set imap_user to your email address`)
+		}
+	case EmailFlagTypeSubject:
+	default:
+		errs.add(section+".email_flag_type", fmt.Sprintf("%q is not supported", s.EmailFlagType), "must be one of: plus, subject")
+	}
+	if s.EmailFlag == "" {
+		errs.add(section+".email_flag", "must not be empty, every email would be processed",
+			`set the tag emails for your Kobo contain, for example email_flag = "[MyKobo]"`)
+	}
+
+	if !validKeyword(s.ProcessedKeyword) {
+		errs.add(section+".processed_keyword", "must be a single word without spaces, brackets or backslashes",
+			`for example processed_keyword = "$KoboMailDone"`)
+	}
+	if strings.ContainsAny(s.FailedFolder, "*%") {
+		errs.add(section+".failed_folder", "must be a single IMAP folder without wildcards", `for example failed_folder = "KoboMail/Failed"`)
+	}
+	validateSearch(errs, section+".search", s.Search)
+}
+
+// validateFiletypes checks that all filetypes can be opened by the Kobo
+func validateFiletypes(errs *ValidationErrors, key string, filetypes []string) {
+	for i, filetype := range filetypes {
+		if !slices.Contains(KnownFiletypes, filetype) {
+			errs.add(fmt.Sprintf("%s[%d]", key, i), fmt.Sprintf("unknown filetype %q", filetype),
+				"use lower case extensions without a leading dot, one of: "+strings.Join(KnownFiletypes, ", "))
+		}
+	}
+}
+
+// validateApplication checks the application_config section
+func validateApplication(errs *ValidationErrors, s applicationConfigSection) {
+	if s.ConfigPath != "" && !helpers.FolderExists(s.ConfigPath) {
+		errs.add("application_config.config_path", s.ConfigPath+" is not a folder", "create the folder or remove the setting")
+	}
+	if !helpers.FolderExists(s.LibraryPath) {
+		errs.add("application_config.library_path", s.LibraryPath+" is not a folder", "run `kobomail init` or create the folder")
+	}
+
+	if s.LogFile != "" && s.LogFile != "/dev/stdout" {
+		if err := checkWritable(s.LogFile); err != nil {
+			errs.add("application_config.logfile", "cannot write to "+s.LogFile+": "+err.Error(),
+				"make sure the folder exists and is writable, or use /dev/stdout")
+		}
+	}
+	if s.LogFormat != "console" && s.LogFormat != "json" {
+		errs.add("application_config.logformat", fmt.Sprintf("%q is not supported", s.LogFormat), "must be one of: console, json")
+	}
+	if !validLogLevel(s.LogLevel) {
+		errs.add("application_config.loglevel", fmt.Sprintf("%q is not supported", s.LogLevel),
+			"must be one of: debug, info, warn, error, dpanic, panic, fatal")
+	}
+}
+
+// checkWritable returns why the file at path cannot be written, a file that does not exist is not created
+func checkWritable(path string) error {
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("it is a folder")
+		}
+		return unix.Access(path, unix.W_OK)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if !helpers.FolderExists(dir) {
+		return fmt.Errorf("folder %s does not exist", dir)
+	}
+	return unix.Access(dir, unix.W_OK)
 }
 
-// Validate returns if the given configuration is valid and any validation errors
-func (c *Config) Validate() validate.Errors {
-	v := validate.Struct(c)
-	v.StopOnError = false
-	return v.ValidateE()
+// validateSMTP checks the smtp_config section, it is only used when smtp_host is set
+func validateSMTP(errs *ValidationErrors, s smtpConfigSection) {
+	if s.SMTPHost == "" {
+		return
+	}
+	if !validPort(s.SMTPPort) {
+		errs.add("smtp_config.smtp_port", fmt.Sprintf("%d is not a valid port", s.SMTPPort), "SMTP uses port 587 for STARTTLS or 465 for TLS")
+	}
+	if s.SMTPFrom != "" {
+		if _, err := mail.ParseAddress(s.SMTPFrom); err != nil {
+			errs.add("smtp_config.smtp_from", fmt.Sprintf("%q is not a valid email address", s.SMTPFrom), "")
+		}
+	}
 }
 
-func (c Config) Messages() map[string]string {
-	return validate.MS{
-		"ValidateFolder": "{field} must point to a valid folder.",
-		"ApplicationConfig.LogLevel.ValidateLogLevel": "Log Level must be one of: debug, info, warn, error, dpanic, panic, fatal",
+// validateAnnotations checks the annotations_config section
+func validateAnnotations(errs *ValidationErrors, s annotationsConfigSection, smtp smtpConfigSection, hasMailAccounts bool) {
+	if s.ExportFormat != AnnotationsFormatMarkdown && s.ExportFormat != AnnotationsFormatHTML {
+		errs.add("annotations_config.export_format", fmt.Sprintf("%q is not supported", s.ExportFormat), "must be one of: markdown, html")
+	}
+	if s.ExportOnRun && smtp.SMTPHost == "" {
+		errs.add("annotations_config.export_on_run", "exporting highlights needs an SMTP server", "set smtp_host in the smtp_config section")
+	}
+	// The addresses default to the user of the first mail account
+	if s.ExportOnRun && !hasMailAccounts {
+		if smtp.SMTPFrom == "" {
+			errs.add("smtp_config.smtp_from", "must be set when no mail account is configured", "set the address the highlights are sent from")
+		}
+		if s.ExportTo == "" {
+			errs.add("annotations_config.export_to", "must be set when no mail account is configured", "set the address the highlights are sent to")
+		}
+	}
+	if s.ExportTo != "" {
+		if _, err := mail.ParseAddress(s.ExportTo); err != nil {
+			errs.add("annotations_config.export_to", fmt.Sprintf("%q is not a valid email address", s.ExportTo), "")
+		}
+	}
+}
+
+// validateRules checks every [[rules]] table
+func validateRules(errs *ValidationErrors, rules []RuleConfig) {
+	for i, rule := range rules {
+		key := fmt.Sprintf("rules[%d]", i)
+		if rule.Action != "" && rule.Action != RuleActionSave && rule.Action != RuleActionSkip {
+			errs.add(key+".action", fmt.Sprintf("%q is not supported", rule.Action), "must be one of: save, skip")
+		}
+		for _, field := range []struct{ key, expr string }{{"from", rule.From}, {"to", rule.To}, {"subject", rule.Subject}} {
+			if _, err := regexp.Compile(field.expr); err != nil {
+				errs.add(key+"."+field.key, "not a valid regular expression: "+err.Error(), `escape special characters like [ and ( with a backslash, written as \\ in TOML strings`)
+			}
+		}
+		if rule.MinSize < 0 || rule.MaxSize < 0 {
+			errs.add(key+".min_size", "sizes cannot be negative", "")
+		} else if rule.MaxSize > 0 && rule.MinSize > rule.MaxSize {
+			errs.add(key+".max_size", "must be larger than min_size", "")
+		}
+		if rule.SaveTo != "" && !filepath.IsLocal(rule.SaveTo) {
+			errs.add(key+".save_to", "must be a relative path inside library_path", "")
+		}
+	}
+}
+
+// validateWebDAV checks every [[webdav]] table
+func validateWebDAV(errs *ValidationErrors, folders []WebDAVConfig) {
+	names := map[string]bool{}
+	for i, folder := range folders {
+		key := fmt.Sprintf("webdav[%d]", i)
+		if folder.Name == "" {
+			errs.add(key+".name", "must not be empty", "the name identifies the downloaded files in the state file")
+		} else if names[folder.Name] {
+			errs.add(key+".name", fmt.Sprintf("%q is used by another webdav folder", folder.Name), "every webdav folder needs a unique name")
+		}
+		names[folder.Name] = true
+
+		if u, err := url.Parse(folder.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs.add(key+".url", fmt.Sprintf("%q is not a valid URL", folder.URL),
+				"for Nextcloud use https://<host>/remote.php/dav/files/<user>/<folder>")
+		}
+		if folder.LibrarySubfolder != "" && !filepath.IsLocal(folder.LibrarySubfolder) {
+			errs.add(key+".library_subfolder", "must be a relative path inside library_path", "")
+		}
+		validateFiletypes(errs, key+".filetypes", folder.Filetypes)
+		if folder.Delete && folder.MoveTo != "" {
+			errs.add(key+".move_to", "cannot be combined with delete", "either delete the downloaded files or move them")
+		}
+		if folder.MoveTo != "" && !filepath.IsLocal(folder.MoveTo) {
+			errs.add(key+".move_to", "must be a relative path inside the webdav folder", `for example move_to = "Done"`)
+		}
+	}
+}
+
+// validateOPDS checks every [[opds]] table
+func validateOPDS(errs *ValidationErrors, feeds []OPDSConfig) {
+	names := map[string]bool{}
+	for i, feed := range feeds {
+		key := fmt.Sprintf("opds[%d]", i)
+		if feed.Name == "" {
+			errs.add(key+".name", "must not be empty", "the name identifies the downloaded entries in the state file")
+		} else if names[feed.Name] {
+			errs.add(key+".name", fmt.Sprintf("%q is used by another opds feed", feed.Name), "every opds feed needs a unique name")
+		}
+		names[feed.Name] = true
+
+		if u, err := url.Parse(feed.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs.add(key+".url", fmt.Sprintf("%q is not a valid URL", feed.URL), "for Calibre-Web use https://<host>/opds")
+		}
+		if feed.LibrarySubfolder != "" && !filepath.IsLocal(feed.LibrarySubfolder) {
+			errs.add(key+".library_subfolder", "must be a relative path inside library_path", "")
+		}
+		validateFiletypes(errs, key+".filetypes", feed.Filetypes)
+	}
+}
+
+// validateFeeds checks every [[feeds]] table and the feeds_config section
+func validateFeeds(errs *ValidationErrors, feeds []FeedConfig, s feedsConfigSection) {
+	names := map[string]bool{}
+	for i, feed := range feeds {
+		key := fmt.Sprintf("feeds[%d]", i)
+		if feed.Name == "" {
+			errs.add(key+".name", "must not be empty", "the name is the title of the section of the feed in the digest")
+		} else if names[feed.Name] {
+			errs.add(key+".name", fmt.Sprintf("%q is used by another feed", feed.Name), "every feed needs a unique name")
+		}
+		names[feed.Name] = true
+
+		if u, err := url.Parse(feed.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs.add(key+".url", fmt.Sprintf("%q is not a valid URL", feed.URL), "use the address of the RSS or Atom feed")
+		}
+	}
+
+	if strings.TrimSpace(s.DigestTitle) == "" {
+		errs.add("feeds_config.digest_title", "must not be empty", "")
+	}
+	if s.MaxItems < 1 {
+		errs.add("feeds_config.max_items", "must be at least 1", "")
+	}
+	if s.LibrarySubfolder != "" && !filepath.IsLocal(s.LibrarySubfolder) {
+		errs.add("feeds_config.library_subfolder", "must be a relative path inside library_path", "")
 	}
 }
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateAccounts(t *testing.T) {
+	account := func(name string, host string, subfolder string) AccountConfig {
+		a := AccountConfig{Name: name, LibrarySubfolder: subfolder}
+		a.IMAPConfig.IMAPHost = host
+		a.IMAPConfig.IMAPUser = "kobo@example.org"
+		return a
+	}
+	c := &Config{Accounts: []AccountConfig{
+		account("kobo@example.org", "imap.example.org", "../../books"),
+		account("kobo@example.org", "imap.example.com", "Work"),
+		account("other", "imap.example.com", "/mnt/onboard"),
+	}}
+
+	keys := map[string]bool{}
+	for _, err := range c.Validate() {
+		keys[err.Key] = true
+	}
+	for key, want := range map[string]bool{
+		"accounts[0].name":              false,
+		"accounts[1].name":              true,
+		"accounts[2].name":              false,
+		"accounts[0].library_subfolder": true,
+		"accounts[1].library_subfolder": false,
+		"accounts[2].library_subfolder": true,
+	} {
+		if keys[key] != want {
+			t.Errorf("Validate() reported %s = %v, want %v", key, keys[key], want)
+		}
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.log")
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "kobomail.log")
+
+	for path, wantErr := range map[string]bool{
+		existing: false,
+		missing:  false,
+		dir:      true,
+		filepath.Join(dir, "logs", "kobomail.log"): true,
+	} {
+		if err := checkWritable(path); (err != nil) != wantErr {
+			t.Errorf("checkWritable(%s) = %v, want error %v", path, err, wantErr)
+		}
+	}
+	if _, err := os.Stat(missing); err == nil {
+		t.Error("checkWritable() created the log file")
+	}
+}
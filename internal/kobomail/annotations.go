@@ -0,0 +1,235 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/koboreader"
+	"github.com/bjw-s/kobomail/pkg/smtp"
+	"github.com/bjw-s/kobomail/pkg/state"
+	"github.com/emersion/go-message/mail"
+	"go.uber.org/zap"
+)
+
+const annotationsLastExportKey = "annotations_last_export"
+
+type annotatedBook struct {
+	Title      string
+	Author     string
+	Highlights []koboreader.Highlight
+}
+
+const annotationsMarkdownTemplate = `# KoboMail reading notes
+{{ range . }}
+## {{ .Title }}{{ if .Author }} - {{ .Author }}{{ end }}
+{{ range .Highlights }}{{ if .Text }}
+> {{ quote .Text }}
+{{ end }}{{ if .Annotation }}
+**Note:** {{ .Annotation }}
+{{ end }}
+_{{ .DateCreated }}_
+{{ end }}{{ end }}`
+
+const annotationsHTMLTemplate = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>KoboMail reading notes</title></head>
+<body>
+<h1>KoboMail reading notes</h1>
+{{ range . }}<h2>{{ .Title }}{{ if .Author }} - {{ .Author }}{{ end }}</h2>
+{{ range .Highlights }}<div>
+{{ if .Text }}<blockquote>{{ .Text }}</blockquote>
+{{ end }}{{ if .Annotation }}<p><strong>Note:</strong> {{ .Annotation }}</p>
+{{ end }}<p><small>{{ .DateCreated }}</small></p>
+</div>
+{{ end }}{{ end }}</body>
+</html>
+`
+
+// groupHighlightsByBook groups the highlights per book, keeping the order they were returned in
+func groupHighlightsByBook(highlights []koboreader.Highlight) []*annotatedBook {
+	var books []*annotatedBook
+	index := map[string]*annotatedBook{}
+	for _, h := range highlights {
+		book, ok := index[h.VolumeID]
+		if !ok {
+			title := h.BookTitle
+			if title == "" {
+				title = h.VolumeID
+			}
+			book = &annotatedBook{Title: title, Author: h.BookAuthor}
+			index[h.VolumeID] = book
+			books = append(books, book)
+		}
+		book.Highlights = append(book.Highlights, h)
+	}
+	return books
+}
+
+func renderAnnotations(books []*annotatedBook, format config.AnnotationsFormat) (string, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case config.AnnotationsFormatHTML:
+		tmpl := htmltemplate.Must(htmltemplate.New("annotations").Parse(annotationsHTMLTemplate))
+		err = tmpl.Execute(&buf, books)
+	default:
+		tmpl := template.Must(template.New("annotations").Funcs(template.FuncMap{
+			"quote": func(s string) string { return strings.ReplaceAll(strings.TrimSpace(s), "\n", "\n> ") },
+		}).Parse(annotationsMarkdownTemplate))
+		err = tmpl.Execute(&buf, books)
+	}
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func buildAnnotationsMessage(from string, to string, body string, format config.AnnotationsFormat) ([]byte, error) {
+	var h mail.Header
+	h.SetDate(time.Now())
+	h.SetAddressList("From", []*mail.Address{{Name: "KoboMail", Address: from}})
+	h.SetAddressList("To", []*mail.Address{{Address: to}})
+	h.SetSubject("KoboMail reading notes " + time.Now().Format("2006-01-02"))
+
+	contentType := "text/plain"
+	if format == config.AnnotationsFormatHTML {
+		contentType = "text/html"
+	}
+	h.SetContentType(contentType, map[string]string{"charset": "utf-8"})
+
+	var buf bytes.Buffer
+	w, err := mail.CreateSingleInlineWriter(&buf, h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sendAnnotations(msg []byte, from string, to string) error {
+	logger := zap.S()
+	smtpConfig := KoboMailConfig.SMTPConfig
+
+	// Fall back to the credentials of the first account, most providers use the same ones for SMTP
+	user := smtpConfig.SMTPUser
+	pwd := string(smtpConfig.SMTPPwd)
+	if accounts := KoboMailConfig.MailAccounts(); len(accounts) > 0 {
+		if user == "" {
+			user = accounts[0].IMAPConfig.IMAPUser
+		}
+		if pwd == "" {
+			pwd = string(accounts[0].IMAPConfig.IMAPPwd)
+		}
+	}
+
+	smtpConnection, err := smtp.ConnectToServer(smtpConfig.SMTPHost, smtpConfig.SMTPPort)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s:%v: %w", smtpConfig.SMTPHost, smtpConfig.SMTPPort, err)
+	}
+	defer smtpConnection.Logout()
+	logger.Infow(
+		"Connected to SMTP server",
+		zap.String("host", smtpConfig.SMTPHost),
+		zap.Int("port", smtpConfig.SMTPPort),
+	)
+
+	if err := smtpConnection.Login(user, pwd); err != nil {
+		return fmt.Errorf("failed to authenticate to SMTP server: %w", err)
+	}
+	logger.Infow("Authenticated to SMTP server", zap.String("user", user))
+
+	return smtpConnection.Send(from, []string{to}, msg)
+}
+
+// ExportAnnotations sends all highlights and notes created since the last export by email.
+// When all is set every highlight is exported, regardless of the previous export.
+func ExportAnnotations(all bool) (int, error) {
+	logger := zap.S()
+	annotationsConfig := KoboMailConfig.AnnotationsConfig
+
+	if KoboMailConfig.SMTPConfig.SMTPHost == "" {
+		return 0, fmt.Errorf("no smtp_host configured, cannot export annotations")
+	}
+
+	stateStore, err := state.Load(config.DefaultStateFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	var lastExport string
+	if !all {
+		if _, err := stateStore.Get(annotationsLastExportKey, &lastExport); err != nil {
+			return 0, fmt.Errorf("failed to read last export timestamp: %w", err)
+		}
+	}
+
+	db, err := koboreader.Open()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open Kobo library database: %w", err)
+	}
+	defer db.Close()
+
+	highlights, err := db.Highlights(lastExport)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read highlights: %w", err)
+	}
+	logger.Infow("Collected highlights", zap.Int("number_of_highlights", len(highlights)), zap.String("since", lastExport))
+	if len(highlights) == 0 {
+		return 0, nil
+	}
+
+	body, err := renderAnnotations(groupHighlightsByBook(highlights), annotationsConfig.ExportFormat)
+	if err != nil {
+		return 0, fmt.Errorf("failed to render highlights: %w", err)
+	}
+
+	// Without a mail account the highlights need smtp_from and export_to, validation makes sure they are set
+	var user string
+	if accounts := KoboMailConfig.MailAccounts(); len(accounts) > 0 {
+		user = accounts[0].IMAPConfig.IMAPUser
+	}
+	from := KoboMailConfig.SMTPConfig.SMTPFrom
+	if from == "" {
+		from = user
+	}
+	to := annotationsConfig.ExportTo
+	if to == "" {
+		to = user
+	}
+
+	msg, err := buildAnnotationsMessage(from, to, body, annotationsConfig.ExportFormat)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build message: %w", err)
+	}
+	if err := sendAnnotations(msg, from, to); err != nil {
+		return 0, err
+	}
+	logger.Infow("Sent highlights", zap.String("to", to), zap.Int("number_of_highlights", len(highlights)))
+
+	// Remember the newest exported highlight so the next export only contains new ones
+	newest := lastExport
+	for _, h := range highlights {
+		if h.DateCreated > newest {
+			newest = h.DateCreated
+		}
+	}
+	if err := stateStore.Set(annotationsLastExportKey, newest); err != nil {
+		return 0, err
+	}
+	if err := stateStore.Save(); err != nil {
+		return 0, fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return len(highlights), nil
+}
@@ -0,0 +1,95 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bjw-s/kobomail/pkg/koboreader"
+	"go.uber.org/zap"
+)
+
+// filenameReplacer replaces the characters the FAT filesystem of the Kobo does not allow in filenames
+var filenameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", `"`, "_", "<", "_", ">", "_", "|", "_")
+
+// saveAttachment writes the attachment to the path decided by its plan.
+// The content is streamed to a hidden temporary file, which is renamed once it is complete,
+// so an interrupted download never leaves a broken book in the library.
+func saveAttachment(plan attachmentPlan) error {
+	dir := filepath.Dir(plan.Path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	content, err := plan.Attachment.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	tmp, err := os.CreateTemp(dir, ".kobomail-*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), plan.Path)
+}
+
+func printAttachmentPlan(out io.Writer, plan attachmentPlan) {
+	ruleSuffix := ""
+	if plan.Rule != "" {
+		ruleSuffix = fmt.Sprintf(" (rule %s)", plan.Rule)
+	}
+	if plan.Skip {
+		fmt.Fprintf(out, "  would skip %s%s\n", plan.Attachment.Filename, ruleSuffix)
+		return
+	}
+	fmt.Fprintf(out, "  would save %s%s\n", plan.Path, ruleSuffix)
+	if plan.Collection != "" {
+		fmt.Fprintf(out, "  would add %s to collection %s\n", plan.Path, plan.Collection)
+	}
+}
+
+// addToCollections adds the saved books to their collections in the Nickel library.
+// The library database can only be changed while Nickel is not running, so collections are only
+// updated when KoboMail runs from KOReader. NickelDbus has no way to change collections.
+func addToCollections(collections map[string][]string) {
+	logger := zap.S()
+	if len(collections) == 0 {
+		return
+	}
+	if koboreader.NickelRunning() {
+		logger.Warnw("Nickel is running, books are only added to their collections when KoboMail runs from KOReader", zap.Int("number_of_collections", len(collections)))
+		return
+	}
+	db, err := koboreader.OpenReadWrite()
+	if err != nil {
+		logger.Errorw("Could not open Kobo library database to update collections", zap.Error(err))
+		return
+	}
+	defer db.Close()
+
+	for collection, paths := range collections {
+		var contentIDs []string
+		for _, path := range paths {
+			contentIDs = append(contentIDs, koboreader.ContentID(path))
+		}
+		if err := db.AddToShelf(collection, contentIDs); err != nil {
+			logger.Errorw("Could not add books to collection", zap.String("collection", collection), zap.Error(err))
+			continue
+		}
+		logger.Infow("Added books to collection", zap.String("collection", collection), zap.Int("number_of_books", len(paths)))
+	}
+}
@@ -0,0 +1,247 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/imap"
+	"github.com/bjw-s/kobomail/pkg/pop3"
+)
+
+// ConnectionTestError describes at which stage the connection test failed
+type ConnectionTestError struct {
+	Stage string
+	Err   error
+}
+
+func (e *ConnectionTestError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Stage, e.Err)
+}
+
+func (e *ConnectionTestError) Unwrap() error {
+	return e.Err
+}
+
+func printCertificateChain(out io.Writer, chain []*x509.Certificate) {
+	for i, cert := range chain {
+		expiry := "valid"
+		if time.Now().After(cert.NotAfter) {
+			expiry = "EXPIRED"
+		} else if time.Now().Before(cert.NotBefore) {
+			expiry = "NOT YET VALID"
+		}
+		fmt.Fprintf(out, "       [%d] %s\n", i, cert.Subject.String())
+		fmt.Fprintf(out, "           issuer:  %s\n", cert.Issuer.String())
+		fmt.Fprintf(out, "           expires: %s (%s)\n", cert.NotAfter.Format(time.RFC1123Z), expiry)
+	}
+}
+
+func reportStage(out io.Writer, ok bool, stage string, detail string) {
+	status := " OK "
+	if !ok {
+		status = "FAIL"
+	}
+	fmt.Fprintf(out, "[%s] %s", status, stage)
+	if detail != "" {
+		fmt.Fprintf(out, ": %s", detail)
+	}
+	fmt.Fprintln(out)
+}
+
+// TestConnection performs every step KoboMail takes to talk to the IMAP server of
+// every configured account and reports the outcome of each stage to out.
+func TestConnection(out io.Writer) error {
+	if !KoboMailConfig.HasMailAccounts() {
+		fmt.Fprintln(out, "No mail account configured")
+		return nil
+	}
+	var failed error
+	for _, account := range KoboMailConfig.MailAccounts() {
+		fmt.Fprintf(out, "Account %s\n", account.Name)
+		if err := testAccountConnection(out, account); err != nil && failed == nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+func testAccountConnection(out io.Writer, account config.AccountConfig) error {
+	switch account.IMAPConfig.Protocol {
+	case config.MailProtocolPOP3:
+		return testPOP3Connection(out, account)
+	case config.MailProtocolJMAP:
+		return testJMAPConnection(out, account)
+	}
+	imapConfig := account.IMAPConfig
+	address := fmt.Sprintf("%s:%v", imapConfig.IMAPHost, imapConfig.IMAPPort)
+
+	// Connect and perform the TLS handshake
+	stage := "TLS handshake with " + address
+	imapConnection, err := imap.ConnectToServer(imapConfig.IMAPHost, imapConfig.IMAPPort)
+	if err != nil {
+		reportStage(out, false, stage, err.Error())
+		// Show what the server presented to help diagnose certificate and clock issues
+		if chain, chainErr := imap.PeerCertificates(imapConfig.IMAPHost, imapConfig.IMAPPort); chainErr == nil {
+			fmt.Fprintln(out, "       certificate chain presented by the server (unverified):")
+			printCertificateChain(out, chain)
+		}
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	defer imapConnection.Logout()
+	reportStage(out, true, stage, "")
+	printCertificateChain(out, imapConnection.TLSConnectionState().PeerCertificates)
+
+	stage = "CAPABILITY"
+	capabilities, err := imapConnection.Capabilities()
+	if err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	reportStage(out, true, stage, strings.Join(capabilities, " "))
+
+	stage = "Login as " + imapConfig.IMAPUser
+	if err := imapConnection.Login(imapConfig.IMAPUser, string(imapConfig.IMAPPwd)); err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	reportStage(out, true, stage, "")
+
+	stage = "LIST folders"
+	mailboxes, err := imapConnection.ListMailboxes("*")
+	if err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	reportStage(out, true, stage, strings.Join(mailboxes, ", "))
+
+	stage = "Resolve folders"
+	folders, err := resolveMailFolders(imapConnection, account)
+	if err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	if len(folders) == 0 {
+		err := fmt.Errorf("no folders match the configured imap_folders")
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+
+	// Selecting read-only makes sure the test never changes anything
+	imapConnection.ReadOnly = true
+	for _, folder := range folders {
+		stage = "SELECT " + folder.Name
+		mbox, err := imapConnection.SelectMailbox(folder.Name)
+		if err != nil {
+			reportStage(out, false, stage, err.Error())
+			return &ConnectionTestError{Stage: stage, Err: err}
+		}
+		reportStage(out, true, stage, fmt.Sprintf("%d messages", mbox.Messages))
+
+		stage = "SEARCH " + folder.Name
+		imapConnection.ResetSearchCriteria()
+		applySearchCriteria(imapConnection, account)
+		found, err := imapConnection.SearchMessages()
+		if err != nil {
+			reportStage(out, false, stage, err.Error())
+			return &ConnectionTestError{Stage: stage, Err: err}
+		}
+		reportStage(out, true, stage, fmt.Sprintf("%d messages match the configured criteria", len(found)))
+	}
+
+	return nil
+}
+
+// testPOP3Connection connects and authenticates to a POP3 server and counts the emails carrying the flag
+func testPOP3Connection(out io.Writer, account config.AccountConfig) error {
+	imapConfig := account.IMAPConfig
+	address := fmt.Sprintf("%s:%v", imapConfig.IMAPHost, imapConfig.IMAPPort)
+
+	stage := "Connect to " + address + " using " + imapConfig.POP3Security
+	client, err := pop3.Dial(imapConfig.IMAPHost, imapConfig.IMAPPort, pop3.Security(imapConfig.POP3Security))
+	if err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	defer client.Quit()
+	reportStage(out, true, stage, "")
+
+	stage = "Login as " + imapConfig.IMAPUser
+	if err := client.Login(imapConfig.IMAPUser, string(imapConfig.IMAPPwd)); err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	reportStage(out, true, stage, "")
+
+	// Nothing is deleted, so listing the maildrop never changes anything
+	client.ReadOnly = true
+	stage = "UIDL"
+	messages, err := client.List()
+	if err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	found := 0
+	for _, msg := range messages {
+		if matchesEmailFlag(account, msg) {
+			found++
+		}
+	}
+	reportStage(out, true, stage, fmt.Sprintf("%d messages, %d carry the configured flag", len(messages), found))
+	return nil
+}
+
+// testJMAPConnection fetches the JMAP session, lists the mailboxes and searches the configured folders
+func testJMAPConnection(out io.Writer, account config.AccountConfig) error {
+	stage := "Fetch session " + account.JMAPSessionURL()
+	client, err := connectJMAP(account)
+	if err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	reportStage(out, true, stage, "")
+
+	stage = "Mailbox/get"
+	mailboxes, err := client.Mailboxes()
+	if err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+	var names []string
+	for _, mbox := range mailboxes {
+		names = append(names, mbox.Path)
+	}
+	reportStage(out, true, stage, strings.Join(names, ", "))
+
+	stage = "Resolve folders"
+	folders, err := resolveJMAPFolders(client, account)
+	if err == nil && len(folders) == 0 {
+		err = fmt.Errorf("no folders match the configured imap_folders")
+	}
+	if err != nil {
+		reportStage(out, false, stage, err.Error())
+		return &ConnectionTestError{Stage: stage, Err: err}
+	}
+
+	// Querying never changes anything
+	client.ReadOnly = true
+	client.Filter = jmapFilter(account, time.Now())
+	for _, folder := range folders {
+		stage = "Email/query " + folder.Name
+		if _, err := client.SelectMailbox(folder.Name); err != nil {
+			reportStage(out, false, stage, err.Error())
+			return &ConnectionTestError{Stage: stage, Err: err}
+		}
+		found, err := client.Count()
+		if err != nil {
+			reportStage(out, false, stage, err.Error())
+			return &ConnectionTestError{Stage: stage, Err: err}
+		}
+		reportStage(out, true, stage, fmt.Sprintf("%d messages match the configured criteria", found))
+	}
+	return nil
+}
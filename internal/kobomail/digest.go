@@ -0,0 +1,322 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/article"
+	"github.com/bjw-s/kobomail/pkg/epub"
+	"github.com/bjw-s/kobomail/pkg/feed"
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"go.uber.org/zap"
+)
+
+const feedsStateKey = "feeds"
+
+const (
+	digestRequestTimeout = 60 * time.Second
+	// Some websites refuse requests without a browser-like user agent
+	digestUserAgent = "Mozilla/5.0 (compatible; KoboMail)"
+	// Articles with less text in the feed are only a summary, the full article is fetched from the website
+	minFeedContentLength = 500
+	// Images are limited to keep the digest small enough for the Kobo, every image is written to the digest
+	// as soon as it is downloaded so only one image is kept in memory
+	maxDigestImages = 50
+	maxImageSize    = 1 << 20
+)
+
+// feedsState remembers the items of every feed that were added to a digest
+type feedsState struct {
+	// LastDigest is the date of the last digest, only one digest is built every day
+	LastDigest string `json:"last_digest,omitempty"`
+	// Items maps the name of every feed to the IDs of its items that were seen
+	Items map[string][]string `json:"items,omitempty"`
+}
+
+// runDigest builds the daily digest of the new items of the feeds and saves it to the library.
+// A feed that fails is skipped, its new items are added to the next digest and the first failure is returned.
+func runDigest(rc *runContext) (accountResult, error) {
+	feedsConfig := KoboMailConfig.FeedsConfig
+	logger := zap.S().With(zap.String("digest", feedsConfig.DigestTitle))
+	opts, out := rc.opts, rc.out
+	result := accountResult{account: feedsConfig.DigestTitle}
+
+	var state feedsState
+	if _, err := rc.state.Get(feedsStateKey, &state); err != nil {
+		logger.Warnw("Failed to read the feed items from the state file, adding all items to the digest", zap.Error(err))
+	}
+	today := time.Now().Format("2006-01-02")
+	if state.LastDigest == today {
+		logger.Infow("The digest of today was built already")
+		if opts.DryRun {
+			fmt.Fprintf(out, "Digest %s: built already today\n", feedsConfig.DigestTitle)
+		}
+		return result, nil
+	}
+
+	client := helpers.NewHTTPClient(digestRequestTimeout, digestUserAgent)
+	title := feedsConfig.DigestTitle + " " + today
+	path := filepath.Join(KoboMailConfig.DigestLibraryPath(), filenameReplacer.Replace(title)+".epub")
+	// The digest file is only created once there are new items
+	var digest *digestFile
+	images := &digestImages{client: client, enabled: feedsConfig.Images, added: map[string]string{}, logger: logger}
+	seen := map[string][]string{}
+	var feedErr error
+	for _, feedConfig := range KoboMailConfig.Feeds {
+		feedLogger := logger.With(zap.String("feed", feedConfig.Name))
+		items, ids, err := newFeedItems(client, feedConfig, state.Items[feedConfig.Name], feedsConfig.MaxItems)
+		if err != nil {
+			feedLogger.Errorw("Failed to read feed, continuing with the next one", zap.String("url", feedConfig.URL), zap.Error(err))
+			if feedErr == nil {
+				feedErr = runError(ExitConnectionError, "Failed to read feed "+feedConfig.Name+", please check internet connection", err)
+			}
+			if previous, ok := state.Items[feedConfig.Name]; ok {
+				seen[feedConfig.Name] = previous
+			}
+			continue
+		}
+		seen[feedConfig.Name] = ids
+		result.emailsFound += len(items)
+		feedLogger.Infow("Read feed", zap.Int("number_of_new_items", len(items)))
+
+		if opts.DryRun {
+			fmt.Fprintf(out, "Feed %s: found %d new items\n", feedConfig.Name, len(items))
+			for _, item := range items {
+				fmt.Fprintf(out, "  would add %q\n", item.Title)
+			}
+			continue
+		}
+		if len(items) == 0 {
+			continue
+		}
+		updateDialog("Adding "+strconv.Itoa(len(items))+" articles of "+feedConfig.Name+" to the digest. Please wait...", false)
+		if digest == nil {
+			digest, err = createDigest(title, path)
+			if err != nil {
+				// The items are added to the next digest
+				logger.Errorw("Failed to create the digest", zap.String("path", path), zap.Error(err))
+				result.messagesFailed++
+				return result, feedErr
+			}
+			images.book = digest.book
+		}
+		for _, item := range items {
+			if err := digest.book.AddChapter(digestChapter(client, images, feedConfig, item, feedLogger)); err != nil {
+				logger.Errorw("Failed to write the digest", zap.String("path", path), zap.Error(err))
+				digest.discard()
+				result.messagesFailed++
+				return result, feedErr
+			}
+		}
+	}
+
+	if opts.DryRun {
+		if result.emailsFound > 0 {
+			fmt.Fprintf(out, "  would save %s\n", path)
+			result.ebooksProcessed++
+		}
+		return result, feedErr
+	}
+
+	if digest != nil {
+		if err := digest.finish(); err != nil {
+			// The items are added to the next digest
+			logger.Errorw("Failed to save the digest", zap.String("path", path), zap.Error(err))
+			result.messagesFailed++
+			return result, feedErr
+		}
+		logger.Infow("Succesfully saved the digest", zap.String("path", path), zap.Int("number_of_articles", digest.book.Chapters()))
+		result.ebooksProcessed++
+		state.LastDigest = today
+	} else {
+		logger.Infow("No new items in the feeds, not building a digest")
+	}
+
+	state.Items = seen
+	err := rc.state.Set(feedsStateKey, state)
+	if err == nil {
+		err = rc.state.Save()
+	}
+	if err != nil {
+		logger.Errorw("Failed to save the feed items", zap.Error(err))
+	}
+	return result, feedErr
+}
+
+// newFeedItems returns the items of the feed that were not seen before, up to maxItems, and the IDs of all its items.
+// Items beyond maxItems are never added, the digest is about the latest news.
+func newFeedItems(client *http.Client, feedConfig config.FeedConfig, seen []string, maxItems int) ([]feed.Item, []string, error) {
+	f, err := feed.Fetch(client, feedConfig.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	previous := map[string]bool{}
+	for _, id := range seen {
+		previous[id] = true
+	}
+
+	var items []feed.Item
+	var ids []string
+	for _, item := range f.Items {
+		ids = append(ids, item.ID)
+		if !previous[item.ID] && len(items) < maxItems {
+			items = append(items, item)
+		}
+	}
+	return items, ids, nil
+}
+
+// digestChapter returns the chapter of a feed item.
+// When the feed only has a summary of the article, the readable content of the article is fetched from its website.
+func digestChapter(client *http.Client, images *digestImages, feedConfig config.FeedConfig, item feed.Item, logger *zap.SugaredLogger) epub.Chapter {
+	base, _ := url.Parse(item.Link)
+	content := article.Parse(item.Content)
+	title := item.Title
+
+	if KoboMailConfig.FeedsConfig.FetchArticles && item.Link != "" && len(content.TextContent()) < minFeedContentLength {
+		fetched, err := article.Fetch(client, item.Link)
+		if err != nil {
+			logger.Warnw("Failed to fetch article, using the content of the feed", zap.String("url", item.Link), zap.Error(err))
+		} else if len(fetched.Content.TextContent()) > len(content.TextContent()) {
+			content, base = fetched.Content, fetched.URL
+			if title == "" {
+				title = fetched.Title
+			}
+		}
+	}
+	if title == "" {
+		title = "Untitled"
+	}
+
+	byline := []string{html.EscapeString(feedConfig.Name)}
+	if item.Author != "" {
+		byline = append(byline, html.EscapeString(item.Author))
+	}
+	if !item.Published.IsZero() {
+		byline = append(byline, item.Published.Local().Format("2 January 2006 15:04"))
+	}
+	body := `<p class="byline">` + strings.Join(byline, " · ") + "</p>\n" + article.XHTML(content, base, images.add)
+	if item.Link != "" {
+		body += "\n" + `<p class="byline"><a href="` + html.EscapeString(item.Link) + `">` + html.EscapeString(item.Link) + "</a></p>"
+	}
+	return epub.Chapter{Title: title, Section: feedConfig.Name, Body: body}
+}
+
+// digestImages downloads the images of the articles into the digest, every image is downloaded once
+type digestImages struct {
+	client  *http.Client
+	book    *epub.Book
+	enabled bool
+	// added maps the URL of every image to its href in the book, images that failed have an empty href
+	added  map[string]string
+	logger *zap.SugaredLogger
+}
+
+// add downloads the image into the book, it implements article.ImageFunc
+func (d *digestImages) add(src string) (string, bool) {
+	if !d.enabled {
+		return "", false
+	}
+	if href, ok := d.added[src]; ok {
+		return href, href != ""
+	}
+	if len(d.added) >= maxDigestImages {
+		return "", false
+	}
+
+	d.added[src] = ""
+	data, err := d.download(src)
+	if err != nil {
+		d.logger.Debugw("Failed to download image, leaving it out", zap.String("url", src), zap.Error(err))
+		return "", false
+	}
+	href, err := d.book.AddImage(http.DetectContentType(data), data)
+	if err != nil {
+		d.logger.Debugw("Leaving out image", zap.String("url", src), zap.Error(err))
+		return "", false
+	}
+	d.added[src] = href
+	return href, true
+}
+
+// download returns the content of an image, images larger than maxImageSize are refused
+func (d *digestImages) download(src string) ([]byte, error) {
+	resp, err := d.client.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("the image is larger than %d bytes", maxImageSize)
+	}
+	return data, nil
+}
+
+// digestFile is a digest being written to a hidden temporary file next to its path, so an interrupted run
+// never leaves a broken digest in the library
+type digestFile struct {
+	book *epub.Book
+	tmp  *os.File
+	path string
+}
+
+// createDigest starts writing the digest, creating the library folder when needed
+func createDigest(title string, path string) (*digestFile, error) {
+	if !helpers.FolderExists(filepath.Dir(path)) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".kobomail-*.part")
+	if err != nil {
+		return nil, err
+	}
+	book, err := epub.Create(tmp, title, "KoboMail")
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &digestFile{book: book, tmp: tmp, path: path}, nil
+}
+
+// finish completes the digest and moves it to its path
+func (d *digestFile) finish() error {
+	err := d.book.Close()
+	if closeErr := d.tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(d.tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(d.tmp.Name(), d.path)
+	}
+	if err != nil {
+		os.Remove(d.tmp.Name())
+	}
+	return err
+}
+
+// discard removes the unfinished digest
+func (d *digestFile) discard() {
+	d.tmp.Close()
+	os.Remove(d.tmp.Name())
+}
@@ -0,0 +1,242 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"github.com/bjw-s/kobomail/pkg/nickeldbus"
+	"github.com/bjw-s/kobomail/pkg/nickelmenu"
+	"github.com/bjw-s/kobomail/pkg/udev"
+)
+
+// CheckStatus is the outcome of a health check
+type CheckStatus string
+
+// CheckStatus enum values
+const (
+	CheckStatusPass CheckStatus = "pass"
+	CheckStatusWarn CheckStatus = "warn"
+	CheckStatusFail CheckStatus = "fail"
+)
+
+// CheckResult is the result of a single health check
+type CheckResult struct {
+	Name    string      `json:"name"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message"`
+	Fix     string      `json:"fix,omitempty"`
+}
+
+// onboardPath is the user visible partition books need to live on
+const onboardPath = "/mnt/onboard"
+
+// minimumSaneTime is used to detect a device clock that was reset, which breaks TLS
+var minimumSaneTime = time.Date(2023, time.August, 1, 0, 0, 0, 0, time.UTC)
+
+func checkConfig(configPath string) CheckResult {
+	result := CheckResult{Name: "Configuration"}
+	if !helpers.FileExists(configPath) {
+		result.Status = CheckStatusFail
+		result.Message = "configuration file " + configPath + " not found"
+		result.Fix = "run `kobomail init` to create the configuration file from the template"
+		return result
+	}
+	if errs := KoboMailConfig.Validate(); len(errs) > 0 {
+		result.Status = CheckStatusFail
+		result.Message = errs.Error()
+		result.Fix = "correct the reported settings in " + configPath
+		return result
+	}
+	result.Status = CheckStatusPass
+	result.Message = "configuration is valid"
+	return result
+}
+
+func checkNickelDbus() CheckResult {
+	result := CheckResult{Name: "NickelDbus"}
+	if !nickeldbus.IsInstalled() {
+		result.Status = CheckStatusWarn
+		result.Message = "NickelDbus is not installed, notifications are disabled and the library is refreshed by simulating a USB connection"
+		result.Fix = "install NickelDbus " + nickeldbus.DesiredVersion
+		return result
+	}
+	version, err := nickeldbus.GetVersion()
+	if err != nil {
+		result.Status = CheckStatusFail
+		result.Message = "could not determine the NickelDbus version: " + err.Error()
+		result.Fix = "reinstall NickelDbus " + nickeldbus.DesiredVersion
+		return result
+	}
+	if version != nickeldbus.DesiredVersion {
+		result.Status = CheckStatusWarn
+		result.Message = fmt.Sprintf("NickelDbus %s is installed, KoboMail works against %s", version, nickeldbus.DesiredVersion)
+		result.Fix = "install NickelDbus " + nickeldbus.DesiredVersion
+		return result
+	}
+	result.Status = CheckStatusPass
+	result.Message = "NickelDbus " + version + " is installed"
+	return result
+}
+
+func checkNickelMenu() CheckResult {
+	result := CheckResult{Name: "NickelMenu"}
+	appConfig := KoboMailConfig.ApplicationConfig
+	installed := nickelmenu.IsInstalled()
+
+	switch {
+	case !installed && !appConfig.RunOnWifiConnect:
+		result.Status = CheckStatusFail
+		result.Message = "NickelMenu is not installed and run_on_wifi_connect is disabled, KoboMail cannot be started"
+		result.Fix = "install NickelMenu or set run_on_wifi_connect = true"
+	case !installed:
+		result.Status = CheckStatusWarn
+		result.Message = "NickelMenu is not installed, KoboMail can only run when WiFi connects"
+		result.Fix = "install NickelMenu to be able to start KoboMail manually"
+	case appConfig.CreateNickelMenuEntry && !nickelmenu.ConfigFileFound():
+		result.Status = CheckStatusWarn
+		result.Message = "the KoboMail NickelMenu entry has not been deployed yet"
+		result.Fix = "run `kobomail run` once to deploy the menu entry"
+	default:
+		result.Status = CheckStatusPass
+		result.Message = "NickelMenu is installed"
+	}
+	return result
+}
+
+func checkUdevRules() CheckResult {
+	result := CheckResult{Name: "WiFi trigger"}
+	found := udev.RulesFileFound()
+
+	if !KoboMailConfig.ApplicationConfig.RunOnWifiConnect {
+		if found {
+			result.Status = CheckStatusWarn
+			result.Message = "udev rules file found while run_on_wifi_connect is disabled"
+			result.Fix = "run `kobomail run` once to remove " + udev.RulesFilePath
+			return result
+		}
+		result.Status = CheckStatusPass
+		result.Message = "run_on_wifi_connect is disabled"
+		return result
+	}
+
+	if !found {
+		result.Status = CheckStatusWarn
+		result.Message = "udev rules file " + udev.RulesFilePath + " not found"
+		result.Fix = "run `kobomail run` once to deploy the udev rules"
+		return result
+	}
+	current, err := udev.RulesFileCurrent()
+	if err != nil {
+		result.Status = CheckStatusFail
+		result.Message = "could not read " + udev.RulesFilePath + ": " + err.Error()
+		result.Fix = "reinstall KoboMail"
+		return result
+	}
+	if !current {
+		result.Status = CheckStatusWarn
+		result.Message = "udev rules file " + udev.RulesFilePath + " is stale"
+		result.Fix = "delete " + udev.RulesFilePath + " and run `kobomail run` to deploy the current rules"
+		return result
+	}
+	result.Status = CheckStatusPass
+	result.Message = "udev rules are up to date"
+	return result
+}
+
+func checkCACertificates() CheckResult {
+	result := CheckResult{Name: "CA certificates"}
+	if !helpers.FileExists(config.CACertificatesPath) {
+		result.Status = CheckStatusFail
+		result.Message = config.CACertificatesPath + " not found, TLS connections will fail"
+		result.Fix = "run `kobomail init` to install the bundled certificates"
+		return result
+	}
+	result.Status = CheckStatusPass
+	result.Message = config.CACertificatesPath + " is present"
+	return result
+}
+
+func deviceID(path string) (uint64, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Dev), nil
+}
+
+func checkLibraryFolder() CheckResult {
+	result := CheckResult{Name: "Library folder"}
+	libraryPath := KoboMailConfig.ApplicationConfig.LibraryPath
+	if !helpers.FolderExists(libraryPath) {
+		result.Status = CheckStatusFail
+		result.Message = libraryPath + " does not exist"
+		result.Fix = "run `kobomail init` or create the folder"
+		return result
+	}
+
+	libraryDevice, err := deviceID(libraryPath)
+	if err != nil {
+		result.Status = CheckStatusFail
+		result.Message = "could not inspect " + libraryPath + ": " + err.Error()
+		return result
+	}
+	onboardDevice, err := deviceID(onboardPath)
+	if err != nil || libraryDevice != onboardDevice {
+		result.Status = CheckStatusFail
+		result.Message = libraryPath + " is not on the " + onboardPath + " partition, Nickel will not pick up the books"
+		result.Fix = "set library_path to a folder below " + onboardPath
+		return result
+	}
+	result.Status = CheckStatusPass
+	result.Message = libraryPath + " is on the " + onboardPath + " partition"
+	return result
+}
+
+func checkLogFile() CheckResult {
+	result := CheckResult{Name: "Log file"}
+	logFile := KoboMailConfig.ApplicationConfig.LogFile
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		result.Status = CheckStatusWarn
+		result.Message = "cannot write to " + logFile + ": " + err.Error()
+		result.Fix = "set logfile to a writable location"
+		return result
+	}
+	f.Close()
+	result.Status = CheckStatusPass
+	result.Message = logFile + " is writable"
+	return result
+}
+
+func checkClock() CheckResult {
+	result := CheckResult{Name: "Clock"}
+	now := time.Now()
+	if now.Before(minimumSaneTime) {
+		result.Status = CheckStatusFail
+		result.Message = "the device clock is set to " + now.Format(time.RFC1123Z) + ", TLS certificates will not validate"
+		result.Fix = "connect to WiFi and sync the time, or set the date in the device settings"
+		return result
+	}
+	result.Status = CheckStatusPass
+	result.Message = "the device clock is set to " + now.Format(time.RFC1123Z)
+	return result
+}
+
+// Doctor runs all environment health checks
+func Doctor(configPath string) []CheckResult {
+	return []CheckResult{
+		checkConfig(configPath),
+		checkNickelDbus(),
+		checkNickelMenu(),
+		checkUdevRules(),
+		checkCACertificates(),
+		checkLibraryFolder(),
+		checkLogFile(),
+		checkClock(),
+	}
+}
@@ -0,0 +1,125 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"go.uber.org/zap"
+)
+
+// downloadedFiles maps an identifier of every file downloaded from a remote source to what is remembered about it,
+// like the version of a WebDAV file or the filename of an OPDS entry
+type downloadedFiles map[string]string
+
+// remoteSource is a source downloading files straight into the library, like a WebDAV folder or an OPDS feed
+type remoteSource struct {
+	rc     *runContext
+	logger *zap.SugaredLogger
+	// kind names the type of source in messages, like "WebDAV folder"
+	kind string
+	name string
+	// stateKey is the key in the state file under which the downloaded files of all sources of this kind are stored
+	stateKey string
+	state    map[string]downloadedFiles
+}
+
+// remoteDownload is a file of a remote source that is planned to be saved to the library
+type remoteDownload struct {
+	plan attachmentPlan
+	// name identifies the file in the log, like the filename or the title of an entry
+	name string
+	// dryRun prints what else would happen to the file after it was saved
+	dryRun func(out io.Writer)
+	// saved is called once the file was saved to the library
+	saved func()
+}
+
+// loadRemoteSource reads the files downloaded before from the source.
+// When they cannot be read the source is treated like it never ran before.
+func loadRemoteSource(rc *runContext, logger *zap.SugaredLogger, kind string, name string, stateKey string) *remoteSource {
+	s := &remoteSource{rc: rc, logger: logger, kind: kind, name: name, stateKey: stateKey}
+	if _, err := rc.state.Get(stateKey, &s.state); err != nil {
+		logger.Warnw("Failed to read the downloaded files from the state file", zap.Error(err))
+	}
+	if s.state == nil {
+		s.state = map[string]downloadedFiles{}
+	}
+	return s
+}
+
+// downloaded returns the files downloaded before from the source, and if the source was run before
+func (s *remoteSource) downloaded() (downloadedFiles, bool) {
+	downloaded, ok := s.state[s.name]
+	if downloaded == nil {
+		downloaded = downloadedFiles{}
+	}
+	return downloaded, ok
+}
+
+// save stores the files downloaded from the source in the state file, nothing is stored in a dry run
+func (s *remoteSource) save(downloaded downloadedFiles) {
+	if s.rc.opts.DryRun {
+		return
+	}
+	s.state[s.name] = downloaded
+	err := s.rc.state.Set(s.stateKey, s.state)
+	if err == nil {
+		err = s.rc.state.Save()
+	}
+	if err != nil {
+		s.logger.Errorw("Failed to save the downloaded files to the state file", zap.Error(err))
+	}
+}
+
+// download saves the planned files to libraryPath, a file that fails is counted and the next one is downloaded
+func (s *remoteSource) download(libraryPath string, downloads []remoteDownload, result *accountResult) error {
+	logger, opts, out := s.logger, s.rc.opts, s.rc.out
+
+	result.emailsFound = len(downloads)
+	logger.Infow("Found new files", zap.Int("number_of_files_found", len(downloads)))
+	if opts.DryRun {
+		fmt.Fprintf(out, "%s %s: found %d new files\n", s.kind, s.name, len(downloads))
+	}
+	if len(downloads) == 0 {
+		return nil
+	}
+	updateDialog("Found "+strconv.Itoa(len(downloads))+" new ebooks in "+s.name+". Please wait...", false)
+	if !opts.DryRun && !helpers.FolderExists(libraryPath) {
+		logger.Infow("Creating library folder", zap.String("path", libraryPath))
+		if err := os.MkdirAll(libraryPath, 0755); err != nil {
+			const errMsg = "Failed to create library folder"
+			showDialog(errMsg+" "+libraryPath+": "+err.Error(), true)
+			logger.Errorw(errMsg, zap.Error(err))
+			return runError(ExitProcessingError, errMsg+" "+libraryPath, err)
+		}
+	}
+
+	for _, d := range downloads {
+		if opts.DryRun {
+			printAttachmentPlan(out, d.plan)
+			if d.dryRun != nil {
+				d.dryRun(out)
+			}
+			result.ebooksProcessed++
+			continue
+		}
+
+		logger.Debugw("Downloading file", zap.String("name", d.name), zap.String("rule", d.plan.Rule))
+		if err := saveAttachment(d.plan); err != nil {
+			logger.Errorw("Failed to download file, continuing with the next one", zap.String("name", d.name), zap.Error(err))
+			result.messagesFailed++
+			continue
+		}
+		logger.Infow("Succesfully downloaded file", zap.String("name", d.name), zap.String("path", d.plan.Path))
+		if d.plan.Collection != "" {
+			s.rc.collections[d.plan.Collection] = append(s.rc.collections[d.plan.Collection], d.plan.Path)
+		}
+		result.ebooksProcessed++
+		d.saved()
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"errors"
+
+	"github.com/bjw-s/kobomail/internal/config"
+)
+
+// Exit codes of the kobomail command, scripts and the udev trigger can use them to tell failures apart
+const (
+	// ExitOK is returned when the run succeeded, also when no emails were found
+	ExitOK = 0
+	// ExitError is returned for any failure without a more specific exit code
+	ExitError = 1
+	// ExitConfigError is returned when the configuration is invalid
+	ExitConfigError = 2
+	// ExitConnectionError is returned when the IMAP server could not be reached or the login failed
+	ExitConnectionError = 3
+	// ExitMailboxError is returned when a mailbox could not be listed, selected or searched
+	ExitMailboxError = 4
+	// ExitProcessingError is returned when an email or attachment could not be processed
+	ExitProcessingError = 5
+	// ExitSetupError is returned when the NickelDbus, NickelMenu or udev integration could not be set up
+	ExitSetupError = 6
+)
+
+// RunError is a failure of a KoboMail run together with the exit code it should result in
+type RunError struct {
+	Code    int
+	Message string
+	Err     error
+}
+
+func (e *RunError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// runError wraps err in a RunError with the given exit code
+func runError(code int, message string, err error) *RunError {
+	return &RunError{Code: code, Message: message, Err: err}
+}
+
+// ExitCode returns the exit code for an error returned by a KoboMail command
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		return runErr.Code
+	}
+	var validationErrs config.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return ExitConfigError
+	}
+	return ExitError
+}
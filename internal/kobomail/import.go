@@ -0,0 +1,56 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/localmail"
+	"go.uber.org/zap"
+)
+
+// importAccount is the name the import folder is reported with, next to the mail accounts
+const importAccount = "import"
+
+// runImport processes the emails copied to the import folder, as .eml files, Maildirs or mbox files.
+// Every email is processed, the email flag and search criteria only apply to mail servers.
+func runImport(rc *runContext) (accountResult, error) {
+	logger := zap.S().With(zap.String("account", importAccount))
+	result := accountResult{account: importAccount}
+	account := config.AccountConfig{Name: importAccount}
+
+	source := localmail.Open(config.ImportPath, config.ImportDonePath)
+	source.ReadOnly = rc.opts.DryRun
+	// Emails handled in files that stayed in place, like an mbox file with a failed email, are not processed again
+	handled := rc.history.mailbox(account, config.ImportPath, 0)
+	for _, id := range handled.UIDLs {
+		source.Done[id] = true
+	}
+	messages, err := source.List()
+	if err != nil {
+		const errMsg = "Failed to read the import folder"
+		showDialog(errMsg+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitMailboxError, errMsg+" "+config.ImportPath, err)
+	}
+	// An empty import folder is not worth reporting
+	if len(messages) > 0 {
+		fr := &folderRun{
+			runContext:  rc,
+			src:         source,
+			account:     account,
+			folder:      config.FolderConfig{Name: config.ImportPath},
+			libraryPath: KoboMailConfig.ApplicationConfig.LibraryPath,
+			logger:      logger,
+		}
+		err = processMessages(fr, messages, &result)
+	}
+
+	// Files are only moved once all emails in them are handled, failed emails stay in the import folder.
+	// Files of which all emails were handled before but that could not be moved are moved now.
+	if !rc.opts.DryRun {
+		if closeErr := source.Close(); closeErr != nil {
+			logger.Errorw("Failed to move handled files to the done folder", zap.String("path", config.ImportDonePath), zap.Error(closeErr))
+		}
+		rc.history.setUIDLs(handled, source.DoneIDs())
+	}
+	return result, err
+}
@@ -0,0 +1,213 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/jmap"
+	"go.uber.org/zap"
+)
+
+// connectJMAP fetches the JMAP session of the account and authenticates
+func connectJMAP(account config.AccountConfig) (*jmap.Client, error) {
+	imapConfig := account.IMAPConfig
+	return jmap.Dial(account.JMAPSessionURL(), jmap.Auth(imapConfig.JMAPAuth), imapConfig.IMAPUser, string(imapConfig.IMAPPwd))
+}
+
+// runJMAPAccount processes all matching emails in every folder of a JMAP account.
+// The emails are searched on the server like on IMAP, but only the attachments that are saved are downloaded.
+func runJMAPAccount(account config.AccountConfig, rc *runContext) (accountResult, error) {
+	logger := zap.S().With(zap.String("account", account.Name))
+	result := accountResult{account: account.Name}
+	search := account.IMAPConfig.Search
+	if search.GmailRaw != "" || len(search.GmailLabels) > 0 {
+		logger.Warnw("JMAP servers do not support the Gmail extensions, ignoring gmail_raw and gmail_labels")
+	}
+
+	client, err := connectJMAP(account)
+	if err != nil {
+		var errMsg = fmt.Sprintf("Failed to connect to %s, please check internet connection and credentials", account.JMAPSessionURL())
+		showDialog(errMsg, true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitConnectionError, errMsg, err)
+	}
+	logger.Infow("Connected to JMAP server", zap.String("session", account.JMAPSessionURL()))
+
+	folders, err := resolveJMAPFolders(client, account)
+	if err != nil {
+		const errMsg = "Failed to list JMAP mailboxes"
+		showDialog(errMsg+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitMailboxError, errMsg, err)
+	}
+
+	client.ReadOnly = rc.opts.DryRun
+	client.Filter = jmapFilter(account, time.Now())
+	client.ProcessedKeyword = account.IMAPConfig.ProcessedKeyword
+	for _, folder := range folders {
+		if err := runJMAPFolder(client, account, folder, rc, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// runJMAPFolder processes all matching emails in a single mailbox of a JMAP account
+func runJMAPFolder(client *jmap.Client, account config.AccountConfig, folder config.FolderConfig, rc *runContext, result *accountResult) error {
+	logger := zap.S().With(zap.String("account", account.Name), zap.String("folder", folder.Name))
+
+	mbox, err := client.SelectMailbox(folder.Name)
+	if err != nil {
+		const errMsg = "Failed to select JMAP mailbox"
+		showDialog(errMsg+" "+folder.Name+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return runError(ExitMailboxError, errMsg+" "+folder.Name, err)
+	}
+	logger.Infow("JMAP mailbox selected", zap.String("name", mbox.Path), zap.String("id", mbox.ID))
+
+	messages, err := client.List()
+	if err != nil {
+		const errMsg = "Failed to fetch messages"
+		showDialog(errMsg+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return runError(ExitMailboxError, errMsg+" from "+folder.Name, err)
+	}
+
+	fr := &folderRun{
+		runContext:      rc,
+		src:             client,
+		account:         account,
+		folder:          folder,
+		libraryPath:     KoboMailConfig.FolderLibraryPath(account, folder),
+		logger:          logger,
+		deleteProcessed: KoboMailConfig.ProcessingConfig.EmailDelete,
+		keyword:         account.IMAPConfig.ProcessedKeyword,
+	}
+	return processMessages(fr, messages, result)
+}
+
+// resolveJMAPFolders returns the folders to process for the account, expanding wildcards like IMAP LIST does
+func resolveJMAPFolders(client *jmap.Client, account config.AccountConfig) ([]config.FolderConfig, error) {
+	mailboxes, err := client.Mailboxes()
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []config.FolderConfig
+	seen := map[string]bool{}
+	for _, folder := range account.MailFolders() {
+		names := []string{folder.Name}
+		if strings.ContainsAny(folder.Name, "*%") {
+			names = nil
+			pattern := mailboxPattern(folder.Name)
+			for _, mbox := range mailboxes {
+				if pattern.MatchString(mbox.Path) {
+					names = append(names, mbox.Path)
+				}
+			}
+		}
+
+		// The first folder configuration matching a mailbox wins, failed emails are never processed again
+		for _, name := range names {
+			if seen[name] || name == account.IMAPConfig.FailedFolder {
+				continue
+			}
+			seen[name] = true
+			resolved := folder
+			resolved.Name = name
+			folders = append(folders, resolved)
+		}
+	}
+	return folders, nil
+}
+
+// mailboxPattern converts an IMAP LIST pattern to a regular expression,
+// * matches any characters and % matches any characters except the hierarchy separator
+func mailboxPattern(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '%':
+			expr.WriteString("[^/]*")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+// jmapFilter returns the Email/query filter with the same criteria applySearchCriteria uses on IMAP servers
+func jmapFilter(account config.AccountConfig, now time.Time) jmap.Filter {
+	imapConfig := account.IMAPConfig
+	var filters []jmap.Filter
+	if imapConfig.EmailUnseen {
+		filters = append(filters, jmap.Condition{NotKeyword: "$seen"})
+	}
+	if imapConfig.EmailFlagType == config.EmailFlagTypePlus {
+		filters = append(filters, jmap.Condition{To: strings.Replace(imapConfig.IMAPUser, "@", "+"+imapConfig.EmailFlag+"@", 1)})
+	} else if imapConfig.EmailFlagType == config.EmailFlagTypeSubject {
+		filters = append(filters, jmap.Condition{Subject: imapConfig.EmailFlag})
+	}
+	if imapConfig.ProcessedKeyword != "" {
+		filters = append(filters, jmap.Condition{NotKeyword: jmap.Keyword(imapConfig.ProcessedKeyword)})
+	}
+	filters = append(filters, jmapSearchFilters(imapConfig.Search, now)...)
+	if len(filters) == 0 {
+		return nil
+	}
+	return jmap.And(filters...)
+}
+
+// jmapSearchFilters returns the filters of the configured search, all of them must match
+func jmapSearchFilters(search config.SearchConfig, now time.Time) []jmap.Filter {
+	condition := jmap.Condition{From: search.From, To: search.To, Subject: search.Subject}
+	// Dates are checked during configuration validation
+	if search.Since != "" {
+		since, _ := config.ParseSearchDate(search.Since, now)
+		condition.After = jmap.UTCDate(since)
+	}
+	if search.Before != "" {
+		before, _ := config.ParseSearchDate(search.Before, now)
+		condition.Before = jmap.UTCDate(before)
+	}
+	// IMAP LARGER excludes the size itself, JMAP minSize includes it
+	if search.Larger > 0 {
+		condition.MinSize = search.Larger + 1
+	}
+	condition.MaxSize = search.Smaller
+
+	var filters []jmap.Filter
+	if condition != (jmap.Condition{}) {
+		filters = append(filters, condition)
+	}
+	// A condition only holds a single keyword
+	for _, keyword := range search.Keywords {
+		filters = append(filters, jmap.Condition{HasKeyword: jmap.Keyword(keyword)})
+	}
+	for _, keyword := range search.WithoutKeywords {
+		filters = append(filters, jmap.Condition{NotKeyword: jmap.Keyword(keyword)})
+	}
+
+	var alternatives []jmap.Filter
+	for _, alternative := range search.Or {
+		alternativeFilters := jmapSearchFilters(alternative, now)
+		// An empty alternative matches every email
+		if len(alternativeFilters) == 0 {
+			return filters
+		}
+		alternatives = append(alternatives, jmap.And(alternativeFilters...))
+	}
+	if len(alternatives) > 0 {
+		filters = append(filters, jmap.Or(alternatives...))
+	}
+	return filters
+}
@@ -0,0 +1,76 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/imap"
+	"go.uber.org/zap"
+)
+
+// MailboxMessages contains the preview of the messages found in a folder of an account
+type MailboxMessages struct {
+	Account  string                `json:"account"`
+	Folder   string                `json:"folder"`
+	Messages []imap.MessagePreview `json:"messages"`
+}
+
+// ListMessages returns a preview of every message Run would pick up, without modifying the mailbox
+func ListMessages() ([]MailboxMessages, error) {
+	var mailboxMessages []MailboxMessages
+	for _, account := range KoboMailConfig.MailAccounts() {
+		accountMessages, err := listAccountMessages(account)
+		mailboxMessages = append(mailboxMessages, accountMessages...)
+		if err != nil {
+			return mailboxMessages, fmt.Errorf("account %s: %w", account.Name, err)
+		}
+	}
+	return mailboxMessages, nil
+}
+
+func listAccountMessages(account config.AccountConfig) ([]MailboxMessages, error) {
+	logger := zap.S()
+	imapConfig := account.IMAPConfig
+	if imapConfig.Protocol != config.MailProtocolIMAP {
+		logger.Warnw("Listing messages is only supported for IMAP accounts, skipping account", zap.String("account", account.Name))
+		return nil, nil
+	}
+
+	imapConnection, err := imap.ConnectToServer(imapConfig.IMAPHost, imapConfig.IMAPPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s:%v: %w", imapConfig.IMAPHost, imapConfig.IMAPPort, err)
+	}
+	defer imapConnection.Logout()
+
+	if err := imapConnection.Login(imapConfig.IMAPUser, string(imapConfig.IMAPPwd)); err != nil {
+		return nil, fmt.Errorf("failed to authenticate to IMAP server: %w", err)
+	}
+	logger.Debugw("Authenticated to IMAP server", zap.String("user", imapConfig.IMAPUser))
+
+	folders, err := resolveMailFolders(imapConnection, account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list IMAP mailboxes: %w", err)
+	}
+
+	var mailboxMessages []MailboxMessages
+	imapConnection.ReadOnly = true
+	for _, folder := range folders {
+		if _, err := imapConnection.SelectMailbox(folder.Name); err != nil {
+			return mailboxMessages, fmt.Errorf("failed to select IMAP mailbox %s: %w", folder.Name, err)
+		}
+
+		imapConnection.ResetSearchCriteria()
+		applySearchCriteria(imapConnection, account)
+		previews, err := imapConnection.PreviewMessages(KoboMailConfig.FolderFiletypes(folder))
+		if err != nil {
+			return mailboxMessages, fmt.Errorf("failed to preview messages in %s: %w", folder.Name, err)
+		}
+		mailboxMessages = append(mailboxMessages, MailboxMessages{
+			Account:  account.Name,
+			Folder:   folder.Name,
+			Messages: previews,
+		})
+	}
+	return mailboxMessages, nil
+}
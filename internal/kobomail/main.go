@@ -2,17 +2,23 @@
 package kobomail
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/helpers"
 	"github.com/bjw-s/kobomail/pkg/imap"
+	"github.com/bjw-s/kobomail/pkg/jmap"
+	"github.com/bjw-s/kobomail/pkg/localmail"
+	"github.com/bjw-s/kobomail/pkg/message"
 	"github.com/bjw-s/kobomail/pkg/nickeldbus"
 	"github.com/bjw-s/kobomail/pkg/nickelmenu"
-	"github.com/bjw-s/kobomail/pkg/nickelseries"
+	"github.com/bjw-s/kobomail/pkg/state"
 	"github.com/bjw-s/kobomail/pkg/udev"
 	"go.uber.org/zap"
 )
@@ -133,6 +139,25 @@ func PreparePrerequisites() error {
 	return nil
 }
 
+// ShowConfigErrors tells the user on the device that the configuration is invalid.
+// It runs before the configuration could be loaded, so it does not depend on show_notifications.
+func ShowConfigErrors(errs config.ValidationErrors) {
+	if !nickeldbus.IsInstalled() {
+		return
+	}
+	const maxErrors = 5
+	lines := []string{"KoboMail configuration is invalid:"}
+	for i, err := range errs {
+		if i == maxErrors {
+			lines = append(lines, fmt.Sprintf("and %d more, see kobomail.log", len(errs)-maxErrors))
+			break
+		}
+		lines = append(lines, err.Error())
+	}
+	nickeldbus.DialogCreate(strings.Join(lines, "\n"))
+	nickeldbus.DialogAddOKButton()
+}
+
 func showDialog(message string, confirmationButton bool) {
 	logger := zap.S()
 	if useNickelDbus && KoboMailConfig.ApplicationConfig.ShowNotifications {
@@ -155,108 +180,503 @@ func updateDialog(message string, confirmationButton bool) {
 	}
 }
 
-// Run executes the main KoboMail logic
-func Run() {
-	logger := zap.S()
+// RunOptions controls how Run processes the mailbox
+type RunOptions struct {
+	// DryRun only reports what would be done, without modifying the mailbox or the filesystem
+	DryRun bool
+	// Output receives the dry-run report, defaults to stdout
+	Output io.Writer
+}
 
-	// Show the user we are running opening a dialog
-	showDialog("Starting up, please wait.", false)
+// runContext holds the state shared by all accounts and folders during a run
+type runContext struct {
+	opts  RunOptions
+	out   io.Writer
+	rules []*rule
+	// collections maps collection names to the books that should be added to them
+	collections map[string][]string
+	// history records processed emails on servers that cannot store the processed keyword and the emails that failed
+	history *processedHistory
+	// state is the state file shared by the history and the other sources
+	state *state.Store
+}
 
-	imapConnection, err := imap.ConnectToServer(KoboMailConfig.IMAPConfig.IMAPHost, KoboMailConfig.IMAPConfig.IMAPPort)
+// accountResult summarizes the processing of a single account
+type accountResult struct {
+	account         string
+	emailsFound     int
+	ebooksProcessed int
+	messagesFailed  int
+}
+
+// runAccount processes all matching emails of a single account
+func runAccount(account config.AccountConfig, rc *runContext) (accountResult, error) {
+	switch account.IMAPConfig.Protocol {
+	case config.MailProtocolPOP3:
+		return runPOP3Account(account, rc)
+	case config.MailProtocolJMAP:
+		return runJMAPAccount(account, rc)
+	}
+	return runIMAPAccount(account, rc)
+}
+
+// runIMAPAccount processes all matching emails in every folder of an IMAP account
+func runIMAPAccount(account config.AccountConfig, rc *runContext) (accountResult, error) {
+	logger := zap.S().With(zap.String("account", account.Name))
+	imapConfig := account.IMAPConfig
+	result := accountResult{account: account.Name}
+
+	imapConnection, err := imap.ConnectToServer(imapConfig.IMAPHost, imapConfig.IMAPPort)
 	if err != nil {
 		var errMsg = fmt.Sprintf(
 			"Failed to connect to %s:%v, please check internet connection",
-			KoboMailConfig.IMAPConfig.IMAPHost,
-			KoboMailConfig.IMAPConfig.IMAPPort,
+			imapConfig.IMAPHost,
+			imapConfig.IMAPPort,
 		)
 		showDialog(errMsg, true)
-		logger.Fatalw(errMsg, zap.Error(err))
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitConnectionError, errMsg, err)
 	}
 	logger.Infow(
 		"Connected to IMAP server",
-		zap.String("host", KoboMailConfig.IMAPConfig.IMAPHost),
-		zap.Int("port", KoboMailConfig.IMAPConfig.IMAPPort),
+		zap.String("host", imapConfig.IMAPHost),
+		zap.Int("port", imapConfig.IMAPPort),
 	)
+	defer imapConnection.Logout()
 
 	// Connected to the imap server, login
-	if err := imapConnection.Login(KoboMailConfig.IMAPConfig.IMAPUser, string(KoboMailConfig.IMAPConfig.IMAPPwd)); err != nil {
+	if err := imapConnection.Login(imapConfig.IMAPUser, string(imapConfig.IMAPPwd)); err != nil {
 		const errMsg = "Failed to authenticate to IMAP server"
+		showDialog(errMsg+" as "+imapConfig.IMAPUser+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitConnectionError, errMsg+" as "+imapConfig.IMAPUser, err)
+	}
+	logger.Infow("Authenticated to IMAP server", zap.String("user", imapConfig.IMAPUser))
+
+	folders, err := resolveMailFolders(imapConnection, account)
+	if err != nil {
+		const errMsg = "Failed to list IMAP mailboxes"
 		showDialog(errMsg+": "+err.Error(), true)
-		logger.Fatalw(errMsg, zap.Error(err))
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitMailboxError, errMsg, err)
 	}
-	logger.Infow("Authenticated to IMAP server", zap.String("user", KoboMailConfig.IMAPConfig.IMAPUser))
-	defer imapConnection.Logout()
+
+	for _, folder := range folders {
+		if err := runFolder(imapConnection, account, folder, rc, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// resolveMailFolders returns the folders to process for the account, expanding wildcards using LIST
+func resolveMailFolders(imapConnection *imap.Connection, account config.AccountConfig) ([]config.FolderConfig, error) {
+	var folders []config.FolderConfig
+	seen := map[string]bool{}
+	for _, folder := range account.MailFolders() {
+		names := []string{folder.Name}
+		if strings.ContainsAny(folder.Name, "*%") {
+			var err error
+			names, err = imapConnection.ListMailboxes(folder.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// The first folder configuration matching a mailbox wins, failed emails are never processed again
+		for _, name := range names {
+			if seen[name] || name == account.IMAPConfig.FailedFolder {
+				continue
+			}
+			seen[name] = true
+			resolved := folder
+			resolved.Name = name
+			folders = append(folders, resolved)
+		}
+	}
+	return folders, nil
+}
+
+// folderRun holds the state of processing the emails of a single source, like a folder of an IMAP account
+type folderRun struct {
+	*runContext
+	src         Source
+	account     config.AccountConfig
+	folder      config.FolderConfig
+	libraryPath string
+	logger      *zap.SugaredLogger
+	// deleteProcessed deletes emails from the source after processing them
+	deleteProcessed bool
+	// keyword marks processed emails on IMAP servers, when the server does not allow it they are recorded in processed
+	keyword   string
+	processed *mailboxHistory
+	// uidValidity is the UIDVALIDITY of an IMAP folder, the IDs of the failed emails are only valid for it
+	uidValidity uint32
+	failed      *failedMailbox
+}
+
+// runFolder processes all matching emails in a single IMAP folder of an account.
+// An email that fails is reported and skipped, only failures affecting the whole folder are returned.
+func runFolder(imapConnection *imap.Connection, account config.AccountConfig, folder config.FolderConfig, rc *runContext, result *accountResult) error {
+	logger := zap.S().With(zap.String("account", account.Name), zap.String("folder", folder.Name))
 
 	// Select mailbox so we can search on it
-	mbox, err := imapConnection.SelectMailbox(KoboMailConfig.IMAPConfig.IMAPFolder)
+	imapConnection.ReadOnly = rc.opts.DryRun
+	mbox, err := imapConnection.SelectMailbox(folder.Name)
 	if err != nil {
 		const errMsg = "Failed to select IMAP mailbox"
-		showDialog(errMsg+" "+KoboMailConfig.IMAPConfig.IMAPFolder+": "+err.Error(), true)
-		logger.Fatalw(errMsg, zap.Error(err))
+		showDialog(errMsg+" "+folder.Name+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return runError(ExitMailboxError, errMsg+" "+folder.Name, err)
 	}
 	logger.Infow("IMAP mailbox selected", zap.String("name", mbox.Name))
 
 	// Apply the search criteria and check if there's any emails with that criteria
-	if KoboMailConfig.IMAPConfig.EmailUnseen {
-		imapConnection.SearchCriteria.WithoutFlags = []string{"\\Seen"}
-	}
-	if KoboMailConfig.IMAPConfig.EmailFlagType == config.EmailFlagTypePlus {
-		criterium := strings.Replace(KoboMailConfig.IMAPConfig.IMAPUser, "@", "+"+KoboMailConfig.IMAPConfig.EmailFlag+"@", 1)
-		imapConnection.SearchCriteria.Header.Add("TO", criterium)
-	} else if KoboMailConfig.IMAPConfig.EmailFlagType == config.EmailFlagTypeSubject {
-		criterium := KoboMailConfig.IMAPConfig.EmailFlag
-		imapConnection.SearchCriteria.Header.Add("SUBJECT", criterium)
-	}
+	imapConnection.ResetSearchCriteria()
+	applySearchCriteria(imapConnection, account)
 
-	messages, err := imapConnection.CollectMessages()
+	messages, err := imapConnection.List()
 	if err != nil {
 		const errMsg = "Failed to fetch messages"
 		showDialog(errMsg+": "+err.Error(), true)
-		logger.Fatalw(errMsg, zap.Error(err))
+		logger.Errorw(errMsg, zap.Error(err))
+		return runError(ExitMailboxError, errMsg+" from "+folder.Name, err)
 	}
-	numberOfEmailsFound := len(messages)
-	logger.Infow("Fetched emails", zap.Int("number_of_emails_found", numberOfEmailsFound))
 
-	// Process the collected messages
-	if numberOfEmailsFound > 0 {
-		updateDialog("Found "+strconv.Itoa(numberOfEmailsFound)+" emails to process. Please wait...", false)
-	} else {
-		const msg = "No emails found, nothing to be done."
-		showDialog(msg, true)
-		os.Exit(0)
+	fr := &folderRun{
+		runContext:      rc,
+		src:             imapConnection,
+		account:         account,
+		folder:          folder,
+		libraryPath:     KoboMailConfig.FolderLibraryPath(account, folder),
+		logger:          logger,
+		deleteProcessed: KoboMailConfig.ProcessingConfig.EmailDelete,
+		keyword:         account.IMAPConfig.ProcessedKeyword,
+		uidValidity:     mbox.UidValidity,
 	}
 
-	numberOfEbooksProcessed := 0
+	// Emails are marked with the processed keyword when the server allows it, otherwise they are recorded in the history
+	imapConnection.ProcessedKeyword = fr.keyword
+	if fr.keyword != "" {
+		fr.processed = rc.history.mailbox(account, folder.Name, mbox.UidValidity)
+		unprocessed := messages[:0]
+		for _, msg := range messages {
+			if fr.processed.contains(imap.UID(msg)) {
+				logger.Debugw("Skipping message found in the processed history", zap.String("uid", msg.ID))
+				continue
+			}
+			unprocessed = append(unprocessed, msg)
+		}
+		messages = unprocessed
+	}
+
+	return processMessages(fr, messages, result)
+}
 
+// processMessages processes the listed emails of a source one by one.
+// An email that fails is reported and skipped, only failures affecting all emails are returned.
+func processMessages(fr *folderRun, messages []*message.Message, result *accountResult) error {
+	logger, opts := fr.logger, fr.opts
+
+	// Emails that failed before are not processed again, they would fail on every run
+	fr.failed = fr.history.failedMailbox(fr.account, fr.folder.Name, fr.uidValidity)
+	var listed []string
+	pending := messages[:0]
 	for _, msg := range messages {
-		err := msg.FetchDetails()
-		if err != nil {
-			const errMsg = "Failed to process message"
-			showDialog(errMsg+": "+err.Error(), true)
-			logger.Fatalw(errMsg, zap.Any("message", msg), zap.Error(err))
+		listed = append(listed, msg.ID)
+		if fr.failed.contains(msg.ID) {
+			logger.Debugw("Skipping message that failed before", zap.String("id", msg.ID))
+			continue
+		}
+		pending = append(pending, msg)
+	}
+	messages = pending
+	if !opts.DryRun {
+		fr.history.pruneFailed(fr.failed, listed)
+	}
+
+	emailsFound := len(messages)
+	result.emailsFound += emailsFound
+	logger.Infow("Fetched emails", zap.Int("number_of_emails_found", emailsFound))
+
+	if opts.DryRun {
+		fmt.Fprintf(fr.out, "Account %s, folder %s: found %d emails\n", fr.account.Name, fr.folder.Name, emailsFound)
+	}
+	if emailsFound == 0 {
+		return nil
+	}
+	updateDialog("Found "+strconv.Itoa(emailsFound)+" emails to process for "+fr.account.Name+". Please wait...", false)
+
+	if !opts.DryRun && !helpers.FolderExists(fr.libraryPath) {
+		logger.Infow("Creating library folder", zap.String("path", fr.libraryPath))
+		if err := os.MkdirAll(fr.libraryPath, 0755); err != nil {
+			const errMsg = "Failed to create library folder"
+			showDialog(errMsg+" "+fr.libraryPath+": "+err.Error(), true)
+			logger.Errorw(errMsg, zap.Error(err))
+			return runError(ExitProcessingError, errMsg+" "+fr.libraryPath, err)
 		}
-		logger.Infow("Processing message", zap.Any("message", msg))
+	}
 
-		downloadedAttachmentsCount, err := msg.ProcessAttachments(KoboMailConfig.ProcessingConfig.Filetypes, KoboMailConfig.ApplicationConfig.LibraryPath)
+	for _, msg := range messages {
+		ebooksProcessed, err := processMessage(fr, msg)
+		result.ebooksProcessed += ebooksProcessed
 		if err != nil {
-			const errMsg = "Failed to process attachment"
-			showDialog(errMsg+": "+err.Error(), true)
-			logger.Fatalw(errMsg, zap.Error(err))
+			result.messagesFailed++
+			fr.messageFailed(msg, err)
 		}
+	}
 
-		numberOfEbooksProcessed = numberOfEbooksProcessed + downloadedAttachmentsCount
+	return nil
+}
 
-		if KoboMailConfig.ProcessingConfig.EmailDelete {
-			logger.Infow("Deleting message", zap.Any("message", msg))
-			err := imapConnection.DeleteMessage(msg)
-			if err != nil {
-				const errMsg = "Failed to delete message"
-				showDialog(errMsg+": "+err.Error(), true)
-				logger.Fatalw(errMsg, zap.Any("message", msg), zap.Error(err))
+// processMessage saves the attachments of a single email and marks, moves or deletes it afterwards.
+// It returns the number of saved ebooks, also when it fails after saving some of them.
+// A panic while processing the email is returned as an error, so a malformed email cannot abort the run.
+func processMessage(fr *folderRun, msg *message.Message) (ebooksProcessed int, err error) {
+	logger, opts, out := fr.logger, fr.opts, fr.out
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unexpected error: %v", r)
+		}
+	}()
+
+	if err := fr.src.Fetch(msg); err != nil {
+		return 0, fmt.Errorf("failed to fetch message: %w", err)
+	}
+	logger.Infow("Processing message", zap.Any("message", msg))
+
+	attachments, err := msg.Attachments()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read attachments: %w", err)
+	}
+
+	info := messageInfo{Sender: msg.Sender, Recipients: msg.Recipients, Subject: msg.Subject}
+	moveTo, moveRule := planMessageMove(fr.rules, info, attachments)
+	src, canMove := fr.src.(mover)
+	if moveTo != "" && !canMove {
+		logger.Warnw("Emails cannot be moved on this server, ignoring move_to", zap.String("rule", moveRule))
+		moveTo = ""
+	}
+
+	if opts.DryRun {
+		fmt.Fprintf(out, "Message %q from %s (%s)\n", msg.Subject, msg.Sender, msg.Date.Format(time.RFC1123Z))
+	}
+
+	for _, attachment := range attachments {
+		plan := planAttachment(fr.rules, info, attachment, fr.libraryPath, KoboMailConfig.FolderFiletypes(fr.folder))
+		if opts.DryRun {
+			printAttachmentPlan(out, plan)
+			if !plan.Skip {
+				ebooksProcessed++
 			}
+			continue
+		}
+		if plan.Skip {
+			logger.Debugw("Skipping attachment", zap.String("filename", attachment.Filename), zap.String("rule", plan.Rule))
+			continue
+		}
+
+		logger.Debugw("Downloading attachment", zap.String("filename", attachment.Filename), zap.String("rule", plan.Rule))
+		if err := saveAttachment(plan); err != nil {
+			return ebooksProcessed, fmt.Errorf("failed to save attachment %s: %w", attachment.Filename, err)
 		}
+		logger.Infow("Succesfully downloaded attachment", zap.String("filename", attachment.Filename), zap.String("path", plan.Path))
+		if plan.Collection != "" {
+			fr.collections[plan.Collection] = append(fr.collections[plan.Collection], plan.Path)
+		}
+		ebooksProcessed++
+	}
+
+	if opts.DryRun {
+		switch fr.src.(type) {
+		case *imap.Connection, *jmap.Client:
+			if fr.keyword != "" {
+				fmt.Fprintf(out, "  would mark message as processed with %s\n", fr.keyword)
+			} else if !msg.Seen {
+				fmt.Fprintln(out, "  would flag message as \\Seen")
+			}
+		case *localmail.Source:
+			fmt.Fprintln(out, "  would move the file to the done folder once all its emails are processed")
+		default:
+			fmt.Fprintln(out, "  would remember message as processed")
+		}
+		if moveTo != "" {
+			fmt.Fprintf(out, "  would move message to %s (rule %s)\n", moveTo, moveRule)
+		} else if fr.deleteProcessed {
+			fmt.Fprintln(out, "  would delete message")
+		}
+		return ebooksProcessed, nil
+	}
+
+	if err := fr.src.MarkDone(msg); err != nil {
+		switch {
+		case fr.processed != nil && errors.Is(err, imap.ErrKeywordNotAllowed):
+			fr.history.add(fr.processed, imap.UID(msg))
+		case fr.processed != nil:
+			logger.Warnw("Failed to store processed keyword, recording message in the processed history", zap.Any("message", msg), zap.Error(err))
+			fr.history.add(fr.processed, imap.UID(msg))
+		default:
+			return ebooksProcessed, fmt.Errorf("failed to mark message as processed: %w", err)
+		}
+	}
+
+	if moveTo != "" {
+		logger.Infow("Moving message", zap.Any("message", msg), zap.String("mailbox", moveTo), zap.String("rule", moveRule))
+		if err := src.Move(msg, moveTo); err != nil {
+			return ebooksProcessed, fmt.Errorf("failed to move message to %s: %w", moveTo, err)
+		}
+		return ebooksProcessed, nil
+	}
+
+	if fr.deleteProcessed {
+		logger.Infow("Deleting message", zap.Any("message", msg))
+		if err := fr.src.Delete(msg); err != nil {
+			return ebooksProcessed, fmt.Errorf("failed to delete message: %w", err)
+		}
+	}
+	return ebooksProcessed, nil
+}
+
+// messageFailed reports an email that could not be processed and moves it to the failed folder when configured.
+// The email is also recorded in the failed history, so it is skipped on the next runs.
+func (fr *folderRun) messageFailed(msg *message.Message, err error) {
+	failedFolder := fr.account.IMAPConfig.FailedFolder
+	src, canMove := fr.src.(mover)
+	if !canMove {
+		failedFolder = ""
+	}
+	fr.logger.Errorw("Failed to process message, continuing with the next one", zap.Any("message", msg), zap.Error(err))
+
+	if fr.opts.DryRun {
+		fmt.Fprintf(fr.out, "Message %q from %s failed: %v\n", msg.Subject, msg.Sender, err)
+		if failedFolder != "" {
+			fmt.Fprintf(fr.out, "  would move message to %s\n", failedFolder)
+		} else {
+			fmt.Fprintln(fr.out, "  would remember message as failed, it is not processed again")
+		}
+		return
+	}
+	if failedFolder != "" {
+		fr.logger.Infow("Moving failed message", zap.Any("message", msg), zap.String("mailbox", failedFolder))
+		if err := src.Move(msg, failedFolder); err != nil {
+			fr.logger.Errorw("Failed to move message to the failed folder", zap.Any("message", msg), zap.String("mailbox", failedFolder), zap.Error(err))
+		}
+	}
+	// The message is remembered even after moving it, servers that cannot expunge it keep it in the folder.
+	// It is forgotten once it is no longer in the folder.
+	fr.history.addFailed(fr.failed, msg.ID)
+}
+
+// failedText describes the number of emails that failed, like "1 message failed"
+func failedText(messagesFailed int) string {
+	if messagesFailed == 1 {
+		return "1 message failed"
+	}
+	return strconv.Itoa(messagesFailed) + " messages failed"
+}
+
+// summarizeResults builds the message shown to the user after all accounts were processed
+func summarizeResults(results []accountResult, numberOfEbooksProcessed int, numberOfMessagesFailed int) string {
+	msg := "Processed " + strconv.Itoa(numberOfEbooksProcessed) + " new ebooks."
+	if numberOfMessagesFailed > 0 {
+		msg = "Processed " + strconv.Itoa(numberOfEbooksProcessed) + " new ebooks, " + failedText(numberOfMessagesFailed) + "."
+	}
+	if len(results) > 1 {
+		for _, result := range results {
+			msg += "\n" + result.account + ": " + strconv.Itoa(result.ebooksProcessed)
+			if result.messagesFailed > 0 {
+				msg += ", " + failedText(result.messagesFailed)
+			}
+		}
+	}
+	return msg
+}
+
+// Run executes the main KoboMail logic for every configured account.
+// Emails that cannot be processed are skipped and reported at the end, any other failure stops processing.
+// The ebooks saved before a failure are still added to the library.
+// The returned error is a *RunError with the exit code to use.
+// The processed email history is saved and all connections are closed before Run returns.
+func Run(opts RunOptions) error {
+	logger := zap.S()
+
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	if opts.DryRun {
+		logger.Infow("Running in dry-run mode, the mailbox and filesystem will not be modified")
 	}
 
+	// Show the user we are running opening a dialog
+	showDialog("Starting up, please wait.", false)
+
+	rules, err := compileRules(KoboMailConfig.Rules)
+	if err != nil {
+		const errMsg = "Invalid rules configuration"
+		showDialog(errMsg+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return runError(ExitConfigError, errMsg, err)
+	}
+	rc := &runContext{
+		opts:        opts,
+		out:         out,
+		rules:       rules,
+		collections: map[string][]string{},
+	}
+	// The processed email history, the downloaded WebDAV files and OPDS entries
+	// and the feed items share the state file
+	rc.state, err = state.Load(config.DefaultStateFile)
+	if err != nil {
+		const errMsg = "Failed to load state"
+		showDialog(errMsg+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return runError(ExitError, errMsg, err)
+	}
+	rc.history, err = loadProcessedHistory(rc.state)
+	if err != nil {
+		const errMsg = "Failed to load processed email history"
+		showDialog(errMsg+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return runError(ExitError, errMsg, err)
+	}
+	// Emails processed or failed before a failure must not be processed again on the next run
+	if !opts.DryRun {
+		defer func() {
+			if err := rc.history.save(); err != nil {
+				logger.Errorw("Failed to save processed email history", zap.Error(err))
+			}
+		}()
+	}
+
+	results, runErr := runSources(rc)
+
+	numberOfEmailsFound := 0
+	numberOfEbooksProcessed := 0
+	numberOfMessagesFailed := 0
+	for _, result := range results {
+		numberOfEmailsFound += result.emailsFound
+		numberOfEbooksProcessed += result.ebooksProcessed
+		numberOfMessagesFailed += result.messagesFailed
+	}
+
+	// Failed emails were skipped and are reported with the exit code, unless processing stopped on another failure
+	failed := runErr
+	if failed == nil && numberOfMessagesFailed > 0 {
+		failed = runError(ExitProcessingError, failedText(numberOfMessagesFailed)+", see the log for details", nil)
+	}
+
+	if opts.DryRun {
+		fmt.Fprintf(out, "Would process %d ebooks from %d emails.\n", numberOfEbooksProcessed, numberOfEmailsFound)
+		if numberOfMessagesFailed > 0 {
+			fmt.Fprintf(out, "%s.\n", failedText(numberOfMessagesFailed))
+		}
+		return failed
+	}
+
+	// The ebooks saved before a failure are added to the library as well
+	addToCollections(rc.collections)
 	if numberOfEbooksProcessed > 0 {
 		if useNickelDbus {
 			// Rescan the library for the new ebooks
@@ -266,7 +686,10 @@ func Run() {
 			}
 			logger.Debugw("Updated library")
 
-			var msg = "Processed " + strconv.Itoa(numberOfEbooksProcessed) + " new ebooks."
+			msg := summarizeResults(results, numberOfEbooksProcessed, numberOfMessagesFailed)
+			if runErr != nil {
+				msg += "\n" + runErr.Error()
+			}
 			showDialog(msg, true)
 			logger.Infow(msg)
 		} else {
@@ -274,17 +697,54 @@ func Run() {
 			// but only if there were any messages processed, no need to bug the user if there was nothing new
 			nickelUSBplugAddRemove()
 		}
-	} else {
+	} else if runErr == nil && numberOfMessagesFailed > 0 {
+		msg := summarizeResults(results, numberOfEbooksProcessed, numberOfMessagesFailed)
+		showDialog(msg, true)
+		logger.Warnw(msg)
+	} else if runErr == nil {
+		// Without new ebooks the dialog showing a failure is left open
 		const msg = "No emails found, nothing to be done."
 		showDialog(msg, true)
 		logger.Infow(msg)
 	}
+	return failed
 }
 
-// Uninstall removes all KoboMail resources except the library folder
-func Uninstall() {
-	// Remove integrations
-	udev.DeleteUdevRulesFile()
-	nickelmenu.DeleteConfigFile()
-	nickelseries.Uninstall()
+// runSources processes every account, the import folder, the WebDAV folders, the OPDS feeds and the feeds digest.
+// A source that fails does not stop the other sources, the first failure that is not limited to a single email is returned
+// with the results of all sources.
+func runSources(rc *runContext) ([]accountResult, error) {
+	var results []accountResult
+	var firstErr error
+	failed := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	add := func(result accountResult, err error) {
+		results = append(results, result)
+		failed(err)
+	}
+
+	for _, account := range KoboMailConfig.MailAccounts() {
+		add(runAccount(account, rc))
+	}
+	// Emails copied onto the Kobo are processed like the emails of an account
+	if helpers.FolderExists(config.ImportPath) {
+		result, err := runImport(rc)
+		if result.emailsFound > 0 {
+			results = append(results, result)
+		}
+		failed(err)
+	}
+	for _, folder := range KoboMailConfig.WebDAV {
+		add(runWebDAV(folder, rc))
+	}
+	for _, feed := range KoboMailConfig.OPDS {
+		add(runOPDS(feed, rc))
+	}
+	if len(KoboMailConfig.Feeds) > 0 {
+		add(runDigest(rc))
+	}
+	return results, firstErr
 }
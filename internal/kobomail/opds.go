@@ -0,0 +1,149 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"github.com/bjw-s/kobomail/pkg/message"
+	"github.com/bjw-s/kobomail/pkg/opds"
+	"go.uber.org/zap"
+)
+
+const opdsStateKey = "opds_entries"
+
+// runOPDS downloads the new entries of an OPDS feed.
+// Entries are downloaded once in the first of the allowed filetypes the catalog offers, an entry that fails is retried on the next run.
+// The filename of every downloaded entry is remembered by its ID.
+// On the first run the entries already in the feed are only remembered, unless download_existing is set.
+func runOPDS(feed config.OPDSConfig, rc *runContext) (accountResult, error) {
+	logger := zap.S().With(zap.String("opds", feed.Name))
+	result := accountResult{account: feed.Name}
+
+	client := opds.New(feed.User, string(feed.Password))
+	feedURL := feed.URL
+	var err error
+	if feed.Shelf != "" {
+		feedURL, err = client.Shelf(feed.URL, feed.Shelf)
+	}
+	var entries []opds.Entry
+	if err == nil {
+		entries, err = client.Entries(feedURL)
+	}
+	if err != nil {
+		var errMsg = fmt.Sprintf("Failed to read OPDS feed %s, please check internet connection and credentials", feed.Name)
+		showDialog(errMsg+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitConnectionError, errMsg, err)
+	}
+	logger.Infow("Read OPDS feed", zap.String("url", feedURL), zap.Int("number_of_entries", len(entries)))
+
+	// Entries that left the feed are remembered as well, they might show up again on another page
+	source := loadRemoteSource(rc, logger, "OPDS feed", feed.Name, opdsStateKey)
+	downloaded, ranBefore := source.downloaded()
+	if !ranBefore && !feed.DownloadExisting {
+		for _, entry := range entries {
+			downloaded[entry.ID] = ""
+		}
+		logger.Infow("First run of the OPDS feed, remembering the existing entries without downloading them", zap.Int("number_of_entries", len(entries)))
+		if rc.opts.DryRun {
+			fmt.Fprintf(rc.out, "OPDS feed %s: first run, would remember %d existing entries without downloading them\n", feed.Name, len(entries))
+		}
+		source.save(downloaded)
+		return result, nil
+	}
+
+	libraryPath := KoboMailConfig.OPDSLibraryPath(feed)
+	filetypes := KoboMailConfig.OPDSFiletypes(feed)
+	// Different entries can have the same title, every entry gets its own file
+	taken := map[string]bool{}
+	var downloads []remoteDownload
+	for _, entry := range entries {
+		if _, ok := downloaded[entry.ID]; ok || len(entry.Acquisitions()) == 0 {
+			continue
+		}
+		link, ok := preferredAcquisition(entry, filetypes)
+		if !ok {
+			logger.Debugw("Skipping entry without an allowed filetype", zap.String("title", entry.Title))
+			continue
+		}
+		filename := opdsFilename(entry, link)
+		attachment := message.NewRemoteAttachment(filename, link.Type, link.Length, func() (io.ReadCloser, error) {
+			return client.Download(link)
+		})
+		plan := planAttachment(rc.rules, messageInfo{}, attachment, libraryPath, filetypes)
+		if plan.Skip {
+			logger.Debugw("Skipping entry", zap.String("title", entry.Title), zap.String("rule", plan.Rule))
+			continue
+		}
+		plan.Path = uniquePath(plan.Path, taken)
+		taken[plan.Path] = true
+
+		entry := entry
+		downloads = append(downloads, remoteDownload{
+			plan: plan,
+			name: entry.Title,
+			saved: func() {
+				downloaded[entry.ID] = filepath.Base(plan.Path)
+			},
+		})
+	}
+
+	err = source.download(libraryPath, downloads, &result)
+	source.save(downloaded)
+	return result, err
+}
+
+// opdsFilename returns the filename of the entry downloaded with link.
+// Entries without a title are named after the file of the link, or after their ID.
+func opdsFilename(entry opds.Entry, link opds.Link) string {
+	name := strings.TrimSpace(entry.Title)
+	if name == "" {
+		if u, err := url.Parse(link.Href); err == nil {
+			base := path.Base(u.Path)
+			name = strings.TrimSpace(strings.TrimSuffix(base, path.Ext(base)))
+			if name == "." || name == "/" {
+				name = ""
+			}
+		}
+	}
+	if name == "" {
+		name = strings.TrimSpace(entry.ID)
+	}
+	return filenameReplacer.Replace(name) + "." + link.Extension()
+}
+
+// uniquePath returns path, or path with a number added to the name when a file exists at path or path is taken
+func uniquePath(path string, taken map[string]bool) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	// .kepub.epub files keep their double extension
+	if strings.HasSuffix(base, ".kepub") {
+		ext = ".kepub" + ext
+		base = strings.TrimSuffix(base, ".kepub")
+	}
+	unique := path
+	for i := 2; taken[unique] || helpers.FileExists(unique); i++ {
+		unique = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	return unique
+}
+
+// preferredAcquisition returns the acquisition link of the entry with the first filetype in filetypes the catalog offers
+func preferredAcquisition(entry opds.Entry, filetypes []string) (opds.Link, bool) {
+	for _, filetype := range filetypes {
+		for _, link := range entry.Acquisitions() {
+			if strings.EqualFold(link.Extension(), filetype) {
+				return link, true
+			}
+		}
+	}
+	return opds.Link{}, false
+}
@@ -0,0 +1,28 @@
+package kobomail
+
+import (
+	"testing"
+
+	"github.com/bjw-s/kobomail/pkg/opds"
+)
+
+func TestOPDSFilename(t *testing.T) {
+	link := opds.Link{Href: "https://books.example.org/download/42/Dune%20Messiah.epub?key=1", Type: "application/epub+zip"}
+	tests := []struct {
+		name  string
+		entry opds.Entry
+		link  opds.Link
+		want  string
+	}{
+		{"title", opds.Entry{ID: "urn:uuid:1", Title: " Dune: Messiah "}, link, "Dune_ Messiah.epub"},
+		{"link filename", opds.Entry{ID: "urn:uuid:1"}, link, "Dune Messiah.epub"},
+		{"entry id", opds.Entry{ID: "urn:uuid:1", Title: " "}, opds.Link{Href: "https://books.example.org/", Type: "application/epub+zip"}, "urn_uuid_1.epub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := opdsFilename(tt.entry, tt.link); got != tt.want {
+				t.Errorf("opdsFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
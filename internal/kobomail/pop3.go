@@ -0,0 +1,112 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/pop3"
+	"go.uber.org/zap"
+)
+
+// pop3HistoryFolder is the folder name the processed messages of a POP3 maildrop are recorded under
+const pop3HistoryFolder = "POP3"
+
+// runPOP3Account processes all matching emails in the maildrop of a POP3 account.
+// POP3 cannot search or flag emails, so the emails are filtered on the Kobo and processed emails are remembered by UIDL.
+func runPOP3Account(account config.AccountConfig, rc *runContext) (accountResult, error) {
+	logger := zap.S().With(zap.String("account", account.Name))
+	imapConfig := account.IMAPConfig
+	result := accountResult{account: account.Name}
+	warnIgnoredPOP3Settings(account, logger)
+
+	client, err := pop3.Dial(imapConfig.IMAPHost, imapConfig.IMAPPort, pop3.Security(imapConfig.POP3Security))
+	if err != nil {
+		var errMsg = fmt.Sprintf(
+			"Failed to connect to %s:%v, please check internet connection",
+			imapConfig.IMAPHost,
+			imapConfig.IMAPPort,
+		)
+		showDialog(errMsg, true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitConnectionError, errMsg, err)
+	}
+	logger.Infow(
+		"Connected to POP3 server",
+		zap.String("host", imapConfig.IMAPHost),
+		zap.Int("port", imapConfig.IMAPPort),
+	)
+	defer func() {
+		if err := client.Quit(); err != nil {
+			logger.Warnw("Failed to end POP3 session", zap.Error(err))
+		}
+	}()
+
+	if err := client.Login(imapConfig.IMAPUser, string(imapConfig.IMAPPwd)); err != nil {
+		const errMsg = "Failed to authenticate to POP3 server"
+		showDialog(errMsg+" as "+imapConfig.IMAPUser+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitConnectionError, errMsg+" as "+imapConfig.IMAPUser, err)
+	}
+	logger.Infow("Authenticated to POP3 server", zap.String("user", imapConfig.IMAPUser))
+
+	processed := rc.history.mailbox(account, pop3HistoryFolder, 0)
+	for _, uidl := range processed.UIDLs {
+		client.Done[uidl] = true
+	}
+	client.ReadOnly = rc.opts.DryRun
+
+	messages, err := client.List()
+	if err != nil {
+		const errMsg = "Failed to fetch messages"
+		showDialog(errMsg+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitMailboxError, errMsg+" from the POP3 maildrop", err)
+	}
+
+	// Emails without the flag are remembered as well, so their headers are not downloaded again on the next run
+	matching := messages[:0]
+	for _, msg := range messages {
+		if matchesEmailFlag(account, msg) {
+			matching = append(matching, msg)
+			continue
+		}
+		logger.Debugw("Skipping message without the email flag", zap.String("uidl", msg.ID))
+		if !rc.opts.DryRun {
+			_ = client.MarkDone(msg)
+		}
+	}
+
+	fr := &folderRun{
+		runContext:      rc,
+		src:             client,
+		account:         account,
+		folder:          config.FolderConfig{Name: "INBOX"},
+		libraryPath:     KoboMailConfig.AccountLibraryPath(account),
+		logger:          logger,
+		deleteProcessed: KoboMailConfig.ProcessingConfig.EmailDelete || !imapConfig.POP3LeaveOnServer,
+	}
+	err = processMessages(fr, matching, &result)
+
+	if !rc.opts.DryRun {
+		rc.history.setUIDLs(processed, client.DoneUIDLs())
+	}
+	return result, err
+}
+
+// warnIgnoredPOP3Settings warns about IMAP settings that have no effect on a POP3 account
+func warnIgnoredPOP3Settings(account config.AccountConfig, logger *zap.SugaredLogger) {
+	imapConfig := account.IMAPConfig
+	ignored := map[string]bool{
+		"imap_folders":      len(imapConfig.IMAPFolders) > 0,
+		"search":            !reflect.DeepEqual(imapConfig.Search, config.SearchConfig{}),
+		"processed_keyword": imapConfig.ProcessedKeyword != "",
+		"failed_folder":     imapConfig.FailedFolder != "",
+	}
+	for _, setting := range []string{"imap_folders", "search", "processed_keyword", "failed_folder"} {
+		if ignored[setting] {
+			logger.Warnw("Setting is not supported by POP3 servers, ignoring it", zap.String("setting", setting))
+		}
+	}
+}
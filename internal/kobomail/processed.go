@@ -0,0 +1,147 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/state"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	processedHistoryKey = "processed_uids"
+	failedMessagesKey   = "failed_messages"
+)
+
+// mailboxHistory lists the UIDs processed in a mailbox, UIDs are only valid for a single UIDVALIDITY.
+// POP3 maildrops have no UIDs, their processed messages are listed by UIDL.
+// The import folder lists the handled messages of files that are still in place by their message ID.
+type mailboxHistory struct {
+	UIDValidity uint32   `json:"uid_validity"`
+	UIDs        []uint32 `json:"uids,omitempty"`
+	UIDLs       []string `json:"uidls,omitempty"`
+}
+
+// failedMailbox lists the messages of a mailbox that failed, they are not processed again.
+// Messages are identified by the ID of their source, IMAP UIDs are only valid for a single UIDVALIDITY.
+type failedMailbox struct {
+	UIDValidity uint32   `json:"uid_validity,omitempty"`
+	IDs         []string `json:"ids"`
+}
+
+// processedHistory keeps the UIDs of processed messages for servers that cannot store the processed keyword,
+// and the messages that failed on any source. Mailboxes are keyed by account and folder name.
+type processedHistory struct {
+	store     *state.Store
+	mailboxes map[string]*mailboxHistory
+	failed    map[string]*failedMailbox
+	changed   bool
+}
+
+// loadProcessedHistory reads the processed and failed message history from the state store
+func loadProcessedHistory(store *state.Store) (*processedHistory, error) {
+	history := &processedHistory{
+		store:     store,
+		mailboxes: map[string]*mailboxHistory{},
+		failed:    map[string]*failedMailbox{},
+	}
+	if _, err := store.Get(processedHistoryKey, &history.mailboxes); err != nil {
+		return nil, err
+	}
+	if _, err := store.Get(failedMessagesKey, &history.failed); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func processedHistoryMailbox(account config.AccountConfig, folder string) string {
+	return account.Name + "/" + folder
+}
+
+// mailbox returns the history of the mailbox, the history is reset when the UIDVALIDITY changed
+func (h *processedHistory) mailbox(account config.AccountConfig, folder string, uidValidity uint32) *mailboxHistory {
+	key := processedHistoryMailbox(account, folder)
+	mbox, ok := h.mailboxes[key]
+	if !ok || mbox.UIDValidity != uidValidity {
+		mbox = &mailboxHistory{UIDValidity: uidValidity}
+		h.mailboxes[key] = mbox
+		h.changed = h.changed || ok
+	}
+	return mbox
+}
+
+// contains returns if the message was already processed
+func (m *mailboxHistory) contains(uid uint32) bool {
+	return slices.Contains(m.UIDs, uid)
+}
+
+// add records the message as processed
+func (h *processedHistory) add(m *mailboxHistory, uid uint32) {
+	if m.contains(uid) {
+		return
+	}
+	m.UIDs = append(m.UIDs, uid)
+	h.changed = true
+}
+
+// setUIDLs replaces the processed POP3 messages or the handled messages of the import folder
+func (h *processedHistory) setUIDLs(m *mailboxHistory, uidls []string) {
+	if slices.Equal(m.UIDLs, uidls) {
+		return
+	}
+	m.UIDLs = uidls
+	h.changed = true
+}
+
+// failedMailbox returns the messages that failed in the mailbox, the list is reset when the UIDVALIDITY changed
+func (h *processedHistory) failedMailbox(account config.AccountConfig, folder string, uidValidity uint32) *failedMailbox {
+	key := processedHistoryMailbox(account, folder)
+	mbox, ok := h.failed[key]
+	if !ok || mbox.UIDValidity != uidValidity {
+		mbox = &failedMailbox{UIDValidity: uidValidity}
+		h.failed[key] = mbox
+		h.changed = h.changed || ok
+	}
+	return mbox
+}
+
+// contains returns if the message failed before
+func (m *failedMailbox) contains(id string) bool {
+	return slices.Contains(m.IDs, id)
+}
+
+// addFailed records the message as failed
+func (h *processedHistory) addFailed(m *failedMailbox, id string) {
+	if m.contains(id) {
+		return
+	}
+	m.IDs = append(m.IDs, id)
+	h.changed = true
+}
+
+// pruneFailed forgets the failed messages that are not listed anymore, like the ones that were deleted
+func (h *processedHistory) pruneFailed(m *failedMailbox, listed []string) {
+	kept := m.IDs[:0]
+	for _, id := range m.IDs {
+		if slices.Contains(listed, id) {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) != len(m.IDs) {
+		h.changed = true
+	}
+	m.IDs = kept
+}
+
+// save writes the history to the state file when it changed
+func (h *processedHistory) save() error {
+	if !h.changed {
+		return nil
+	}
+	if err := h.store.Set(processedHistoryKey, h.mailboxes); err != nil {
+		return err
+	}
+	if err := h.store.Set(failedMessagesKey, h.failed); err != nil {
+		return err
+	}
+	return h.store.Save()
+}
@@ -0,0 +1,189 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"github.com/bjw-s/kobomail/pkg/message"
+)
+
+// rule is a compiled routing rule
+type rule struct {
+	config.RuleConfig
+	from    *regexp.Regexp
+	to      *regexp.Regexp
+	subject *regexp.Regexp
+}
+
+// messageInfo holds the message details rules match on
+type messageInfo struct {
+	Sender     string
+	Recipients []string
+	Subject    string
+}
+
+// attachmentPlan describes what happens to a single attachment
+type attachmentPlan struct {
+	Attachment message.Attachment
+	Skip       bool
+	Path       string
+	Collection string
+	Rule       string
+}
+
+func compileRules(ruleConfigs []config.RuleConfig) ([]*rule, error) {
+	var rules []*rule
+	for _, rc := range ruleConfigs {
+		r := &rule{RuleConfig: rc}
+		var err error
+		if r.from, err = compileOptionalRegexp(rc.From); err != nil {
+			return nil, err
+		}
+		if r.to, err = compileOptionalRegexp(rc.To); err != nil {
+			return nil, err
+		}
+		if r.subject, err = compileOptionalRegexp(rc.Subject); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func compileOptionalRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// matchesMessage returns if the message conditions of the rule match
+func (r *rule) matchesMessage(msg messageInfo) bool {
+	if r.from != nil && !r.from.MatchString(msg.Sender) {
+		return false
+	}
+	if r.subject != nil && !r.subject.MatchString(msg.Subject) {
+		return false
+	}
+	if r.to != nil {
+		matched := false
+		for _, recipient := range msg.Recipients {
+			if r.to.MatchString(recipient) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAttachment returns if the attachment conditions of the rule match
+func (r *rule) matchesAttachment(attachment message.Attachment) bool {
+	if len(r.Extensions) > 0 && !helpers.ContainsFiletype(r.Extensions, strings.ToLower(attachment.Extension)) {
+		return false
+	}
+	if len(r.MIMETypes) > 0 {
+		matched := false
+		for _, pattern := range r.MIMETypes {
+			if ok, _ := path.Match(pattern, attachment.MIMEType); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.MinSize > 0 && attachment.Size() < r.MinSize {
+		return false
+	}
+	if r.MaxSize > 0 && attachment.Size() > r.MaxSize {
+		return false
+	}
+	return true
+}
+
+// hasAttachmentConditions returns if the rule only applies to some attachments
+func (r *rule) hasAttachmentConditions() bool {
+	return len(r.Extensions) > 0 || len(r.MIMETypes) > 0 || r.MinSize > 0 || r.MaxSize > 0
+}
+
+// attachmentFilename returns the name the attachment is saved as
+func attachmentFilename(attachment message.Attachment, renameToKepub bool) string {
+	filename := filepath.Base(attachment.Filename)
+	switch {
+	// Check if the file is a kepub, rename it to .kepub.epub so kobo can properly handle it
+	case attachment.Extension == "kepub":
+		return filename + ".epub"
+	// Let Nickel open the epub with the kepub renderer, the file itself is not converted
+	case renameToKepub && attachment.Extension == "epub" && !strings.HasSuffix(filename, ".kepub.epub"):
+		return strings.TrimSuffix(filename, ".epub") + ".kepub.epub"
+	}
+	return filename
+}
+
+// planAttachment decides what happens to an attachment, the first matching rule wins.
+// Rules without extensions or mime_types only save the filetypes that are allowed, like the attachments
+// that don't match any rule, which are saved to libraryPath.
+func planAttachment(rules []*rule, msg messageInfo, attachment message.Attachment, libraryPath string, filetypes []string) attachmentPlan {
+	plan := attachmentPlan{Attachment: attachment}
+	allowed := helpers.ContainsFiletype(filetypes, attachment.Extension)
+
+	for _, r := range rules {
+		if !r.matchesMessage(msg) || !r.matchesAttachment(attachment) {
+			continue
+		}
+		if r.Action == config.RuleActionSkip {
+			plan.Rule = r.Name
+			plan.Skip = true
+			return plan
+		}
+		if !allowed && len(r.Extensions) == 0 && len(r.MIMETypes) == 0 {
+			continue
+		}
+		plan.Rule = r.Name
+
+		destination := libraryPath
+		if r.SaveTo != "" {
+			destination = filepath.Join(KoboMailConfig.ApplicationConfig.LibraryPath, r.SaveTo)
+		}
+		plan.Path = filepath.Join(destination, attachmentFilename(attachment, r.RenameToKepub))
+		plan.Collection = r.Collection
+		return plan
+	}
+
+	if !allowed {
+		plan.Skip = true
+		return plan
+	}
+	plan.Path = filepath.Join(libraryPath, attachmentFilename(attachment, false))
+	return plan
+}
+
+// planMessageMove returns the mailbox the message should be moved to after processing, if any.
+// The first rule with move_to matching the message wins, rules with attachment conditions
+// also need to match at least one of the attachments.
+func planMessageMove(rules []*rule, msg messageInfo, attachments []message.Attachment) (mailbox string, ruleName string) {
+	for _, r := range rules {
+		if r.MoveTo == "" || !r.matchesMessage(msg) {
+			continue
+		}
+		if !r.hasAttachmentConditions() {
+			return r.MoveTo, r.Name
+		}
+		for _, attachment := range attachments {
+			if r.matchesAttachment(attachment) {
+				return r.MoveTo, r.Name
+			}
+		}
+	}
+	return "", ""
+}
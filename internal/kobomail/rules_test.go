@@ -0,0 +1,137 @@
+package kobomail
+
+import (
+	"testing"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/message"
+)
+
+func newAttachment(filename string, extension string, mimeType string, size int) message.Attachment {
+	return message.Attachment{Filename: filename, Extension: extension, MIMEType: mimeType, Content: make([]byte, size)}
+}
+
+func TestPlanAttachment(t *testing.T) {
+	KoboMailConfig = &config.Config{}
+	KoboMailConfig.ApplicationConfig.LibraryPath = "/mnt/onboard"
+
+	rules, err := compileRules([]config.RuleConfig{
+		{Name: "no-samples", Subject: `(?i)sample`, Action: config.RuleActionSkip},
+		{Name: "comics", Extensions: []string{"cbz"}, Action: config.RuleActionSave, SaveTo: "Comics", Collection: "Comics"},
+		{Name: "big-pdfs", MIMETypes: []string{"application/pdf"}, MinSize: 100, Action: config.RuleActionSave, SaveTo: "Papers"},
+		{Name: "alice", From: `@example\.org$`, Action: config.RuleActionSave, SaveTo: "Alice", RenameToKepub: true},
+	})
+	if err != nil {
+		t.Fatalf("compileRules: %v", err)
+	}
+
+	filetypes := []string{"epub", "kepub"}
+	alice := messageInfo{Sender: "alice@example.org", Subject: "A book"}
+	bob := messageInfo{Sender: "bob@example.com", Subject: "A book"}
+	tests := []struct {
+		name           string
+		msg            messageInfo
+		attachment     message.Attachment
+		wantSkip       bool
+		wantPath       string
+		wantRule       string
+		wantCollection string
+	}{
+		{
+			name:       "no matching rule",
+			msg:        bob,
+			attachment: newAttachment("dune.epub", "epub", "application/epub+zip", 10),
+			wantPath:   "/library/dune.epub",
+		},
+		{
+			name:       "kepub is renamed",
+			msg:        bob,
+			attachment: newAttachment("dune.kepub", "kepub", "application/kepub+zip", 10),
+			wantPath:   "/library/dune.kepub.epub",
+		},
+		{
+			name:       "filetype not allowed",
+			msg:        bob,
+			attachment: newAttachment("notes.txt", "txt", "text/plain", 10),
+			wantSkip:   true,
+		},
+		{
+			name:       "skip rule",
+			msg:        messageInfo{Sender: "alice@example.org", Subject: "Sample chapter"},
+			attachment: newAttachment("dune.epub", "epub", "application/epub+zip", 10),
+			wantSkip:   true,
+			wantRule:   "no-samples",
+		},
+		{
+			name:           "rule with extensions saves other filetypes",
+			msg:            bob,
+			attachment:     newAttachment("batman.cbz", "cbz", "application/x-cbz", 10),
+			wantPath:       "/mnt/onboard/Comics/batman.cbz",
+			wantRule:       "comics",
+			wantCollection: "Comics",
+		},
+		{
+			name:       "rule with mime types and a size",
+			msg:        bob,
+			attachment: newAttachment("paper.pdf", "pdf", "application/pdf", 200),
+			wantPath:   "/mnt/onboard/Papers/paper.pdf",
+			wantRule:   "big-pdfs",
+		},
+		{
+			name:       "attachment below the minimum size",
+			msg:        bob,
+			attachment: newAttachment("paper.pdf", "pdf", "application/pdf", 50),
+			wantSkip:   true,
+		},
+		{
+			name:       "rule without attachment conditions and rename_to_kepub",
+			msg:        alice,
+			attachment: newAttachment("dune.epub", "epub", "application/epub+zip", 10),
+			wantPath:   "/mnt/onboard/Alice/dune.kepub.epub",
+			wantRule:   "alice",
+		},
+		{
+			name:       "rule without attachment conditions keeps the filetypes",
+			msg:        alice,
+			attachment: newAttachment("logo.png", "png", "image/png", 10),
+			wantSkip:   true,
+		},
+		{
+			name:       "filename with a path",
+			msg:        bob,
+			attachment: newAttachment("../../etc/dune.epub", "epub", "application/epub+zip", 10),
+			wantPath:   "/library/dune.epub",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := planAttachment(rules, tt.msg, tt.attachment, "/library", filetypes)
+			if plan.Skip != tt.wantSkip || plan.Path != tt.wantPath || plan.Rule != tt.wantRule || plan.Collection != tt.wantCollection {
+				t.Errorf("planAttachment() = skip %v, path %q, rule %q, collection %q, want skip %v, path %q, rule %q, collection %q",
+					plan.Skip, plan.Path, plan.Rule, plan.Collection, tt.wantSkip, tt.wantPath, tt.wantRule, tt.wantCollection)
+			}
+		})
+	}
+}
+
+func TestPlanMessageMove(t *testing.T) {
+	rules, err := compileRules([]config.RuleConfig{
+		{Name: "comics", Extensions: []string{"cbz"}, MoveTo: "Comics"},
+		{Name: "alice", From: `^alice@`, MoveTo: "Alice"},
+	})
+	if err != nil {
+		t.Fatalf("compileRules: %v", err)
+	}
+	comic := []message.Attachment{newAttachment("batman.cbz", "cbz", "application/x-cbz", 10)}
+	book := []message.Attachment{newAttachment("dune.epub", "epub", "application/epub+zip", 10)}
+
+	if mailbox, rule := planMessageMove(rules, messageInfo{Sender: "alice@example.org"}, comic); mailbox != "Comics" || rule != "comics" {
+		t.Errorf("comic from alice moved to %q by %q, want Comics", mailbox, rule)
+	}
+	if mailbox, rule := planMessageMove(rules, messageInfo{Sender: "alice@example.org"}, book); mailbox != "Alice" || rule != "alice" {
+		t.Errorf("book from alice moved to %q by %q, want Alice", mailbox, rule)
+	}
+	if mailbox, _ := planMessageMove(rules, messageInfo{Sender: "bob@example.org"}, book); mailbox != "" {
+		t.Errorf("book from bob moved to %q, want no move", mailbox)
+	}
+}
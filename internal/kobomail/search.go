@@ -0,0 +1,101 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/imap"
+	goimap "github.com/emersion/go-imap"
+	"go.uber.org/zap"
+)
+
+// applySearchCriteria sets the search criteria configured for the account on the IMAP connection
+func applySearchCriteria(imapConnection *imap.Connection, account config.AccountConfig) {
+	imapConfig := account.IMAPConfig
+	criteria := imapConnection.SearchCriteria
+	// Messages moved or deleted earlier stay flagged as deleted on servers that do not expunge them
+	criteria.WithoutFlags = append(criteria.WithoutFlags, "\\Deleted")
+	if imapConfig.EmailUnseen {
+		criteria.WithoutFlags = append(criteria.WithoutFlags, "\\Seen")
+	}
+	if imapConfig.EmailFlagType == config.EmailFlagTypePlus {
+		criterium := strings.Replace(imapConfig.IMAPUser, "@", "+"+imapConfig.EmailFlag+"@", 1)
+		criteria.Header.Add("TO", criterium)
+	} else if imapConfig.EmailFlagType == config.EmailFlagTypeSubject {
+		criterium := imapConfig.EmailFlag
+		criteria.Header.Add("SUBJECT", criterium)
+	}
+	if imapConfig.ProcessedKeyword != "" {
+		criteria.WithoutFlags = append(criteria.WithoutFlags, imapConfig.ProcessedKeyword)
+	}
+
+	search := imapConfig.Search
+	addSearchCriteria(criteria, search, time.Now())
+
+	if search.GmailRaw == "" && len(search.GmailLabels) == 0 {
+		return
+	}
+	supported, err := imapConnection.Supports(imap.GmailExtensionCapability)
+	if err != nil || !supported {
+		zap.S().Warnw("Server does not support the Gmail extensions, ignoring gmail_raw and gmail_labels",
+			zap.String("account", account.Name),
+		)
+		return
+	}
+	imapConnection.GmailRaw = search.GmailRaw
+	imapConnection.GmailLabels = search.GmailLabels
+}
+
+// addSearchCriteria adds the configured search to the IMAP search criteria
+func addSearchCriteria(criteria *goimap.SearchCriteria, search config.SearchConfig, now time.Time) {
+	if search.From != "" {
+		criteria.Header.Add("FROM", search.From)
+	}
+	if search.To != "" {
+		criteria.Header.Add("TO", search.To)
+	}
+	if search.Subject != "" {
+		criteria.Header.Add("SUBJECT", search.Subject)
+	}
+	// Dates are checked during configuration validation
+	if search.Since != "" {
+		criteria.Since, _ = config.ParseSearchDate(search.Since, now)
+	}
+	if search.Before != "" {
+		criteria.Before, _ = config.ParseSearchDate(search.Before, now)
+	}
+	if search.Larger > 0 {
+		criteria.Larger = search.Larger
+	}
+	if search.Smaller > 0 {
+		criteria.Smaller = search.Smaller
+	}
+	criteria.WithFlags = append(criteria.WithFlags, search.Keywords...)
+	criteria.WithoutFlags = append(criteria.WithoutFlags, search.WithoutKeywords...)
+
+	switch len(search.Or) {
+	case 0:
+	case 1:
+		// A single alternative has to match as well
+		addSearchCriteria(criteria, search.Or[0], now)
+	default:
+		var alternatives []*goimap.SearchCriteria
+		for _, alternative := range search.Or {
+			c := goimap.NewSearchCriteria()
+			addSearchCriteria(c, alternative, now)
+			alternatives = append(alternatives, c)
+		}
+		criteria.Or = append(criteria.Or, orCriteria(alternatives)[0])
+	}
+}
+
+// orCriteria folds the alternatives into nested pairs, as IMAP OR only takes two search keys
+func orCriteria(alternatives []*goimap.SearchCriteria) [][2]*goimap.SearchCriteria {
+	if len(alternatives) == 2 {
+		return [][2]*goimap.SearchCriteria{{alternatives[0], alternatives[1]}}
+	}
+	rest := &goimap.SearchCriteria{Or: orCriteria(alternatives[1:])}
+	return [][2]*goimap.SearchCriteria{{alternatives[0], rest}}
+}
@@ -0,0 +1,76 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"go.uber.org/zap"
+)
+
+// bundledCACertificatesPath is the CA bundle shipped with KoboMail for devices without one
+const bundledCACertificatesPath = config.InstallPath + "/ssl/certs/ca-certificates.crt"
+
+func ensureFolder(path string, actions []string) ([]string, error) {
+	if helpers.FolderExists(path) {
+		return actions, nil
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return actions, fmt.Errorf("failed to create folder %s: %w", path, err)
+	}
+	return append(actions, "created folder "+path), nil
+}
+
+func ensureFile(path string, content []byte, actions []string) ([]string, error) {
+	if helpers.FileExists(path) {
+		return actions, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return actions, fmt.Errorf("failed to create folder for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return actions, fmt.Errorf("failed to create file %s: %w", path, err)
+	}
+	return append(actions, "created file "+path), nil
+}
+
+// Init prepares the device for KoboMail: it creates the addon and library folders,
+// deploys the configuration template and installs CA certificates when they are missing.
+// It is safe to run multiple times and returns what it did.
+func Init() ([]string, error) {
+	logger := zap.S()
+	var actions []string
+	var err error
+
+	if actions, err = ensureFolder(config.DefaultAddonPath, actions); err != nil {
+		return actions, err
+	}
+	if actions, err = ensureFile(config.DefaultConfigFile, config.ConfigTemplate, actions); err != nil {
+		return actions, err
+	}
+	if actions, err = ensureFile(config.DefaultLogFile, nil, actions); err != nil {
+		return actions, err
+	}
+	if actions, err = ensureFolder(KoboMailConfig.ApplicationConfig.LibraryPath, actions); err != nil {
+		return actions, err
+	}
+
+	if !helpers.FileExists(config.CACertificatesPath) {
+		logger.Infow("CA certificates not found, installing bundled certificates", zap.String("file", config.CACertificatesPath))
+		certificates, err := os.ReadFile(bundledCACertificatesPath)
+		if err != nil {
+			return actions, fmt.Errorf("failed to read bundled CA certificates: %w", err)
+		}
+		if actions, err = ensureFile(config.CACertificatesPath, certificates, actions); err != nil {
+			return actions, err
+		}
+	}
+
+	for _, action := range actions {
+		logger.Infow("Setup: " + action)
+	}
+	return actions, nil
+}
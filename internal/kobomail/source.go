@@ -0,0 +1,48 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"strings"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/message"
+)
+
+// Source is a mailbox KoboMail collects emails from, like an IMAP folder or a POP3 maildrop.
+// Every email that is listed goes through the same attachment processing, whatever its source.
+type Source interface {
+	// List returns the emails to process, only their headers are available
+	List() ([]*message.Message, error)
+	// Fetch downloads the full email, so its attachments can be read
+	Fetch(msg *message.Message) error
+	// MarkDone marks the email as processed, so it is not listed again
+	MarkDone(msg *message.Message) error
+	// Delete removes the email from the source
+	Delete(msg *message.Message) error
+}
+
+// mover is implemented by sources that can move emails to another folder, like IMAP
+type mover interface {
+	Move(msg *message.Message, folder string) error
+}
+
+// matchesEmailFlag returns if the email carries the configured flag.
+// Sources that cannot search on the server, like POP3, use it to find the emails meant for the Kobo.
+func matchesEmailFlag(account config.AccountConfig, msg *message.Message) bool {
+	imapConfig := account.IMAPConfig
+	flag := strings.ToLower(imapConfig.EmailFlag)
+	switch imapConfig.EmailFlagType {
+	case config.EmailFlagTypePlus:
+		// Only the plus address itself matches, like the server side search
+		address := strings.Replace(strings.ToLower(imapConfig.IMAPUser), "@", "+"+flag+"@", 1)
+		for _, recipient := range msg.Recipients {
+			if strings.EqualFold(strings.TrimSpace(recipient), address) {
+				return true
+			}
+		}
+		return false
+	case config.EmailFlagTypeSubject:
+		return strings.Contains(strings.ToLower(msg.Subject), flag)
+	}
+	return false
+}
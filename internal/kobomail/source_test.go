@@ -0,0 +1,43 @@
+package kobomail
+
+import (
+	"testing"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/message"
+)
+
+func TestMatchesEmailFlag(t *testing.T) {
+	plus := config.AccountConfig{}
+	plus.IMAPConfig.IMAPUser = "Kobo@Example.org"
+	plus.IMAPConfig.EmailFlagType = config.EmailFlagTypePlus
+	plus.IMAPConfig.EmailFlag = "kobo"
+
+	subject := config.AccountConfig{}
+	subject.IMAPConfig.EmailFlagType = config.EmailFlagTypeSubject
+	subject.IMAPConfig.EmailFlag = "Kobo"
+
+	tests := []struct {
+		name    string
+		account config.AccountConfig
+		msg     message.Message
+		want    bool
+	}{
+		{"plus address", plus, message.Message{Recipients: []string{"friend@example.org", "kobo+KOBO@example.org"}}, true},
+		{"plain address", plus, message.Message{Recipients: []string{"kobo@example.org"}}, false},
+		{"other plus address", plus, message.Message{Recipients: []string{"kobo+news@example.org"}}, false},
+		{"longer flag", plus, message.Message{Recipients: []string{"kobo+kobold@example.org"}}, false},
+		{"longer address", plus, message.Message{Recipients: []string{"mykobo+kobo@example.org", "kobo+kobo@example.org.net"}}, false},
+		{"no recipients", plus, message.Message{Subject: "kobo"}, false},
+		{"subject", subject, message.Message{Subject: "[KOBO] A book"}, true},
+		{"other subject", subject, message.Message{Subject: "A book", Recipients: []string{"kobo+kobo@example.org"}}, false},
+		{"no flag type", config.AccountConfig{}, message.Message{Subject: "kobo"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesEmailFlag(tt.account, &tt.msg); got != tt.want {
+				t.Errorf("matchesEmailFlag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"github.com/bjw-s/kobomail/pkg/nickelmenu"
+	"github.com/bjw-s/kobomail/pkg/nickelseries"
+	"github.com/bjw-s/kobomail/pkg/udev"
+	"go.uber.org/zap"
+)
+
+// UninstallOptions controls what Uninstall removes
+type UninstallOptions struct {
+	RemoveLibrary bool
+	KeepConfig    bool
+	DryRun        bool
+}
+
+// UninstallTargets returns all files and folders Uninstall would remove that are present on the device
+func UninstallTargets(opts UninstallOptions) []string {
+	candidates := []string{
+		udev.RulesFilePath,
+		config.CACertificatesPath,
+		config.InstallPath,
+		nickelseries.BinaryPath,
+		nickelmenu.ConfigFilePath,
+	}
+	if !opts.KeepConfig {
+		candidates = append(candidates, config.DefaultAddonPath)
+	}
+	if opts.RemoveLibrary {
+		candidates = append(candidates, KoboMailConfig.ApplicationConfig.LibraryPath)
+	}
+
+	var targets []string
+	for _, candidate := range candidates {
+		if helpers.FileExists(candidate) || helpers.FolderExists(candidate) {
+			targets = append(targets, candidate)
+		}
+	}
+	return targets
+}
+
+// Uninstall removes all KoboMail resources and returns what was removed.
+// When DryRun is set nothing is removed and the returned list contains what would be removed.
+func Uninstall(opts UninstallOptions) ([]string, error) {
+	logger := zap.S()
+	targets := UninstallTargets(opts)
+	if opts.DryRun {
+		return targets, nil
+	}
+
+	var removed []string
+	for _, target := range targets {
+		logger.Infow("Removing", zap.String("path", target))
+		var err error
+		if helpers.FolderExists(target) {
+			_, err = helpers.DeleteFolder(target)
+		} else {
+			_, err = helpers.DeleteFile(target)
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, target)
+	}
+	return removed, nil
+}
@@ -0,0 +1,93 @@
+// Package kobomail implements all KoboMail functionality
+package kobomail
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bjw-s/kobomail/internal/config"
+	"github.com/bjw-s/kobomail/pkg/message"
+	"github.com/bjw-s/kobomail/pkg/webdav"
+	"go.uber.org/zap"
+)
+
+const webdavStateKey = "webdav_files"
+
+// runWebDAV downloads the new and changed files of a WebDAV folder.
+// Files go through the same rules and filetypes as email attachments, a file that fails is retried on the next run.
+// The version of every downloaded file is remembered by its path.
+func runWebDAV(folder config.WebDAVConfig, rc *runContext) (accountResult, error) {
+	logger := zap.S().With(zap.String("webdav", folder.Name))
+	result := accountResult{account: folder.Name}
+
+	client, err := webdav.New(folder.URL, folder.User, string(folder.Password))
+	if err != nil {
+		return result, runError(ExitConfigError, "Invalid WebDAV URL "+folder.URL, err)
+	}
+	files, err := client.List()
+	if err != nil {
+		var errMsg = fmt.Sprintf("Failed to list WebDAV folder %s, please check internet connection and credentials", folder.Name)
+		showDialog(errMsg+": "+err.Error(), true)
+		logger.Errorw(errMsg, zap.Error(err))
+		return result, runError(ExitConnectionError, errMsg, err)
+	}
+	logger.Infow("Listed WebDAV folder", zap.String("url", folder.URL), zap.Int("number_of_files", len(files)))
+
+	source := loadRemoteSource(rc, logger, "WebDAV folder", folder.Name, webdavStateKey)
+	previous, _ := source.downloaded()
+	// Only files still in the folder are remembered
+	downloaded := downloadedFiles{}
+	for _, file := range files {
+		if version, ok := previous[file.Href]; ok {
+			downloaded[file.Href] = version
+		}
+	}
+
+	libraryPath := KoboMailConfig.WebDAVLibraryPath(folder)
+	filetypes := KoboMailConfig.WebDAVFiletypes(folder)
+	var downloads []remoteDownload
+	for _, file := range files {
+		if downloaded[file.Href] == file.Version() {
+			continue
+		}
+		file := file
+		attachment := message.NewRemoteAttachment(file.Name, file.ContentType, file.Size, func() (io.ReadCloser, error) {
+			return client.Download(file)
+		})
+		plan := planAttachment(rc.rules, messageInfo{}, attachment, libraryPath, filetypes)
+		if plan.Skip {
+			logger.Debugw("Skipping file", zap.String("filename", file.Name), zap.String("rule", plan.Rule))
+			continue
+		}
+		downloads = append(downloads, remoteDownload{
+			plan: plan,
+			name: file.Name,
+			dryRun: func(out io.Writer) {
+				if folder.Delete {
+					fmt.Fprintf(out, "  would delete %s from the WebDAV folder\n", file.Name)
+				} else if folder.MoveTo != "" {
+					fmt.Fprintf(out, "  would move %s to %s in the WebDAV folder\n", file.Name, folder.MoveTo)
+				}
+			},
+			saved: func() {
+				downloaded[file.Href] = file.Version()
+				// A file that cannot be removed is not downloaded again, as long as it does not change
+				var removeErr error
+				if folder.Delete {
+					removeErr = client.Delete(file)
+				} else if folder.MoveTo != "" {
+					removeErr = client.Move(file, folder.MoveTo)
+				}
+				if removeErr != nil {
+					logger.Warnw("Failed to remove downloaded file from the WebDAV folder", zap.String("filename", file.Name), zap.Error(removeErr))
+				} else if folder.Delete || folder.MoveTo != "" {
+					delete(downloaded, file.Href)
+				}
+			},
+		})
+	}
+
+	err = source.download(libraryPath, downloads, &result)
+	source.save(downloaded)
+	return result, err
+}
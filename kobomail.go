@@ -2,9 +2,11 @@
 package main
 
 import (
+	"os"
+
 	"github.com/bjw-s/kobomail/internal/commands"
 )
 
 func main() {
-	commands.Execute()
+	os.Exit(commands.Execute())
 }
@@ -0,0 +1,260 @@
+// Package article implements extracting the readable content of web pages, to read them on the Kobo
+package article
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxPageSize limits the size of a web page that is read
+const maxPageSize = 5 << 20
+
+// The patterns used to score the elements of a page, based on the ones of Mozilla's Readability
+var (
+	unlikelyCandidates = regexp.MustCompile(`(?i)-ad-|banner|breadcrumbs|combx|comment|community|cookie|cover-wrap|disqus|extra|footer|gdpr|header|legends|menu|newsletter|pager|pagination|popup|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|supplemental`)
+	maybeCandidate     = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positiveWeight     = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeWeight     = regexp.MustCompile(`(?i)-ad-|hidden|banner|combx|comment|com-|contact|foot|footer|footnote|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget`)
+)
+
+// removedElements never contain readable content
+var removedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "nav": true, "header": true, "footer": true, "aside": true,
+	"form": true, "iframe": true, "button": true, "input": true, "select": true, "textarea": true, "svg": true,
+	"object": true, "embed": true, "canvas": true, "template": true, "dialog": true, "head": true,
+}
+
+// blockElements are the elements that make a div more than a paragraph
+var blockElements = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "div": true, "dl": true, "figure": true, "h1": true,
+	"h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "ul": true,
+}
+
+// Article is the readable content of a web page
+type Article struct {
+	Title string
+	// URL is the address of the page after redirects, relative links in the content are relative to it
+	URL     *url.URL
+	Content *Node
+}
+
+// Fetch downloads the web page at pageURL and extracts its readable content
+func Fetch(client *http.Client, pageURL string) (*Article, error) {
+	resp, err := client.Get(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: server returned %s", pageURL, resp.Status)
+	}
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "" && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("GET %s: not a web page but %s", pageURL, mediaType)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return nil, err
+	}
+	page := string(content)
+	if charset := strings.ToLower(params["charset"]); charset == "iso-8859-1" || charset == "windows-1252" {
+		page = latin1(content)
+	}
+
+	title, main := Extract(Parse(page))
+	return &Article{Title: title, URL: resp.Request.URL, Content: main}, nil
+}
+
+// Extract returns the title and the main content of a parsed web page.
+// The content is found like Readability does, by scoring the elements containing the paragraphs of the page.
+func Extract(document *Node) (string, *Node) {
+	title := pageTitle(document)
+	body := document.Find("body")
+	if body == nil {
+		body = document
+	}
+	prune(body)
+
+	scores := map[*Node]float64{}
+	var candidates []*Node
+	for _, paragraph := range paragraphs(body) {
+		text := paragraph.TextContent()
+		if len(text) < 25 {
+			continue
+		}
+		score := 1 + float64(strings.Count(text, ",")) + minFloat(float64(len(text)/100), 3)
+		ancestor := paragraph.Parent
+		for level := 0; level < 3 && ancestor != nil && ancestor.Tag != "#document"; level++ {
+			if _, ok := scores[ancestor]; !ok {
+				scores[ancestor] = initialScore(ancestor)
+				candidates = append(candidates, ancestor)
+			}
+			divider := 1.0
+			if level == 1 {
+				divider = 2
+			} else if level > 1 {
+				divider = float64(level * 3)
+			}
+			scores[ancestor] += score / divider
+			ancestor = ancestor.Parent
+		}
+	}
+
+	var top *Node
+	for _, candidate := range candidates {
+		scores[candidate] *= 1 - linkDensity(candidate)
+		if top == nil || scores[candidate] > scores[top] {
+			top = candidate
+		}
+	}
+	if top == nil || top.Parent == nil {
+		return title, body
+	}
+
+	// Articles are often split over siblings, like a lead and the body or paragraphs between ads
+	content := &Node{Tag: "div"}
+	threshold := maxFloat(10, scores[top]*0.2)
+	for _, sibling := range top.Parent.Children {
+		include := sibling == top
+		if score, ok := scores[sibling]; ok && score >= threshold {
+			include = true
+		} else if sibling.Tag == "p" {
+			text := sibling.TextContent()
+			density := linkDensity(sibling)
+			include = include || (len(text) > 80 && density < 0.25) || (len(text) > 0 && density == 0 && strings.Contains(text, ". "))
+		}
+		if include {
+			content.Children = append(content.Children, sibling)
+		}
+	}
+	return title, content
+}
+
+// pageTitle returns the title of the article, preferring the Open Graph title over the title of the page
+func pageTitle(document *Node) string {
+	for _, meta := range document.FindAll("meta") {
+		if meta.Attrs["property"] == "og:title" && strings.TrimSpace(meta.Attrs["content"]) != "" {
+			return strings.TrimSpace(meta.Attrs["content"])
+		}
+	}
+	if title := document.Find("title"); title != nil && title.TextContent() != "" {
+		return title.TextContent()
+	}
+	if h1 := document.Find("h1"); h1 != nil {
+		return h1.TextContent()
+	}
+	return ""
+}
+
+// prune removes the elements that are unlikely to be part of the article
+func prune(body *Node) {
+	var removed []*Node
+	body.walk(func(n *Node) bool {
+		if n.Tag == "" || n == body {
+			return true
+		}
+		match := n.Attrs["class"] + " " + n.Attrs["id"]
+		_, hidden := n.Attrs["hidden"]
+		unlikely := unlikelyCandidates.MatchString(match) && !maybeCandidate.MatchString(match) &&
+			n.Tag != "article" && n.Tag != "main" && n.Tag != "a"
+		if removedElements[n.Tag] || unlikely || hidden || n.Attrs["aria-hidden"] == "true" {
+			removed = append(removed, n)
+			return false
+		}
+		return true
+	})
+	for _, n := range removed {
+		n.remove()
+	}
+}
+
+// paragraphs returns the elements holding the text of the page, divs without block elements count as paragraphs
+func paragraphs(body *Node) []*Node {
+	var found []*Node
+	body.walk(func(n *Node) bool {
+		switch n.Tag {
+		case "p", "pre", "td":
+			found = append(found, n)
+			return false
+		case "div":
+			for _, child := range n.Children {
+				if blockElements[child.Tag] {
+					return true
+				}
+			}
+			found = append(found, n)
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// initialScore returns the score of an element before the paragraphs in it are counted
+func initialScore(n *Node) float64 {
+	var score float64
+	switch n.Tag {
+	case "div", "article", "main":
+		score = 5
+	case "pre", "td", "blockquote":
+		score = 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score = -3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score = -5
+	}
+	for _, attr := range []string{n.Attrs["class"], n.Attrs["id"]} {
+		if attr == "" {
+			continue
+		}
+		if negativeWeight.MatchString(attr) {
+			score -= 25
+		}
+		if positiveWeight.MatchString(attr) {
+			score += 25
+		}
+	}
+	return score
+}
+
+// linkDensity returns the part of the text of the element that is in links
+func linkDensity(n *Node) float64 {
+	length := len(n.TextContent())
+	if length == 0 {
+		return 0
+	}
+	linkLength := 0
+	for _, link := range n.FindAll("a") {
+		linkLength += len(link.TextContent())
+	}
+	return float64(linkLength) / float64(length)
+}
+
+// latin1 converts Latin-1 text to UTF-8
+func latin1(content []byte) string {
+	runes := make([]rune, len(content))
+	for i, b := range content {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func minFloat(a float64, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a float64, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
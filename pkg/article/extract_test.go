@@ -0,0 +1,94 @@
+package article
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const page = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Example News - A long day</title>
+  <meta property="og:title" content="A long day">
+  <script>track()</script>
+</head>
+<body>
+  <header><nav><a href="/">Home</a> <a href="/world">World</a></nav></header>
+  <div class="sidebar">
+    <p>Subscribe to our newsletter, get the best stories, every morning, in your inbox.</p>
+  </div>
+  <article class="post">
+    <p>The first paragraph of the article, with enough text, commas, and words to count as content.</p>
+    <p>The second paragraph continues the story, adding details, quotes, and more words to read.</p>
+    <p>The third paragraph ends the story. It has a <a href="/more">link</a> as well.</p>
+  </article>
+  <footer><p>Copyright Example News, all rights reserved, no part may be copied.</p></footer>
+</body>
+</html>`
+
+func TestExtract(t *testing.T) {
+	title, content := Extract(Parse(page))
+	if title != "A long day" {
+		t.Errorf("title = %q, want the Open Graph title", title)
+	}
+	text := content.TextContent()
+	for _, want := range []string{"first paragraph", "second paragraph", "third paragraph"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("content is missing the %s: %q", want, text)
+		}
+	}
+	for _, unwanted := range []string{"Home", "newsletter", "Copyright", "track"} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("content contains %q: %q", unwanted, text)
+		}
+	}
+}
+
+func TestExtractTitle(t *testing.T) {
+	tests := []struct {
+		document string
+		want     string
+	}{
+		{`<title> Page title </title><h1>Heading</h1>`, "Page title"},
+		{`<h1>Heading</h1><p>text</p>`, "Heading"},
+		{`<p>text</p>`, ""},
+	}
+	for _, tt := range tests {
+		if title, _ := Extract(Parse(tt.document)); title != tt.want {
+			t.Errorf("Extract(%q) title = %q, want %q", tt.document, title, tt.want)
+		}
+	}
+}
+
+func TestXHTML(t *testing.T) {
+	base, _ := url.Parse("https://example.org/news/story.html")
+	document := Parse(`<h1>Title</h1>` +
+		`<p class="lead">Fish &amp; chips<br>are <span>great</span><script>x()</script></p>` +
+		`<p><a href="../about">about</a> <a href="javascript:void(0)">menu</a> <a href="mailto:a@example.org">mail</a></p>` +
+		`<figure><img src="data:image/gif;base64,R0lGOD" data-src="/img/a.jpg" alt="A &quot;photo&quot;">` +
+		`<img srcset="small.png 300w, large.png 1000w"><img src="pixel.gif" width="1"></figure>` +
+		`<p>control` + "\x01" + `character</p>`)
+
+	var requested []string
+	image := func(src string) (string, bool) {
+		requested = append(requested, src)
+		if strings.HasSuffix(src, ".png") {
+			return "", false
+		}
+		return "images/image-001.jpg", true
+	}
+	got := XHTML(document, base, image)
+	want := `<h2>Title</h2>` +
+		`<p>Fish &amp; chips<br/>are great</p>` +
+		`<p><a href="https://example.org/about">about</a> menu mail</p>` +
+		`<figure><img src="images/image-001.jpg" alt="A &quot;photo&quot;"/></figure>` +
+		`<p>controlcharacter</p>`
+	if got != want {
+		t.Errorf("XHTML()\n got %s\nwant %s", got, want)
+	}
+	wantRequested := []string{"https://example.org/img/a.jpg", "https://example.org/news/small.png"}
+	if strings.Join(requested, " ") != strings.Join(wantRequested, " ") {
+		t.Errorf("requested images %v, want %v", requested, wantRequested)
+	}
+}
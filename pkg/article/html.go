@@ -0,0 +1,132 @@
+// Package article implements extracting the readable content of web pages, to read them on the Kobo
+package article
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Node is an element or a text of an HTML document, text nodes have no tag
+type Node struct {
+	Tag      string
+	Attrs    map[string]string
+	Text     string
+	Parent   *Node
+	Children []*Node
+}
+
+// voidElements never have content or an end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+	"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Parse parses an HTML document or fragment into a tree, like browsers it never fails on invalid HTML.
+// Fragments are placed in the body of a document. Comments and doctypes are left out.
+func Parse(document string) *Node {
+	parsed, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		// Reading from a string never fails
+		return &Node{Tag: "#document"}
+	}
+	return convert(parsed, nil)
+}
+
+// convert converts a node of the html package and its descendants to a Node
+func convert(n *html.Node, parent *Node) *Node {
+	node := &Node{Parent: parent}
+	switch n.Type {
+	case html.DocumentNode:
+		node.Tag = "#document"
+	case html.ElementNode:
+		node.Tag = n.Data
+		node.Attrs = make(map[string]string, len(n.Attr))
+		for _, attr := range n.Attr {
+			key := attr.Key
+			if attr.Namespace != "" {
+				key = attr.Namespace + ":" + key
+			}
+			if _, ok := node.Attrs[key]; !ok {
+				node.Attrs[key] = attr.Val
+			}
+		}
+	case html.TextNode:
+		node.Text = n.Data
+		return node
+	default:
+		return nil
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if converted := convert(child, node); converted != nil {
+			node.Children = append(node.Children, converted)
+		}
+	}
+	return node
+}
+
+// Find returns the first element with the tag, searching the node and its descendants in document order
+func (n *Node) Find(tag string) *Node {
+	if n.Tag == tag {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := child.Find(tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// FindAll returns all elements with the tag in document order
+func (n *Node) FindAll(tag string) []*Node {
+	var found []*Node
+	n.walk(func(node *Node) bool {
+		if node.Tag == tag {
+			found = append(found, node)
+		}
+		return true
+	})
+	return found
+}
+
+// TextContent returns the text of the node and its descendants, with whitespace collapsed
+func (n *Node) TextContent() string {
+	var text strings.Builder
+	n.walk(func(node *Node) bool {
+		if node.Tag == "script" || node.Tag == "style" || node.Tag == "noscript" {
+			return false
+		}
+		if node.Tag == "" {
+			text.WriteString(node.Text)
+			text.WriteByte(' ')
+		}
+		return true
+	})
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// walk calls fn for the node and its descendants in document order, descendants are skipped when fn returns false
+func (n *Node) walk(fn func(*Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for _, child := range n.Children {
+		child.walk(fn)
+	}
+}
+
+// remove removes the node from its parent
+func (n *Node) remove() {
+	if n.Parent == nil {
+		return
+	}
+	children := n.Parent.Children
+	for i, child := range children {
+		if child == n {
+			n.Parent.Children = append(children[:i:i], children[i+1:]...)
+			break
+		}
+	}
+	n.Parent = nil
+}
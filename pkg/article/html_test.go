@@ -0,0 +1,117 @@
+package article
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// render returns a compact form of the tree, elements as tag[attr=value](children) and texts quoted
+func render(n *Node) string {
+	if n.Tag == "" {
+		return strconv.Quote(n.Text)
+	}
+	var out strings.Builder
+	out.WriteString(n.Tag)
+	if len(n.Attrs) > 0 {
+		var attrs []string
+		for name, value := range n.Attrs {
+			attrs = append(attrs, name+"="+value)
+		}
+		sort.Strings(attrs)
+		out.WriteString("[" + strings.Join(attrs, " ") + "]")
+	}
+	if len(n.Children) > 0 {
+		var children []string
+		for _, child := range n.Children {
+			if child.Parent != n {
+				children = append(children, "!parent")
+			}
+			children = append(children, render(child))
+		}
+		out.WriteString("(" + strings.Join(children, " ") + ")")
+	}
+	return out.String()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     string
+	}{
+		{"nested", `<div><p>Hello <em>world</em></p></div>`, `div(p("Hello " em("world")))`},
+		{"case", `<DIV ID=main><P>x</P></DIV>`, `div[id=main](p("x"))`},
+		{"attributes", `<a href='/x' title="a &amp; b" data-x=1 hidden>y</a>`, `a[data-x=1 hidden= href=/x title=a & b]("y")`},
+		{"duplicate attribute", `<img src="a.png" src="b.png">`, `img[src=a.png]`},
+		{"entities", `<p>&lt;tag&gt; &amp; &eacute;&#233;</p>`, `p("<tag> & éé")`},
+		{"paragraphs end implicitly", `<p>one<p>two<div>three</div>`, `p("one") p("two") div("three")`},
+		{"nested lists", `<ul><li>one<ul><li>inner</ul><li>two</ul>`, `ul(li("one" ul(li("inner"))) li("two"))`},
+		{"table rows end implicitly", `<table><tr><td>a<td>b<tr><th>c</table>`, `table(tbody(tr(td("a") td("b")) tr(th("c"))))`},
+		{"void elements", `<p>a<br>b<hr>c`, `p("a" br "b") hr "c"`},
+		{"script", `<p>x<script>if (a < b) { x = "</p>" }</script>`, `p("x" script("if (a < b) { x = \"</p>\" }"))`},
+		{"comments and doctype", `<!DOCTYPE html>a<!-- <p>not a paragraph</p> -->b`, `"a" "b"`},
+		{"svg attributes", `<svg><use xlink:href="#icon"/></svg>`, `svg(use[xlink:href=#icon])`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := Parse(tt.document)
+			body := root.Find("body")
+			if root.Tag != "#document" || body == nil {
+				t.Fatalf("Parse returned %s, want a #document with a body", render(root))
+			}
+			var children []string
+			for _, child := range body.Children {
+				children = append(children, render(child))
+			}
+			if got := strings.Join(children, " "); got != tt.want {
+				t.Errorf("Parse(%q)\n got %s\nwant %s", tt.document, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDocument(t *testing.T) {
+	root := Parse(`<title>A &amp; B</title><style>a::before { content: "&amp;" }</style><p>x`)
+	want := `#document(html(head(title("A & B") style("a::before { content: \"&amp;\" }")) body(p("x"))))`
+	if got := render(root); got != want {
+		t.Errorf("Parse()\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestTextContent(t *testing.T) {
+	root := Parse(`<div>Hello,
+		<b>brave</b>   new<script>var x = 1</script><style>p {}</style><noscript>enable JavaScript</noscript> world</div>`)
+	if got, want := root.TextContent(), "Hello, brave new world"; got != want {
+		t.Errorf("TextContent() = %q, want %q", got, want)
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := Parse(`<div><p id=a><a href=1>x</a></p><p id=b><a href=2>y</a></p></div>`)
+	if p := root.Find("p"); p == nil || p.Attrs["id"] != "a" {
+		t.Errorf("Find(p) = %v, want the first paragraph", p)
+	}
+	if root.Find("table") != nil {
+		t.Error("Find(table) found an element")
+	}
+	links := root.FindAll("a")
+	if len(links) != 2 || links[0].Attrs["href"] != "1" || links[1].Attrs["href"] != "2" {
+		t.Errorf("FindAll(a) returned %d links in the wrong order", len(links))
+	}
+}
+
+func TestRemove(t *testing.T) {
+	root := Parse(`<p>a</p><p>b</p><p>c</p>`)
+	middle := root.Find("body").Children[1]
+	middle.remove()
+	if got, want := root.TextContent(), "a c"; got != want {
+		t.Errorf("TextContent() after remove = %q, want %q", got, want)
+	}
+	if middle.Parent != nil {
+		t.Error("removed node still has a parent")
+	}
+	// Removing a detached node does nothing
+	middle.remove()
+}
@@ -0,0 +1,144 @@
+// Package article implements extracting the readable content of web pages, to read them on the Kobo
+package article
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// keptElements are rendered as is, without their attributes
+var keptElements = map[string]bool{
+	"p": true, "br": true, "hr": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true, "blockquote": true, "pre": true,
+	"code": true, "em": true, "strong": true, "i": true, "b": true, "u": true, "s": true, "sub": true,
+	"sup": true, "small": true, "q": true, "cite": true, "abbr": true, "del": true, "ins": true, "mark": true,
+	"figure": true, "figcaption": true, "table": true, "thead": true, "tbody": true, "tfoot": true,
+	"tr": true, "th": true, "td": true, "caption": true, "div": true,
+}
+
+// droppedElements are left out together with their content
+var droppedElements = map[string]bool{
+	"title": true, "meta": true, "link": true, "base": true, "source": true, "track": true, "video": true,
+	"audio": true, "map": true, "area": true, "wbr": true,
+}
+
+var (
+	textEscaper      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+// ImageFunc returns the href of the image with the absolute URL src in the output, images are left out when it returns false
+type ImageFunc func(src string) (string, bool)
+
+// XHTML renders the children of the node as the XHTML content of a body element.
+// Only the markup that makes sense on an e-reader is kept, links and images are resolved against base.
+func XHTML(n *Node, base *url.URL, image ImageFunc) string {
+	var out bytes.Buffer
+	for _, child := range n.Children {
+		writeXHTML(&out, child, base, image)
+	}
+	return out.String()
+}
+
+func writeXHTML(out *bytes.Buffer, n *Node, base *url.URL, image ImageFunc) {
+	tag := n.Tag
+	switch {
+	case tag == "":
+		out.WriteString(escape(n.Text, false))
+		return
+	case removedElements[tag] || droppedElements[tag]:
+		return
+	case tag == "img":
+		src := resolve(base, imageSource(n))
+		if src == "" || n.Attrs["width"] == "1" || n.Attrs["height"] == "1" {
+			return
+		}
+		if href, ok := image(src); ok {
+			out.WriteString(`<img src="` + escape(href, true) + `" alt="` + escape(n.Attrs["alt"], true) + `"/>`)
+		}
+		return
+	case tag == "a":
+		href := resolve(base, n.Attrs["href"])
+		if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") {
+			break
+		}
+		out.WriteString(`<a href="` + escape(href, true) + `">`)
+		for _, child := range n.Children {
+			writeXHTML(out, child, base, image)
+		}
+		out.WriteString(`</a>`)
+		return
+	case tag == "h1":
+		// The title of the chapter is the only h1
+		tag = "h2"
+	}
+
+	if !keptElements[tag] {
+		// Unknown elements, like span and section, are replaced by their content
+		for _, child := range n.Children {
+			writeXHTML(out, child, base, image)
+		}
+		return
+	}
+	if voidElements[tag] {
+		out.WriteString("<" + tag + "/>")
+		return
+	}
+	out.WriteString("<" + tag + ">")
+	for _, child := range n.Children {
+		writeXHTML(out, child, base, image)
+	}
+	out.WriteString("</" + tag + ">")
+}
+
+// imageSource returns the URL of an image, lazy loaded images keep their URL in a data attribute
+func imageSource(n *Node) string {
+	src := strings.TrimSpace(n.Attrs["src"])
+	if src != "" && !strings.HasPrefix(src, "data:") {
+		return src
+	}
+	for _, attr := range []string{"data-src", "data-original", "data-lazy-src"} {
+		if value := strings.TrimSpace(n.Attrs[attr]); value != "" {
+			return value
+		}
+	}
+	// The first image of a srcset is usually the smallest one, which is plenty for an e-reader
+	for _, attr := range []string{"srcset", "data-srcset"} {
+		if fields := strings.Fields(n.Attrs[attr]); len(fields) > 0 {
+			return strings.TrimSuffix(fields[0], ",")
+		}
+	}
+	return ""
+}
+
+// resolve returns the absolute URL of href, or an empty string when it is not valid
+func resolve(base *url.URL, href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "data:") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	return u.String()
+}
+
+// escape escapes text for use in XHTML, characters XML does not allow are left out
+func escape(text string, attribute bool) string {
+	text = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0xFFFE || r == 0xFFFF {
+			return -1
+		}
+		return r
+	}, text)
+	if attribute {
+		return attributeEscaper.Replace(text)
+	}
+	return textEscaper.Replace(text)
+}
@@ -0,0 +1,168 @@
+// Package credentials implements an encrypted credential store bound to the device
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bjw-s/kobomail/pkg/helpers"
+)
+
+// DeviceInfoPath contains the serial number of the Kobo device
+const DeviceInfoPath = "/mnt/onboard/.kobo/version"
+
+// Store keeps credentials encrypted with AES-GCM in a JSON file.
+// The key is derived from a random key file and the serial number of the device,
+// both should be kept outside of the user visible partition.
+type Store struct {
+	path    string
+	keyPath string
+	secrets map[string]string
+}
+
+// Load reads the credential store, a missing file results in an empty store
+func Load(path string, keyPath string) (*Store, error) {
+	store := &Store{
+		path:    path,
+		keyPath: keyPath,
+		secrets: map[string]string{},
+	}
+
+	if !helpers.FileExists(path) {
+		return store, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &store.secrets); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// deviceKey returns the encryption key, the key file is created when it does not exist yet
+func (s *Store) deviceKey(create bool) ([]byte, error) {
+	if !helpers.FileExists(s.keyPath) {
+		if !create {
+			return nil, fmt.Errorf("key file %s does not exist", s.keyPath)
+		}
+		secret := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(s.keyPath), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(s.keyPath, secret, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	secret, err := os.ReadFile(s.keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version file starts with the serial number of the device
+	serial := ""
+	if info, err := os.ReadFile(DeviceInfoPath); err == nil {
+		serial = strings.SplitN(strings.TrimSpace(string(info)), ",", 2)[0]
+	}
+
+	key := sha256.Sum256(append(secret, []byte(serial)...))
+	return key[:], nil
+}
+
+func (s *Store) cipher(create bool) (cipher.AEAD, error) {
+	key, err := s.deviceKey(create)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Get returns the decrypted credential stored under name and if it was found
+func (s *Store) Get(name string) (string, bool, error) {
+	encoded, ok := s.secrets[name]
+	if !ok {
+		return "", false, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", true, err
+	}
+	gcm, err := s.cipher(false)
+	if err != nil {
+		return "", true, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", true, fmt.Errorf("credential %s is corrupt", name)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", true, fmt.Errorf("failed to decrypt credential %s, was it stored on another device? %w", name, err)
+	}
+	return string(plaintext), true, nil
+}
+
+// Set encrypts the credential and stores it under name, call Save to persist the change
+func (s *Store) Set(name string, secret string) error {
+	gcm, err := s.cipher(true)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), []byte(name))
+	s.secrets[name] = base64.StdEncoding.EncodeToString(sealed)
+	return nil
+}
+
+// Delete removes the credential stored under name and returns if it was found
+func (s *Store) Delete(name string) bool {
+	_, ok := s.secrets[name]
+	delete(s.secrets, name)
+	return ok
+}
+
+// Names returns the names of all stored credentials
+func (s *Store) Names() []string {
+	var names []string
+	for name := range s.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save writes the store back to disk, only readable by the owner
+func (s *Store) Save() error {
+	content, err := json.MarshalIndent(s.secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, content, 0600)
+}
@@ -0,0 +1,310 @@
+// Package epub implements writing simple EPUB 3 books, with a navigation document for EPUB 2 readers as well
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"text/template"
+	"time"
+)
+
+// Chapter is a single XHTML document of the book
+type Chapter struct {
+	Title string
+	// Section groups chapters in the table of contents, chapters without a section are listed at the top level
+	Section string
+	// Body is the XHTML content of the body element, without the title
+	Body string
+
+	href string
+}
+
+// Href returns the path of the chapter in the book
+func (c *Chapter) Href() string {
+	return c.href
+}
+
+// Image is an image used by the chapters
+type Image struct {
+	Href      string
+	MediaType string
+}
+
+// Book is an EPUB book being written.
+// Chapters and images are written to the EPUB file as soon as they are added, so they are not kept in memory,
+// the package document and the table of contents are written when the book is closed.
+type Book struct {
+	Title    string
+	Author   string
+	Language string
+	Date     time.Time
+
+	archive *zip.Writer
+	// chapters only keep their title and href once they are written
+	chapters []*Chapter
+	images   []Image
+}
+
+// Create starts writing a book as an EPUB file to w, Close must be called to finish the file
+func Create(w io.Writer, title string, author string) (*Book, error) {
+	b := &Book{Title: title, Author: author, Language: "en", Date: time.Now(), archive: zip.NewWriter(w)}
+	// The mimetype must be the first file and stored uncompressed
+	mimetype, err := b.archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// AddChapter writes a chapter at the end of the book
+func (b *Book) AddChapter(chapter Chapter) error {
+	chapter.href = fmt.Sprintf("chapter-%03d.xhtml", len(b.chapters)+1)
+	f, err := b.archive.Create("OEBPS/" + chapter.href)
+	if err != nil {
+		return err
+	}
+	if err := chapterTemplate.Execute(f, map[string]interface{}{"Book": b, "Chapter": &chapter}); err != nil {
+		return fmt.Errorf("failed to write %s: %w", chapter.href, err)
+	}
+	chapter.Body = ""
+	b.chapters = append(b.chapters, &chapter)
+	return nil
+}
+
+// AddImage writes an image to the book and returns the href chapters use to show it
+func (b *Book) AddImage(mediaType string, data []byte) (string, error) {
+	extensions := map[string]string{"image/jpeg": "jpg", "image/png": "png", "image/gif": "gif"}
+	extension, ok := extensions[mediaType]
+	if !ok {
+		return "", fmt.Errorf("images of type %s are not supported", mediaType)
+	}
+	href := fmt.Sprintf("images/image-%03d.%s", len(b.images)+1, extension)
+	// Images are compressed already
+	f, err := b.archive.CreateHeader(&zip.FileHeader{Name: "OEBPS/" + href, Method: zip.Store})
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		return "", err
+	}
+	b.images = append(b.images, Image{Href: href, MediaType: mediaType})
+	return href, nil
+}
+
+// Chapters returns the number of chapters in the book
+func (b *Book) Chapters() int {
+	return len(b.chapters)
+}
+
+// tocSection is a section of the table of contents with its chapters
+type tocSection struct {
+	Title    string
+	Href     string
+	Chapters []*Chapter
+}
+
+// sections groups the chapters by their section, in the order of the book
+func (b *Book) sections() []tocSection {
+	var sections []tocSection
+	for _, chapter := range b.chapters {
+		if chapter.Section == "" {
+			sections = append(sections, tocSection{Title: chapter.Title, Href: chapter.Href()})
+			continue
+		}
+		if last := len(sections) - 1; last >= 0 && sections[last].Title == chapter.Section && len(sections[last].Chapters) > 0 {
+			sections[last].Chapters = append(sections[last].Chapters, chapter)
+			continue
+		}
+		sections = append(sections, tocSection{Title: chapter.Section, Href: chapter.Href(), Chapters: []*Chapter{chapter}})
+	}
+	return sections
+}
+
+// Close writes the package document, the table of contents and the stylesheet and finishes the EPUB file.
+// The writer passed to Create is not closed.
+func (b *Book) Close() error {
+	id, err := uuid()
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"ID":       id,
+		"Book":     b,
+		"Modified": b.Date.UTC().Format("2006-01-02T15:04:05Z"),
+		"Chapters": b.chapters,
+		"Images":   b.images,
+		"Sections": b.sections(),
+	}
+
+	files := []struct {
+		name     string
+		template *template.Template
+		data     interface{}
+	}{
+		{"META-INF/container.xml", containerTemplate, data},
+		{"OEBPS/content.opf", packageTemplate, data},
+		{"OEBPS/nav.xhtml", navTemplate, data},
+		{"OEBPS/toc.ncx", ncxTemplate, data},
+	}
+	for _, file := range files {
+		f, err := b.archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err := file.template.Execute(f, file.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+
+	f, err := b.archive.Create("OEBPS/style.css")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, stylesheet); err != nil {
+		return err
+	}
+	return b.archive.Close()
+}
+
+// uuid returns a random UUID identifying the book
+func uuid() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
+
+// escape escapes text for use in XML
+func escape(text string) string {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+var funcs = template.FuncMap{
+	"escape": escape,
+	"add":    func(a int, b int) int { return a + b },
+}
+
+const stylesheet = `body { margin: 0 2%; }
+h1 { font-size: 1.4em; margin: 0.5em 0; }
+h2 { font-size: 1.2em; }
+img { max-width: 100%; height: auto; }
+figure { margin: 1em 0; }
+figcaption, .byline { font-size: 0.8em; font-style: italic; }
+pre { white-space: pre-wrap; font-size: 0.8em; }
+blockquote { margin: 1em 1.5em; }
+`
+
+var containerTemplate = template.Must(template.New("container").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`))
+
+var packageTemplate = template.Must(template.New("package").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">urn:uuid:{{.ID}}</dc:identifier>
+    <dc:title>{{escape .Book.Title}}</dc:title>
+    <dc:creator>{{escape .Book.Author}}</dc:creator>
+    <dc:language>{{escape .Book.Language}}</dc:language>
+    <dc:date>{{.Modified}}</dc:date>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+{{- range $i, $chapter := .Chapters}}
+    <item id="chapter-{{add $i 1}}" href="{{$chapter.Href}}" media-type="application/xhtml+xml"/>
+{{- end}}
+{{- range $i, $image := .Images}}
+    <item id="image-{{add $i 1}}" href="{{$image.Href}}" media-type="{{$image.MediaType}}"/>
+{{- end}}
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="nav"/>
+{{- range $i, $chapter := .Chapters}}
+    <itemref idref="chapter-{{add $i 1}}"/>
+{{- end}}
+  </spine>
+</package>
+`))
+
+var navTemplate = template.Must(template.New("nav").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{escape .Book.Language}}">
+<head>
+  <title>{{escape .Book.Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>{{escape .Book.Title}}</h1>
+    <ol>
+{{- range .Sections}}
+      <li><a href="{{.Href}}">{{escape .Title}}</a>
+{{- if .Chapters}}
+        <ol>
+{{- range .Chapters}}
+          <li><a href="{{.Href}}">{{escape .Title}}</a></li>
+{{- end}}
+        </ol>
+{{- end}}
+      </li>
+{{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+var ncxTemplate = template.Must(template.New("ncx").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="urn:uuid:{{.ID}}"/>
+  </head>
+  <docTitle><text>{{escape .Book.Title}}</text></docTitle>
+  <navMap>
+{{- range $i, $section := .Sections}}
+    <navPoint id="section-{{add $i 1}}">
+      <navLabel><text>{{escape $section.Title}}</text></navLabel>
+      <content src="{{$section.Href}}"/>
+{{- range $j, $chapter := $section.Chapters}}
+      <navPoint id="section-{{add $i 1}}-{{add $j 1}}">
+        <navLabel><text>{{escape $chapter.Title}}</text></navLabel>
+        <content src="{{$chapter.Href}}"/>
+      </navPoint>
+{{- end}}
+    </navPoint>
+{{- end}}
+  </navMap>
+</ncx>
+`))
+
+var chapterTemplate = template.Must(template.New("chapter").Funcs(funcs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="{{escape .Book.Language}}">
+<head>
+  <title>{{escape .Chapter.Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <h1>{{escape .Chapter.Title}}</h1>
+{{.Chapter.Body}}
+</body>
+</html>
+`))
@@ -0,0 +1,292 @@
+// Package feed implements reading RSS and Atom feeds
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxFeedSize limits the size of a feed that is read
+const maxFeedSize = 10 << 20
+
+// tags matches the tags of an HTML text
+var tags = regexp.MustCompile(`<[^>]*>`)
+
+// dateLayouts are the date formats found in feeds, RSS feeds do not always follow RFC 822
+var dateLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// Item is an article of a feed
+type Item struct {
+	ID        string
+	Title     string
+	Link      string
+	Author    string
+	Published time.Time
+	// Content is the HTML content of the item, only a summary for many feeds
+	Content string
+}
+
+// Feed is an RSS or Atom feed
+type Feed struct {
+	Title string
+	Link  string
+	Items []Item
+}
+
+// document covers RSS 2.0, RSS 1.0 and Atom, only the elements of the format in use are filled
+type document struct {
+	XMLName xml.Name
+	// RSS 2.0 has its items inside the channel, RSS 1.0 next to it
+	Channel struct {
+		Title string    `xml:"title"`
+		Links rssLinks  `xml:"link"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items []rssItem `xml:"item"`
+
+	Title   string      `xml:"title"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// rssLinks are the link elements of an RSS channel or item, which can include atom:link elements
+type rssLinks []struct {
+	Value string `xml:",chardata"`
+}
+
+// first returns the URL of the RSS link, atom:link elements have no content
+func (l rssLinks) first() string {
+	for _, link := range l {
+		if strings.TrimSpace(link.Value) != "" {
+			return link.Value
+		}
+	}
+	return ""
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Links       rssLinks `xml:"link"`
+	GUID        string   `xml:"guid"`
+	About       string   `xml:"about,attr"`
+	Author      string   `xml:"author"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate     string   `xml:"pubDate"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Description string   `xml:"description"`
+	Encoded     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Inner string `xml:",innerxml"`
+}
+
+// html returns the text as HTML, plain text is escaped and XHTML is taken as is
+func (t atomText) html() string {
+	switch t.Type {
+	case "xhtml":
+		return strings.TrimSpace(t.Inner)
+	case "html":
+		return unescapeXML(t.Inner)
+	}
+	return escapeText(unescapeXML(t.Inner))
+}
+
+// text returns the text without markup
+func (t atomText) text() string {
+	if t.Type == "html" {
+		return html.UnescapeString(tags.ReplaceAllString(unescapeXML(t.Inner), ""))
+	}
+	return unescapeXML(t.Inner)
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     atomText   `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Authors   []string   `xml:"author>name"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   atomText   `xml:"summary"`
+	Content   atomText   `xml:"content"`
+}
+
+// alternate returns the link to the web page of an Atom entry or feed
+func alternate(links []atomLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// Fetch reads the feed at feedURL, links in the feed are made absolute
+func Fetch(client *http.Client, feedURL string) (*Feed, error) {
+	req, err := http.NewRequest(http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: server returned %s", feedURL, resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(content, resp.Request.URL)
+}
+
+// Parse parses an RSS or Atom feed, relative links are resolved against base
+func Parse(content []byte, base *url.URL) (*Feed, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = charsetReader
+
+	var doc document
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid feed: %w", err)
+	}
+
+	resolve := func(href string) string {
+		href = strings.TrimSpace(href)
+		if href == "" || base == nil {
+			return href
+		}
+		if u, err := base.Parse(href); err == nil {
+			return u.String()
+		}
+		return href
+	}
+
+	feed := &Feed{}
+	switch doc.XMLName.Local {
+	case "rss", "RDF":
+		feed.Title = strings.TrimSpace(doc.Channel.Title)
+		feed.Link = resolve(doc.Channel.Links.first())
+		for _, item := range append(doc.Channel.Items, doc.Items...) {
+			parsed := Item{
+				ID:        strings.TrimSpace(firstNonEmpty(item.GUID, item.About, item.Links.first(), item.Title)),
+				Title:     strings.TrimSpace(item.Title),
+				Link:      resolve(item.Links.first()),
+				Author:    strings.TrimSpace(firstNonEmpty(item.Creator, item.Author)),
+				Published: parseDate(firstNonEmpty(item.PubDate, item.Date)),
+				Content:   firstNonEmpty(item.Encoded, item.Description),
+			}
+			feed.Items = append(feed.Items, parsed)
+		}
+	case "feed":
+		feed.Title = strings.TrimSpace(doc.Title)
+		feed.Link = resolve(alternate(doc.Links))
+		for _, entry := range doc.Entries {
+			parsed := Item{
+				ID:        strings.TrimSpace(firstNonEmpty(entry.ID, alternate(entry.Links))),
+				Title:     strings.TrimSpace(entry.Title.text()),
+				Link:      resolve(alternate(entry.Links)),
+				Published: parseDate(firstNonEmpty(entry.Published, entry.Updated)),
+				Content:   firstNonEmpty(entry.Content.html(), entry.Summary.html()),
+			}
+			if len(entry.Authors) > 0 {
+				parsed.Author = strings.TrimSpace(entry.Authors[0])
+			}
+			feed.Items = append(feed.Items, parsed)
+		}
+	default:
+		return nil, fmt.Errorf("invalid feed: unknown format <%s>", doc.XMLName.Local)
+	}
+	return feed, nil
+}
+
+// parseDate parses the date of an item, an unknown format results in the zero time
+func parseDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// charsetReader converts Latin-1 feeds to UTF-8, most feeds are UTF-8 and other charsets are read as is
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		content, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	return input, nil
+}
+
+// unescapeXML decodes the character data of an element read with innerxml
+func unescapeXML(inner string) string {
+	var text strings.Builder
+	decoder := xml.NewDecoder(strings.NewReader("<x>" + inner + "</x>"))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if data, ok := token.(xml.CharData); ok {
+			text.Write(data)
+		}
+	}
+	return text.String()
+}
+
+// escapeText escapes plain text to be used as HTML
+func escapeText(text string) string {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
@@ -36,3 +36,25 @@ func DeleteFile(filename string) (bool, error) {
 	}
 	return false, fmt.Errorf("file %s does not exist", filename)
 }
+
+// DeleteFolder removes the folder and everything it contains if it exists
+func DeleteFolder(foldername string) (bool, error) {
+	if FolderExists(foldername) {
+		err := os.RemoveAll(foldername)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("folder %s does not exist", foldername)
+}
+
+// ContainsFiletype returns if the file extension is in the list of filetypes
+func ContainsFiletype(filetypes []string, extension string) bool {
+	for _, filetype := range filetypes {
+		if filetype == extension {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+// Package helpers implements several useful functions
+package helpers
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// dialTimeout limits connecting to a server and the TLS handshake
+const dialTimeout = 30 * time.Second
+
+// NewHTTPClient returns a client for downloading large files on slow connections.
+// Unlike http.Client.Timeout, which limits the whole download, only connecting and waiting for the response headers
+// are limited by timeout, so a large ebook on a slow WiFi connection is not cut off.
+// When userAgent is set it is sent with every request instead of the Go default.
+func NewHTTPClient(timeout time.Duration, userAgent string) *http.Client {
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   dialTimeout,
+		ResponseHeaderTimeout: timeout,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	if userAgent != "" {
+		transport = userAgentTransport{transport: transport, userAgent: userAgent}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// userAgentTransport sets the User-Agent header of every request
+type userAgentTransport struct {
+	transport http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.transport.RoundTrip(req)
+}
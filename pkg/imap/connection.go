@@ -3,7 +3,10 @@ package imap
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
@@ -12,11 +15,19 @@ import (
 
 // Connection is a simple implementation of an IMAP connection
 type Connection struct {
-	host   string
-	port   int
-	client *client.Client
+	host     string
+	port     int
+	client   *client.Client
+	tlsState tls.ConnectionState
 
+	// ReadOnly makes sure the mailbox is not modified, messages are not marked as seen
+	ReadOnly       bool
 	SearchCriteria *imap.SearchCriteria
+	// GmailRaw and GmailLabels extend the search criteria on servers supporting the Gmail extensions
+	GmailRaw    string
+	GmailLabels []string
+	// ProcessedKeyword is stored on messages by MarkDone
+	ProcessedKeyword string
 }
 
 func (ic *Connection) connect() error {
@@ -29,11 +40,11 @@ func (ic *Connection) connect() error {
 	}
 
 	numRetries := 3
-	c, err := client.DialTLS(connStr, tlsc)
+	conn, err := tls.Dial("tcp", connStr, tlsc)
 	if err != nil {
 		for numRetries > 0 {
 			time.Sleep(1 * time.Second)
-			c, err = client.DialTLS(connStr, tlsc)
+			conn, err = tls.Dial("tcp", connStr, tlsc)
 			if err != nil {
 				numRetries--
 			} else {
@@ -46,7 +57,14 @@ func (ic *Connection) connect() error {
 		}
 	}
 
+	c, err := client.New(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
 	ic.client = c
+	ic.tlsState = conn.ConnectionState()
 	ic.SearchCriteria = imap.NewSearchCriteria()
 	return nil
 }
@@ -65,6 +83,57 @@ func ConnectToServer(host string, port int) (*Connection, error) {
 	return &connection, nil
 }
 
+// PeerCertificates returns the certificate chain presented by the server without verifying it.
+// This is only meant for diagnosing TLS problems, never use it to transfer any data.
+func PeerCertificates(host string, port int) ([]*x509.Certificate, error) {
+	connStr := fmt.Sprintf("%s:%v", host, port)
+	conn, err := tls.Dial("tcp", connStr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates, nil
+}
+
+// TLSConnectionState returns the details of the TLS connection to the server
+func (ic *Connection) TLSConnectionState() tls.ConnectionState {
+	return ic.tlsState
+}
+
+// Capabilities returns the capabilities advertised by the server
+func (ic *Connection) Capabilities() ([]string, error) {
+	caps, err := ic.client.Capability()
+	if err != nil {
+		return nil, err
+	}
+
+	var capabilities []string
+	for c := range caps {
+		capabilities = append(capabilities, c)
+	}
+	sort.Strings(capabilities)
+	return capabilities, nil
+}
+
+// ListMailboxes returns the names of all mailboxes matching pattern.
+// The pattern can contain the IMAP wildcards * and %.
+func (ic *Connection) ListMailboxes(pattern string) ([]string, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- ic.client.List("", pattern, mailboxes)
+	}()
+
+	var names []string
+	for m := range mailboxes {
+		names = append(names, m.Name)
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
 // Login identifies the client to the server and carries the plaintext password
 // authenticating this user.
 func (ic *Connection) Login(username string, password string) error {
@@ -78,57 +147,32 @@ func (ic *Connection) Logout() error {
 
 // SelectMailbox selects a mailbox so that messages in the mailbox can be accessed.
 func (ic *Connection) SelectMailbox(mailbox string) (*imap.MailboxStatus, error) {
-	return ic.client.Select(mailbox, false)
+	return ic.client.Select(mailbox, ic.ReadOnly)
 }
 
-// CollectMessages collects the messages based on the criteria set on the IMAPConnection.
-func (ic *Connection) CollectMessages() ([]*message, error) {
-	uids, err := ic.client.Search(ic.SearchCriteria)
-	if err != nil {
-		return nil, err
-	}
-
-	seqset := new(imap.SeqSet)
-	seqset.AddNum(uids...)
-
-	// Fetch the emails list
-	section := &imap.BodySectionName{}
-	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchInternalDate, section.FetchItem()}
-	messages := make(chan *imap.Message)
-	done := make(chan error, 1)
-	go func() {
-		done <- ic.client.Fetch(seqset, items, messages)
-	}()
-
-	var collectedMessages []*message
-	for msg := range messages {
-		if msg != nil {
-			collectedMessage := message{
-				imapMessage: msg,
-			}
-
-			collectedMessages = append(collectedMessages, &collectedMessage)
-		}
-	}
+// ResetSearchCriteria clears the criteria set on the IMAPConnection, for example before searching another mailbox.
+func (ic *Connection) ResetSearchCriteria() {
+	ic.SearchCriteria = imap.NewSearchCriteria()
+	ic.GmailRaw = ""
+	ic.GmailLabels = nil
+}
 
-	return collectedMessages, nil
+// SearchMessages returns the sequence numbers of the messages matching the criteria set on the IMAPConnection.
+func (ic *Connection) SearchMessages() ([]uint32, error) {
+	return ic.search(false)
 }
 
-// DeleteMessage deletes the specified message on the server.
-func (ic *Connection) DeleteMessage(msg *message) error {
-	if msg != nil {
-		seqset := new(imap.SeqSet)
-		seqset.AddNum(msg.imapMessage.SeqNum)
-
-		done := make(chan error, 1)
-		go func() {
-			done <- ic.client.Store(seqset, imap.AddFlags, []interface{}{imap.DeletedFlag}, nil)
-		}()
-		err := <-done
-		if err != nil {
-			return err
+// KeywordAllowed returns if the given keyword can be stored permanently on messages in the selected mailbox.
+// Mailboxes selected read-only never allow storing keywords.
+func (ic *Connection) KeywordAllowed(keyword string) bool {
+	mbox := ic.client.Mailbox()
+	if mbox == nil {
+		return false
+	}
+	for _, flag := range mbox.PermanentFlags {
+		if flag == imap.TryCreateFlag || strings.EqualFold(flag, keyword) {
+			return true
 		}
-		return nil
 	}
-	return nil
+	return false
 }
@@ -2,116 +2,175 @@
 package imap
 
 import (
+	"errors"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
-	"strings"
-	"time"
+	"strconv"
 
+	"github.com/bjw-s/kobomail/pkg/message"
 	"github.com/emersion/go-imap"
-	"github.com/emersion/go-message/mail"
-	"go.uber.org/zap"
+	"github.com/emersion/go-imap/commands"
 )
 
-type message struct {
-	imapMessage   *imap.Message
-	messageReader *mail.Reader
+// ErrKeywordNotAllowed is returned by MarkDone when the selected mailbox cannot store the processed keyword
+var ErrKeywordNotAllowed = errors.New("the mailbox does not allow storing the processed keyword")
 
-	Date    time.Time
-	Sender  string
-	Subject string
+// UID returns the IMAP UID of a message listed from an IMAP mailbox
+func UID(msg *message.Message) uint32 {
+	uid, _ := strconv.ParseUint(msg.ID, 10, 32)
+	return uint32(uid)
 }
 
-func (msg *message) getMessageReader() (*mail.Reader, error) {
-	if msg.messageReader != nil {
-		return msg.messageReader, nil
-	}
+// uidSet returns the UID set addressing a single message
+func uidSet(msg *message.Message) *imap.SeqSet {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(UID(msg))
+	return seqset
+}
 
-	msgBody := msg.imapMessage.GetBody(&imap.BodySectionName{})
-	if msgBody == nil {
-		return nil, fmt.Errorf("server did not return message body")
+// newMessage creates a message from the envelope and flags returned by the server
+func newMessage(msg *imap.Message) *message.Message {
+	m := &message.Message{ID: strconv.FormatUint(uint64(msg.Uid), 10)}
+	for _, flag := range msg.Flags {
+		if flag == imap.SeenFlag {
+			m.Seen = true
+		}
+	}
+	if envelope := msg.Envelope; envelope != nil {
+		m.Date = envelope.Date
+		m.Subject = envelope.Subject
+		if len(envelope.From) > 0 {
+			m.Sender = envelope.From[0].Address()
+		}
+		for _, recipient := range append(envelope.To, envelope.Cc...) {
+			m.Recipients = append(m.Recipients, recipient.Address())
+		}
 	}
+	return m
+}
 
-	msgReader, err := mail.CreateReader(msgBody)
+// List returns the messages matching the criteria set on the Connection.
+// Only their envelope and flags are fetched, use Fetch to download a message.
+func (ic *Connection) List() ([]*message.Message, error) {
+	uids, err := ic.search(true)
 	if err != nil {
 		return nil, err
 	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
 
-	msg.messageReader = msgReader
-	return msgReader, nil
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchFlags}
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- ic.client.UidFetch(seqset, items, messages)
+	}()
+
+	var listed []*message.Message
+	for msg := range messages {
+		if msg != nil {
+			listed = append(listed, newMessage(msg))
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return listed, nil
 }
 
-func (msg *message) FetchDetails() error {
-	msgReader, err := msg.getMessageReader()
-	if err != nil {
+// Fetch downloads the full message, the server flags it as seen unless the connection is read-only
+// or processed messages are marked with ProcessedKeyword, which leaves the read status to the user
+func (ic *Connection) Fetch(msg *message.Message) error {
+	section := &imap.BodySectionName{Peek: ic.ReadOnly || ic.ProcessedKeyword != ""}
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- ic.client.UidFetch(uidSet(msg), []imap.FetchItem{section.FetchItem()}, messages)
+	}()
+
+	var fetched *imap.Message
+	for m := range messages {
+		if m != nil {
+			fetched = m
+		}
+	}
+	if err := <-done; err != nil {
 		return err
 	}
-	messageDate, _ := msgReader.Header.Date()
-	messageSender, _ := msgReader.Header.AddressList("from")
-	messageSubject, _ := msgReader.Header.Subject()
-
-	msg.Date = messageDate
-	msg.Sender = messageSender[0].Address
-	msg.Subject = messageSubject
+	if fetched == nil {
+		return fmt.Errorf("server did not return message %s", msg.ID)
+	}
 
-	return nil
+	body := fetched.GetBody(&imap.BodySectionName{})
+	if body == nil {
+		return fmt.Errorf("server did not return message body")
+	}
+	return msg.Parse(body)
 }
 
-func (msg *message) ProcessAttachments(allowedExtensions []string, destinationPath string) (int, error) {
-	logger := zap.S()
-	msgReader, err := msg.getMessageReader()
-	if err != nil {
-		return 0, err
+// MarkDone marks the message as processed by storing ProcessedKeyword on it.
+// Without a processed keyword there is nothing to do, fetching the message already flagged it as seen.
+func (ic *Connection) MarkDone(msg *message.Message) error {
+	if ic.ProcessedKeyword == "" {
+		return nil
 	}
+	if ic.ReadOnly {
+		return fmt.Errorf("cannot flag message on a read-only connection")
+	}
+	if !ic.KeywordAllowed(ic.ProcessedKeyword) {
+		return ErrKeywordNotAllowed
+	}
+	return ic.client.UidStore(uidSet(msg), imap.AddFlags, []interface{}{ic.ProcessedKeyword}, nil)
+}
 
-	downloadedAttachmentCount := 0
+// uidExpunge is the UID EXPUNGE command of the UIDPLUS extension, it only removes the given deleted messages
+type uidExpunge struct {
+	seqset *imap.SeqSet
+}
 
-	// Process each message part, there might be multiple attachments
-	for {
-		p, err := msgReader.NextPart()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return 0, err
-		}
+func (cmd *uidExpunge) Command() *imap.Command {
+	return &imap.Command{
+		Name:      "EXPUNGE",
+		Arguments: []interface{}{cmd.seqset},
+	}
+}
 
-		switch h := p.Header.(type) {
-		// This is an attachment
-		case *mail.AttachmentHeader:
-			attachmentFileName, _ := h.Filename()
-			attachmentFileExtension := strings.Trim(filepath.Ext(attachmentFileName), ".")
-
-			// Only save the attachment if the filetype is allowed
-			if containsFiletype(allowedExtensions, attachmentFileExtension) {
-				// Check if the file is a kepub, rename it to .kepub.epub so kobo can properly handle it
-				if attachmentFileExtension == "kepub" {
-					attachmentFileName += ".epub"
-				}
-
-				logger.Debugw("Downloading attachment", zap.String("filename", attachmentFileName))
-
-				attachmentContent, _ := io.ReadAll(p.Body)
-				// Write the whole body at once
-				err = os.WriteFile(destinationPath+"/"+attachmentFileName, attachmentContent, 0644)
-				if err != nil {
-					return 0, err
-				}
-				logger.Infow("Succesfully downloaded attachment", zap.String("filename", attachmentFileName))
-				downloadedAttachmentCount++
-			}
-		}
+// Move moves the message to another mailbox on the server.
+// Without the MOVE extension the message is copied, flagged as deleted and expunged with UID EXPUNGE,
+// so other messages flagged as deleted are left alone. Servers without UIDPLUS keep the deleted original.
+func (ic *Connection) Move(msg *message.Message, mailbox string) error {
+	if ic.ReadOnly {
+		return fmt.Errorf("cannot move message on a read-only connection")
+	}
+	if supported, err := ic.client.Support("MOVE"); err != nil {
+		return err
+	} else if supported {
+		return ic.client.UidMove(uidSet(msg), mailbox)
 	}
 
-	return downloadedAttachmentCount, nil
+	if err := ic.client.UidCopy(uidSet(msg), mailbox); err != nil {
+		return err
+	}
+	if err := ic.client.UidStore(uidSet(msg), imap.AddFlags, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return err
+	}
+	if supported, err := ic.client.Support("UIDPLUS"); err != nil || !supported {
+		return err
+	}
+	status, err := ic.client.Execute(&commands.Uid{Cmd: &uidExpunge{seqset: uidSet(msg)}}, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
 }
 
-func containsFiletype(slice []string, item string) bool {
-	set := make(map[string]struct{}, len(slice))
-	for _, s := range slice {
-		set[s] = struct{}{}
+// Delete flags the message as deleted on the server
+func (ic *Connection) Delete(msg *message.Message) error {
+	if ic.ReadOnly {
+		return fmt.Errorf("cannot delete message on a read-only connection")
 	}
-
-	_, ok := set[item]
-	return ok
+	return ic.client.UidStore(uidSet(msg), imap.AddFlags, []interface{}{imap.DeletedFlag}, nil)
 }
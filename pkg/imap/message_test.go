@@ -0,0 +1,106 @@
+package imap
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/bjw-s/kobomail/pkg/message"
+	"github.com/emersion/go-imap/client"
+)
+
+// fakeServer answers the IMAP commands of a client on the other end of a pipe, it records every command
+type fakeServer struct {
+	capabilities string
+	commands     []string
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "* PREAUTH [CAPABILITY IMAP4rev1 %s] ready\r\n", s.capabilities)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, command, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		s.commands = append(s.commands, command)
+		switch {
+		case strings.HasPrefix(command, "SELECT"):
+			fmt.Fprintf(conn, "* 1 EXISTS\r\n* OK [UIDVALIDITY 1] ok\r\n%s OK [READ-WRITE] selected\r\n", tag)
+		case command == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK bye\r\n", tag)
+			return
+		default:
+			fmt.Fprintf(conn, "%s OK done\r\n", tag)
+		}
+	}
+}
+
+func newTestConnection(t *testing.T, server *fakeServer) (*Connection, <-chan struct{}) {
+	clientConn, serverConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.serve(serverConn)
+		close(done)
+	}()
+	c, err := client.New(clientConn)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	return &Connection{client: c}, done
+}
+
+func TestMove(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities string
+		want         []string
+	}{
+		{
+			name:         "move extension",
+			capabilities: "MOVE UIDPLUS",
+			want:         []string{`UID MOVE 7 "Failed"`},
+		},
+		{
+			name:         "uidplus extension",
+			capabilities: "UIDPLUS",
+			want:         []string{`UID COPY 7 "Failed"`, `UID STORE 7 +FLAGS.SILENT (\Deleted)`, "UID EXPUNGE 7"},
+		},
+		{
+			name:         "no extensions",
+			capabilities: "",
+			want:         []string{`UID COPY 7 "Failed"`, `UID STORE 7 +FLAGS.SILENT (\Deleted)`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeServer{capabilities: tt.capabilities}
+			ic, done := newTestConnection(t, server)
+			if err := ic.Move(&message.Message{ID: "7"}, "Failed"); err != nil {
+				t.Fatalf("Move: %v", err)
+			}
+			ic.client.Logout()
+			<-done
+
+			// Skip SELECT and LOGOUT
+			got := server.commands[1 : len(server.commands)-1]
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Move() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoveReadOnly(t *testing.T) {
+	ic := &Connection{ReadOnly: true}
+	if err := ic.Move(&message.Message{ID: "7"}, "Failed"); err == nil {
+		t.Error("Move() succeeded on a read-only connection")
+	}
+}
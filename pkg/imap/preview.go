@@ -0,0 +1,101 @@
+// Package imap implements all IMAP interactions of KoboMail
+package imap
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"github.com/emersion/go-imap"
+)
+
+// MessagePreview describes a message using only its envelope and body structure
+type MessagePreview struct {
+	UID         uint32              `json:"uid"`
+	Date        time.Time           `json:"date"`
+	Sender      string              `json:"sender"`
+	Subject     string              `json:"subject"`
+	Attachments []AttachmentPreview `json:"attachments"`
+}
+
+// AttachmentPreview describes an attachment without downloading it
+type AttachmentPreview struct {
+	Filename string `json:"filename"`
+	Size     uint32 `json:"size"`
+	Matches  bool   `json:"matches_filetypes"`
+}
+
+// attachmentSize estimates the decoded size of a body part
+func attachmentSize(part *imap.BodyStructure) uint32 {
+	if strings.EqualFold(part.Encoding, "base64") {
+		return part.Size / 4 * 3
+	}
+	return part.Size
+}
+
+func newMessagePreview(msg *imap.Message, allowedExtensions []string) MessagePreview {
+	preview := MessagePreview{
+		UID: msg.Uid,
+	}
+
+	if msg.Envelope != nil {
+		preview.Date = msg.Envelope.Date
+		preview.Subject = msg.Envelope.Subject
+		if len(msg.Envelope.From) > 0 {
+			preview.Sender = msg.Envelope.From[0].Address()
+		}
+	}
+
+	if msg.BodyStructure != nil {
+		msg.BodyStructure.Walk(func(path []int, part *imap.BodyStructure) bool {
+			if !strings.EqualFold(part.Disposition, "attachment") {
+				return true
+			}
+			filename, _ := part.Filename()
+			extension := strings.Trim(filepath.Ext(filename), ".")
+			preview.Attachments = append(preview.Attachments, AttachmentPreview{
+				Filename: filename,
+				Size:     attachmentSize(part),
+				Matches:  helpers.ContainsFiletype(allowedExtensions, extension),
+			})
+			return true
+		})
+	}
+
+	return preview
+}
+
+// PreviewMessages describes the messages matching the criteria set on the IMAPConnection.
+// Only the envelope and body structure are fetched, message bodies are never downloaded.
+func (ic *Connection) PreviewMessages(allowedExtensions []string) ([]MessagePreview, error) {
+	uids, err := ic.search(true)
+	if err != nil {
+		return nil, err
+	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchBodyStructure}
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- ic.client.UidFetch(seqset, items, messages)
+	}()
+
+	var previews []MessagePreview
+	for msg := range messages {
+		if msg != nil {
+			previews = append(previews, newMessagePreview(msg, allowedExtensions))
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+
+	return previews, nil
+}
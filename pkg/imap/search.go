@@ -0,0 +1,67 @@
+// Package imap implements all IMAP interactions of KoboMail
+package imap
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+// GmailExtensionCapability is advertised by servers supporting the Gmail IMAP extensions
+const GmailExtensionCapability = "X-GM-EXT-1"
+
+// gmailSearch is a SEARCH command extended with the Gmail X-GM-RAW and X-GM-LABELS search keys
+type gmailSearch struct {
+	criteria *imap.SearchCriteria
+	raw      string
+	labels   []string
+}
+
+func (cmd *gmailSearch) Command() *imap.Command {
+	args := cmd.criteria.Format()
+	if cmd.raw != "" {
+		args = append(args, imap.RawString("X-GM-RAW"), cmd.raw)
+	}
+	for _, label := range cmd.labels {
+		args = append(args, imap.RawString("X-GM-LABELS"), label)
+	}
+	return &imap.Command{
+		Name:      "SEARCH",
+		Arguments: args,
+	}
+}
+
+// Supports returns if the server advertises the given capability
+func (ic *Connection) Supports(capability string) (bool, error) {
+	return ic.client.Support(capability)
+}
+
+// search runs the search criteria set on the IMAPConnection, including the Gmail search keys when set.
+// When uid is set UIDs are returned instead of sequence numbers.
+func (ic *Connection) search(uid bool) ([]uint32, error) {
+	if ic.GmailRaw == "" && len(ic.GmailLabels) == 0 {
+		if uid {
+			return ic.client.UidSearch(ic.SearchCriteria)
+		}
+		return ic.client.Search(ic.SearchCriteria)
+	}
+
+	var cmd imap.Commander = &gmailSearch{
+		criteria: ic.SearchCriteria,
+		raw:      ic.GmailRaw,
+		labels:   ic.GmailLabels,
+	}
+	if uid {
+		cmd = &commands.Uid{Cmd: cmd}
+	}
+
+	res := new(responses.Search)
+	status, err := ic.client.Execute(cmd, res)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	return res.Ids, nil
+}
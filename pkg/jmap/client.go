@@ -0,0 +1,208 @@
+// Package jmap implements all JMAP interactions of KoboMail
+package jmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/pkg/helpers"
+)
+
+const (
+	capabilityCore = "urn:ietf:params:jmap:core"
+	capabilityMail = "urn:ietf:params:jmap:mail"
+
+	requestTimeout = 60 * time.Second
+)
+
+// Auth is the way the client authenticates to the JMAP server
+type Auth string
+
+// Auth values
+const (
+	// AuthBasic authenticates with the user name and password
+	AuthBasic Auth = "basic"
+	// AuthBearer authenticates with an API token, like the ones Fastmail hands out
+	AuthBearer Auth = "bearer"
+)
+
+// session is the JMAP session resource, it tells where to send requests and download blobs
+type session struct {
+	APIURL          string            `json:"apiUrl"`
+	DownloadURL     string            `json:"downloadUrl"`
+	PrimaryAccounts map[string]string `json:"primaryAccounts"`
+}
+
+// Client is a simple implementation of a JMAP mail client
+type Client struct {
+	http          *http.Client
+	authorization string
+	session       session
+	accountID     string
+	// sessionURL is the URL of the session resource, the URLs of the session are relative to it
+	sessionURL *url.URL
+
+	// mailbox is the ID of the selected mailbox
+	mailbox string
+	// mailboxes caches the mailboxes of the account
+	mailboxes []Mailbox
+
+	// ReadOnly makes sure the mailbox is not modified, messages are not marked as seen
+	ReadOnly bool
+	// Filter narrows down the emails listed in the selected mailbox
+	Filter Filter
+	// ProcessedKeyword is stored on messages by MarkDone
+	ProcessedKeyword string
+}
+
+// Dial fetches the session resource and authenticates to the JMAP server
+func Dial(sessionURL string, auth Auth, username string, password string) (*Client, error) {
+	c := &Client{http: helpers.NewHTTPClient(requestTimeout, "")}
+	if auth == AuthBearer {
+		c.authorization = "Bearer " + password
+	} else {
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		c.authorization = req.Header.Get("Authorization")
+	}
+
+	if err := c.get(sessionURL, &c.session); err != nil {
+		return nil, fmt.Errorf("failed to fetch JMAP session: %w", err)
+	}
+	c.accountID = c.session.PrimaryAccounts[capabilityMail]
+	if c.session.APIURL == "" || c.accountID == "" {
+		return nil, fmt.Errorf("server does not offer JMAP mail")
+	}
+
+	// The session resource may return relative URLs
+	base, err := url.Parse(sessionURL)
+	if err != nil {
+		return nil, err
+	}
+	c.sessionURL = base
+	if apiURL, err := base.Parse(c.session.APIURL); err == nil {
+		c.session.APIURL = apiURL.String()
+	}
+	return c, nil
+}
+
+// do sends the request with the credentials and returns the response when it succeeded
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", c.authorization)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("authentication failed")
+		}
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// get fetches a JSON resource
+func (c *Client) get(resourceURL string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, resourceURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// call invokes a single JMAP method and decodes its result into v
+func (c *Client) call(method string, args map[string]interface{}, v interface{}) error {
+	args["accountId"] = c.accountID
+	body, err := json.Marshal(map[string]interface{}{
+		"using":       []string{capabilityCore, capabilityMail},
+		"methodCalls": []interface{}{[]interface{}{method, args, "0"}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.session.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		MethodResponses [][3]json.RawMessage `json:"methodResponses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+	if len(response.MethodResponses) != 1 {
+		return fmt.Errorf("%s: unexpected response", method)
+	}
+
+	var name string
+	if err := json.Unmarshal(response.MethodResponses[0][0], &name); err != nil {
+		return err
+	}
+	if name == "error" {
+		var methodError struct {
+			Type        string `json:"type"`
+			Description string `json:"description"`
+		}
+		if err := json.Unmarshal(response.MethodResponses[0][1], &methodError); err != nil {
+			return err
+		}
+		if methodError.Description != "" {
+			return fmt.Errorf("%s: %s: %s", method, methodError.Type, methodError.Description)
+		}
+		return fmt.Errorf("%s: %s", method, methodError.Type)
+	}
+	return json.Unmarshal(response.MethodResponses[0][1], v)
+}
+
+// download returns a reader for the content of a blob, the caller must close it
+func (c *Client) download(blobID string, name string, mimeType string) (io.ReadCloser, error) {
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	downloadURL := c.session.DownloadURL
+	for variable, value := range map[string]string{
+		"{accountId}": c.accountID,
+		"{blobId}":    blobID,
+		"{name}":      name,
+		"{type}":      mimeType,
+	} {
+		downloadURL = strings.ReplaceAll(downloadURL, variable, url.PathEscape(value))
+	}
+	// The download URL is a template, it is resolved once the variables are filled in
+	target, err := c.sessionURL.Parse(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob %s: %w", blobID, err)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,304 @@
+// Package jmap implements all JMAP interactions of KoboMail
+package jmap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/pkg/message"
+)
+
+// queryPageSize is the number of emails queried at once, servers limit the number of emails per request
+const queryPageSize = 100
+
+// Filter is an Email/query filter, either a Condition or an Operator
+type Filter interface {
+	filter()
+}
+
+// Condition matches the emails matching all properties that are set
+type Condition struct {
+	InMailbox  string `json:"inMailbox,omitempty"`
+	After      string `json:"after,omitempty"`
+	Before     string `json:"before,omitempty"`
+	MinSize    uint32 `json:"minSize,omitempty"`
+	MaxSize    uint32 `json:"maxSize,omitempty"`
+	HasKeyword string `json:"hasKeyword,omitempty"`
+	NotKeyword string `json:"notKeyword,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	Subject    string `json:"subject,omitempty"`
+}
+
+// Operator combines filters, Operator is AND, OR or NOT
+type Operator struct {
+	Operator   string   `json:"operator"`
+	Conditions []Filter `json:"conditions"`
+}
+
+func (Condition) filter() {}
+func (Operator) filter()  {}
+
+// And matches the emails matching all filters
+func And(filters ...Filter) Filter {
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	return Operator{Operator: "AND", Conditions: filters}
+}
+
+// Or matches the emails matching any of the filters
+func Or(filters ...Filter) Filter {
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	return Operator{Operator: "OR", Conditions: filters}
+}
+
+// UTCDate formats a date the way JMAP filters expect it
+func UTCDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// Keyword returns the JMAP keyword of an IMAP flag, system flags like \Seen have their own keywords
+func Keyword(flag string) string {
+	switch strings.ToLower(flag) {
+	case `\seen`:
+		return "$seen"
+	case `\flagged`:
+		return "$flagged"
+	case `\answered`:
+		return "$answered"
+	case `\draft`:
+		return "$draft"
+	}
+	return flag
+}
+
+type address struct {
+	Email string `json:"email"`
+}
+
+type bodyPart struct {
+	BlobID      string `json:"blobId"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Size        int    `json:"size"`
+	Disposition string `json:"disposition"`
+}
+
+type email struct {
+	ID          string          `json:"id"`
+	ReceivedAt  time.Time       `json:"receivedAt"`
+	SentAt      time.Time       `json:"sentAt"`
+	From        []address       `json:"from"`
+	To          []address       `json:"to"`
+	Cc          []address       `json:"cc"`
+	Subject     string          `json:"subject"`
+	Keywords    map[string]bool `json:"keywords"`
+	Attachments []bodyPart      `json:"attachments"`
+}
+
+func newMessage(e email) *message.Message {
+	msg := &message.Message{
+		ID:      e.ID,
+		Date:    e.SentAt,
+		Subject: e.Subject,
+		Seen:    e.Keywords["$seen"],
+	}
+	if msg.Date.IsZero() {
+		msg.Date = e.ReceivedAt
+	}
+	if len(e.From) > 0 {
+		msg.Sender = e.From[0].Email
+	}
+	for _, recipient := range append(e.To, e.Cc...) {
+		msg.Recipients = append(msg.Recipients, recipient.Email)
+	}
+	return msg
+}
+
+// query returns the IDs of the emails in the selected mailbox matching the filter, oldest first
+func (c *Client) query() ([]string, error) {
+	if c.mailbox == "" {
+		return nil, fmt.Errorf("no mailbox selected")
+	}
+	filter := Filter(Condition{InMailbox: c.mailbox})
+	if c.Filter != nil {
+		filter = And(filter, c.Filter)
+	}
+
+	var ids []string
+	for {
+		var result struct {
+			IDs   []string `json:"ids"`
+			Total int      `json:"total"`
+		}
+		err := c.call("Email/query", map[string]interface{}{
+			"filter":         filter,
+			"sort":           []map[string]interface{}{{"property": "receivedAt", "isAscending": true}},
+			"position":       len(ids),
+			"limit":          queryPageSize,
+			"calculateTotal": true,
+		}, &result)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, result.IDs...)
+		if len(result.IDs) == 0 || len(ids) >= result.Total {
+			return ids, nil
+		}
+	}
+}
+
+// Count returns the number of emails in the selected mailbox matching the filter
+func (c *Client) Count() (int, error) {
+	ids, err := c.query()
+	return len(ids), err
+}
+
+// List returns the emails in the selected mailbox matching the filter, only their headers are downloaded.
+// Use Fetch to read their attachments.
+func (c *Client) List() ([]*message.Message, error) {
+	ids, err := c.query()
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*message.Message
+	for start := 0; start < len(ids); start += queryPageSize {
+		end := start + queryPageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var result struct {
+			List []email `json:"list"`
+		}
+		err := c.call("Email/get", map[string]interface{}{
+			"ids":        ids[start:end],
+			"properties": []string{"id", "receivedAt", "sentAt", "from", "to", "cc", "subject", "keywords"},
+		}, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range result.List {
+			messages = append(messages, newMessage(e))
+		}
+	}
+	return messages, nil
+}
+
+// Fetch reads the attachments of the email.
+// Only their metadata is fetched, the content of an attachment is downloaded when it is read.
+func (c *Client) Fetch(msg *message.Message) error {
+	var result struct {
+		List     []email  `json:"list"`
+		NotFound []string `json:"notFound"`
+	}
+	err := c.call("Email/get", map[string]interface{}{
+		"ids":            []string{msg.ID},
+		"properties":     []string{"attachments"},
+		"bodyProperties": []string{"blobId", "name", "type", "size", "disposition"},
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if len(result.List) != 1 {
+		return fmt.Errorf("message %s does not exist", msg.ID)
+	}
+
+	var attachments []message.Attachment
+	for _, part := range result.List[0].Attachments {
+		// Inline parts like images in the email body are not attachments
+		if part.Disposition == "inline" || part.Name == "" {
+			continue
+		}
+		part := part
+		attachments = append(attachments, message.NewRemoteAttachment(part.Name, part.Type, part.Size, func() (io.ReadCloser, error) {
+			return c.download(part.BlobID, part.Name, part.Type)
+		}))
+	}
+	msg.SetAttachments(attachments)
+	return nil
+}
+
+// update applies a patch to the email
+func (c *Client) update(msg *message.Message, patch map[string]interface{}) error {
+	if c.ReadOnly {
+		return fmt.Errorf("cannot modify message on a read-only connection")
+	}
+	return c.set(map[string]interface{}{"update": map[string]interface{}{msg.ID: patch}}, msg.ID)
+}
+
+// set invokes Email/set and returns the error reported for the email, if any
+func (c *Client) set(args map[string]interface{}, id string) error {
+	type setError struct {
+		Type        string `json:"type"`
+		Description string `json:"description"`
+	}
+	var result struct {
+		NotUpdated   map[string]setError `json:"notUpdated"`
+		NotDestroyed map[string]setError `json:"notDestroyed"`
+	}
+	if err := c.call("Email/set", args, &result); err != nil {
+		return err
+	}
+	for _, failed := range []map[string]setError{result.NotUpdated, result.NotDestroyed} {
+		if e, ok := failed[id]; ok {
+			if e.Description != "" {
+				return fmt.Errorf("%s: %s", e.Type, e.Description)
+			}
+			return fmt.Errorf("%s", e.Type)
+		}
+	}
+	return nil
+}
+
+// patchPath escapes a property name for use in a patch
+func patchPath(property string, key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	return property + "/" + strings.ReplaceAll(key, "/", "~1")
+}
+
+// MarkDone stores the processed keyword on the message, or flags it as seen when no keyword is set.
+// The read status is left alone when the keyword is set, like on IMAP servers.
+func (c *Client) MarkDone(msg *message.Message) error {
+	keyword := "$seen"
+	if c.ProcessedKeyword != "" {
+		keyword = Keyword(c.ProcessedKeyword)
+	}
+	return c.update(msg, map[string]interface{}{patchPath("keywords", keyword): true})
+}
+
+// Move moves the message from the selected mailbox to another mailbox
+func (c *Client) Move(msg *message.Message, mailbox string) error {
+	target, err := c.findMailbox(mailbox)
+	if err != nil {
+		return err
+	}
+	return c.update(msg, map[string]interface{}{
+		patchPath("mailboxIds", c.mailbox): nil,
+		patchPath("mailboxIds", target.ID): true,
+	})
+}
+
+// Delete moves the message to the trash mailbox, like mail clients do.
+// The message is destroyed when the account has no trash mailbox or the message is already in it.
+func (c *Client) Delete(msg *message.Message) error {
+	if c.ReadOnly {
+		return fmt.Errorf("cannot delete message on a read-only connection")
+	}
+	trash, err := c.findRole("trash")
+	if err != nil {
+		return err
+	}
+	if trash.ID == "" || trash.ID == c.mailbox {
+		return c.set(map[string]interface{}{"destroy": []string{msg.ID}}, msg.ID)
+	}
+	return c.update(msg, map[string]interface{}{
+		patchPath("mailboxIds", c.mailbox): nil,
+		patchPath("mailboxIds", trash.ID):  true,
+	})
+}
@@ -0,0 +1,277 @@
+package jmap
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bjw-s/kobomail/pkg/message"
+)
+
+// fakeServer is a minimal JMAP server serving a fixed account, it records the Email/set calls
+type fakeServer struct {
+	*httptest.Server
+	t         *testing.T
+	mailboxes []Mailbox
+	emails    []email
+	blobs     map[string]string
+	sets      []map[string]interface{}
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	f := &fakeServer{
+		t: t,
+		mailboxes: []Mailbox{
+			{ID: "mb-inbox", Name: "Inbox", Role: "inbox"},
+			{ID: "mb-trash", Name: "Trash", Role: "trash"},
+			{ID: "mb-kobo", Name: "Kobo"},
+			{ID: "mb-done", Name: "Done", ParentID: "mb-kobo"},
+		},
+		emails: []email{
+			{
+				ID:      "e1",
+				Subject: "[MyKobo] dune",
+				From:    []address{{Email: "alice@example.org"}},
+				To:      []address{{Email: "me@example.org"}},
+				Attachments: []bodyPart{
+					{BlobID: "b1", Name: "dune.epub", Type: "application/epub+zip", Size: 4},
+					{BlobID: "b2", Name: "logo.png", Type: "image/png", Disposition: "inline"},
+				},
+			},
+			{ID: "e2", Subject: "[MyKobo] other", Keywords: map[string]bool{"$seen": true}},
+		},
+		blobs: map[string]string{"b1": "book"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/jmap", func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "me" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"apiUrl":          "/api",
+			"downloadUrl":     "/download/{accountId}/{blobId}/{name}?type={type}",
+			"primaryAccounts": map[string]string{capabilityMail: "acc"},
+		})
+	})
+	mux.HandleFunc("/api", f.api)
+	mux.HandleFunc("/download/acc/", func(w http.ResponseWriter, r *http.Request) {
+		blobID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/download/acc/"), "/")
+		blob, ok := f.blobs[blobID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, blob)
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeServer) api(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		MethodCalls [][3]json.RawMessage `json:"methodCalls"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		f.t.Errorf("invalid request: %v", err)
+		return
+	}
+	var method string
+	var args map[string]interface{}
+	json.Unmarshal(request.MethodCalls[0][0], &method)
+	json.Unmarshal(request.MethodCalls[0][1], &args)
+	if args["accountId"] != "acc" {
+		f.t.Errorf("%s: accountId = %v", method, args["accountId"])
+	}
+
+	var result interface{}
+	switch method {
+	case "Mailbox/get":
+		result = map[string]interface{}{"list": f.mailboxes}
+	case "Email/query":
+		var ids []string
+		for _, e := range f.emails {
+			ids = append(ids, e.ID)
+		}
+		result = map[string]interface{}{"ids": ids, "total": len(ids)}
+	case "Email/get":
+		var list []email
+		for _, id := range args["ids"].([]interface{}) {
+			for _, e := range f.emails {
+				if e.ID == id {
+					list = append(list, e)
+				}
+			}
+		}
+		result = map[string]interface{}{"list": list}
+	case "Email/set":
+		f.sets = append(f.sets, args)
+		result = map[string]interface{}{}
+	default:
+		result = map[string]interface{}{"type": "unknownMethod"}
+		method = "error"
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"methodResponses": []interface{}{[]interface{}{method, result, "0"}},
+	})
+}
+
+// lastUpdate returns the patch of the last Email/set update of the email
+func (f *fakeServer) lastUpdate(id string) map[string]interface{} {
+	f.t.Helper()
+	if len(f.sets) == 0 {
+		f.t.Fatal("no Email/set call")
+	}
+	update, _ := f.sets[len(f.sets)-1]["update"].(map[string]interface{})
+	patch, ok := update[id].(map[string]interface{})
+	if !ok {
+		f.t.Fatalf("Email/set does not update %s: %v", id, f.sets[len(f.sets)-1])
+	}
+	return patch
+}
+
+func dialFake(t *testing.T, f *fakeServer) *Client {
+	t.Helper()
+	c, err := Dial(f.URL+"/.well-known/jmap", AuthBasic, "me", "secret")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, err := c.SelectMailbox("INBOX"); err != nil {
+		t.Fatalf("SelectMailbox: %v", err)
+	}
+	return c
+}
+
+func TestDialAuthenticationFailed(t *testing.T) {
+	f := newFakeServer(t)
+	if _, err := Dial(f.URL+"/.well-known/jmap", AuthBasic, "me", "wrong"); err == nil {
+		t.Fatal("Dial succeeded with a wrong password")
+	}
+}
+
+func TestListAndFetch(t *testing.T) {
+	f := newFakeServer(t)
+	c := dialFake(t, f)
+
+	messages, err := c.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("List returned %d messages, want 2", len(messages))
+	}
+	msg := messages[0]
+	if msg.ID != "e1" || msg.Sender != "alice@example.org" || msg.Seen || !messages[1].Seen {
+		t.Errorf("unexpected messages: %+v, %+v", msg, messages[1])
+	}
+
+	if err := c.Fetch(msg); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	attachments, err := msg.Attachments()
+	if err != nil {
+		t.Fatalf("Attachments: %v", err)
+	}
+	if len(attachments) != 1 || attachments[0].Filename != "dune.epub" {
+		t.Fatalf("Fetch returned attachments %+v, want only dune.epub", attachments)
+	}
+	r, err := attachments[0].Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil || string(content) != "book" {
+		t.Errorf("attachment content = %q, %v", content, err)
+	}
+}
+
+func TestSelectMailboxPath(t *testing.T) {
+	f := newFakeServer(t)
+	c := dialFake(t, f)
+	mbox, err := c.SelectMailbox("Kobo/Done")
+	if err != nil || mbox.ID != "mb-done" {
+		t.Errorf("SelectMailbox(Kobo/Done) = %+v, %v", mbox, err)
+	}
+	if _, err := c.SelectMailbox("Missing"); err == nil {
+		t.Error("SelectMailbox(Missing) succeeded")
+	}
+}
+
+func TestMarkDone(t *testing.T) {
+	f := newFakeServer(t)
+	c := dialFake(t, f)
+	msg := &message.Message{ID: "e1"}
+
+	if err := c.MarkDone(msg); err != nil {
+		t.Fatalf("MarkDone: %v", err)
+	}
+	if patch := f.lastUpdate("e1"); len(patch) != 1 || patch["keywords/$seen"] != true {
+		t.Errorf("MarkDone without keyword patched %v", patch)
+	}
+
+	c.ProcessedKeyword = "$KoboMailDone"
+	if err := c.MarkDone(msg); err != nil {
+		t.Fatalf("MarkDone: %v", err)
+	}
+	if patch := f.lastUpdate("e1"); len(patch) != 1 || patch["keywords/$KoboMailDone"] != true {
+		t.Errorf("MarkDone with keyword patched %v", patch)
+	}
+}
+
+func TestMove(t *testing.T) {
+	f := newFakeServer(t)
+	c := dialFake(t, f)
+	if err := c.Move(&message.Message{ID: "e1"}, "Kobo/Done"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	patch := f.lastUpdate("e1")
+	if v, ok := patch["mailboxIds/mb-inbox"]; !ok || v != nil || patch["mailboxIds/mb-done"] != true {
+		t.Errorf("Move patched %v", patch)
+	}
+}
+
+func TestDeleteMovesToTrash(t *testing.T) {
+	f := newFakeServer(t)
+	c := dialFake(t, f)
+	if err := c.Delete(&message.Message{ID: "e1"}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	patch := f.lastUpdate("e1")
+	if v, ok := patch["mailboxIds/mb-inbox"]; !ok || v != nil || patch["mailboxIds/mb-trash"] != true {
+		t.Errorf("Delete patched %v", patch)
+	}
+	if _, ok := f.sets[0]["destroy"]; ok {
+		t.Error("Delete destroyed the email")
+	}
+}
+
+func TestDeleteWithoutTrash(t *testing.T) {
+	f := newFakeServer(t)
+	f.mailboxes = f.mailboxes[:1]
+	c := dialFake(t, f)
+	if err := c.Delete(&message.Message{ID: "e1"}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	destroy, _ := f.sets[0]["destroy"].([]interface{})
+	if len(destroy) != 1 || destroy[0] != "e1" {
+		t.Errorf("Delete without trash mailbox sent %v", f.sets[0])
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	f := newFakeServer(t)
+	c := dialFake(t, f)
+	c.ReadOnly = true
+	msg := &message.Message{ID: "e1"}
+	if c.MarkDone(msg) == nil || c.Move(msg, "Trash") == nil || c.Delete(msg) == nil {
+		t.Error("read-only client modified a message")
+	}
+	if len(f.sets) != 0 {
+		t.Errorf("read-only client sent Email/set: %v", f.sets)
+	}
+}
@@ -0,0 +1,86 @@
+// Package jmap implements all JMAP interactions of KoboMail
+package jmap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mailbox is a mailbox of the JMAP account
+type Mailbox struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	ParentID string `json:"parentId"`
+	Role     string `json:"role"`
+	// Path is the name of the mailbox including its parents separated by /, like IMAP names it
+	Path string `json:"-"`
+}
+
+// Mailboxes returns all mailboxes of the account
+func (c *Client) Mailboxes() ([]Mailbox, error) {
+	if c.mailboxes != nil {
+		return c.mailboxes, nil
+	}
+
+	var result struct {
+		List []Mailbox `json:"list"`
+	}
+	if err := c.call("Mailbox/get", map[string]interface{}{"ids": nil}, &result); err != nil {
+		return nil, err
+	}
+
+	byID := map[string]Mailbox{}
+	for _, mbox := range result.List {
+		byID[mbox.ID] = mbox
+	}
+	for i, mbox := range result.List {
+		path := []string{mbox.Name}
+		// Guard against parent loops in broken servers
+		for parent, depth := mbox.ParentID, 0; parent != "" && depth < len(result.List); depth++ {
+			p := byID[parent]
+			path = append([]string{p.Name}, path...)
+			parent = p.ParentID
+		}
+		result.List[i].Path = strings.Join(path, "/")
+	}
+	c.mailboxes = result.List
+	return c.mailboxes, nil
+}
+
+// findMailbox returns the mailbox with the given name, INBOX is the mailbox with the inbox role like on IMAP servers
+func (c *Client) findMailbox(name string) (Mailbox, error) {
+	mailboxes, err := c.Mailboxes()
+	if err != nil {
+		return Mailbox{}, err
+	}
+	for _, mbox := range mailboxes {
+		if mbox.Path == name || (strings.EqualFold(name, "INBOX") && mbox.Role == "inbox") {
+			return mbox, nil
+		}
+	}
+	return Mailbox{}, fmt.Errorf("mailbox %s does not exist", name)
+}
+
+// findRole returns the mailbox with the given role, like "trash", or an empty mailbox when the account has none
+func (c *Client) findRole(role string) (Mailbox, error) {
+	mailboxes, err := c.Mailboxes()
+	if err != nil {
+		return Mailbox{}, err
+	}
+	for _, mbox := range mailboxes {
+		if mbox.Role == role {
+			return mbox, nil
+		}
+	}
+	return Mailbox{}, nil
+}
+
+// SelectMailbox selects the mailbox emails are listed from
+func (c *Client) SelectMailbox(name string) (Mailbox, error) {
+	mbox, err := c.findMailbox(name)
+	if err != nil {
+		return Mailbox{}, err
+	}
+	c.mailbox = mbox.ID
+	return mbox, nil
+}
@@ -0,0 +1,51 @@
+// Package koboreader implements all interactions with the Kobo library database
+package koboreader
+
+// Highlight is a highlighted passage or note stored in the Bookmark table
+type Highlight struct {
+	BookmarkID  string
+	VolumeID    string
+	BookTitle   string
+	BookAuthor  string
+	Text        string
+	Annotation  string
+	DateCreated string
+}
+
+const highlightsQuery = `
+SELECT
+	b.BookmarkID,
+	b.VolumeID,
+	IFNULL(c.Title, ''),
+	IFNULL(c.Attribution, ''),
+	IFNULL(b.Text, ''),
+	IFNULL(b.Annotation, ''),
+	IFNULL(b.DateCreated, '')
+FROM Bookmark b
+LEFT JOIN content c ON c.ContentID = b.VolumeID AND c.ContentType = 6
+WHERE (b.Hidden IS NULL OR b.Hidden IN ('false', 0))
+	AND (IFNULL(b.Text, '') != '' OR IFNULL(b.Annotation, '') != '')
+	AND IFNULL(b.DateCreated, '') > ?
+ORDER BY c.Title, b.VolumeID, b.DateCreated`
+
+// Highlights returns all highlights and notes created after the given timestamp.
+// The timestamp uses the same format Nickel stores in Bookmark.DateCreated, an
+// empty string returns everything.
+func (d *Database) Highlights(since string) ([]Highlight, error) {
+	rows, err := d.db.Query(highlightsQuery, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var highlights []Highlight
+	for rows.Next() {
+		var h Highlight
+		err := rows.Scan(&h.BookmarkID, &h.VolumeID, &h.BookTitle, &h.BookAuthor, &h.Text, &h.Annotation, &h.DateCreated)
+		if err != nil {
+			return nil, err
+		}
+		highlights = append(highlights, h)
+	}
+	return highlights, rows.Err()
+}
@@ -0,0 +1,74 @@
+// Package koboreader implements all interactions with the Kobo library database
+package koboreader
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"go.uber.org/zap"
+
+	// Pure Go SQLite driver, so KoboMail can still be built without CGO
+	_ "modernc.org/sqlite"
+)
+
+// DatabasePath is the location of the Nickel library database
+const DatabasePath = "/mnt/onboard/.kobo/KoboReader.sqlite"
+
+// Database is a handle on the Nickel library database
+type Database struct {
+	db *sql.DB
+}
+
+// IsInstalled determines if the Nickel library database is present
+func IsInstalled() (installed bool) {
+	return helpers.FileExists(DatabasePath)
+}
+
+// NickelRunning determines if Nickel is running, Nickel keeps the library database open while it runs
+// and does not pick up changes made by other processes
+func NickelRunning() bool {
+	comms, _ := filepath.Glob("/proc/[0-9]*/comm")
+	for _, comm := range comms {
+		name, err := os.ReadFile(comm)
+		if err == nil && strings.TrimSpace(string(name)) == "nickel" {
+			return true
+		}
+	}
+	return false
+}
+
+// Open opens the Nickel library database in read-only mode
+func Open() (*Database, error) {
+	return open("ro")
+}
+
+// OpenReadWrite opens the Nickel library database so it can be modified
+func OpenReadWrite() (*Database, error) {
+	return open("rw")
+}
+
+func open(mode string) (*Database, error) {
+	logger := zap.S()
+	logger.Debugw(
+		"Opening Kobo library database",
+		zap.String("file", DatabasePath),
+		zap.String("mode", mode),
+	)
+	db, err := sql.Open("sqlite", "file:"+DatabasePath+"?mode="+mode+"&_pragma=busy_timeout(10000)")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Database{db: db}, nil
+}
+
+// Close closes the database handle
+func (d *Database) Close() error {
+	return d.db.Close()
+}
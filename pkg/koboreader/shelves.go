@@ -0,0 +1,60 @@
+// Package koboreader implements all interactions with the Kobo library database
+package koboreader
+
+import (
+	"time"
+)
+
+// nickelTimeFormat is the timestamp format Nickel uses in the database
+const nickelTimeFormat = "2006-01-02T15:04:05Z"
+
+// ContentID returns the identifier Nickel uses for a sideloaded book at path
+func ContentID(path string) string {
+	return "file://" + path
+}
+
+// AddToShelf adds the books to the shelf (a collection in the Nickel UI), creating the shelf when needed.
+// Nickel only picks up the changes after the library has been refreshed.
+func (d *Database) AddToShelf(shelfName string, contentIDs []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(nickelTimeFormat)
+
+	var exists int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM Shelf WHERE InternalName = ? AND _IsDeleted = 'false'`, shelfName).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		_, err = tx.Exec(`
+INSERT INTO Shelf (CreationDate, Id, InternalName, LastModified, Name, Type, _IsDeleted, _IsVisible, _IsSynced)
+VALUES (?, ?, ?, ?, ?, 'UserTag', 'false', 'true', 'false')`,
+			now, shelfName, shelfName, now, shelfName)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, contentID := range contentIDs {
+		err = tx.QueryRow(`SELECT COUNT(*) FROM ShelfContent WHERE ShelfName = ? AND ContentId = ? AND _IsDeleted = 'false'`, shelfName, contentID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			continue
+		}
+		_, err = tx.Exec(`
+INSERT INTO ShelfContent (ShelfName, ContentId, DateModified, _IsDeleted, _IsSynced)
+VALUES (?, ?, ?, 'false', 'false')`,
+			shelfName, contentID, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,343 @@
+// Package localmail implements reading emails copied onto the Kobo, as single .eml files, Maildirs or mbox files
+package localmail
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bjw-s/kobomail/pkg/message"
+	"go.uber.org/zap"
+)
+
+// entry locates a message within the import folder
+type entry struct {
+	// file is the path of the file containing the message, relative to the import folder
+	file string
+	// offset and length locate the message within an mbox file
+	mbox   bool
+	offset int64
+	length int64
+}
+
+// Source reads the emails placed in an import folder.
+// Handled files are moved to the done folder when the source is closed, files of which a message failed stay in place.
+type Source struct {
+	root    string
+	doneDir string
+
+	// ReadOnly makes sure no files are moved or deleted
+	ReadOnly bool
+	// Done holds the IDs of the messages that were already handled in files that stayed in place,
+	// they are not listed again. After Close it only contains the IDs of messages in files still in the import folder.
+	Done map[string]bool
+
+	entries map[string]entry
+	// messages counts the messages in every file, done counts the handled ones including the ones handled before.
+	// marked and deleted count the messages handled and deleted in this session.
+	messages map[string]int
+	done     map[string]int
+	marked   map[string]int
+	deleted  map[string]int
+}
+
+// Open returns a source reading the emails in root, handled files are moved to doneDir
+func Open(root string, doneDir string) *Source {
+	return &Source{root: root, doneDir: doneDir, Done: map[string]bool{}}
+}
+
+// List returns the emails in the import folder, only their headers are read.
+// Use Fetch to read a message.
+func (s *Source) List() ([]*message.Message, error) {
+	s.entries = map[string]entry{}
+	s.messages = map[string]int{}
+	s.done = map[string]int{}
+	s.marked = map[string]int{}
+	s.deleted = map[string]int{}
+
+	var messages []*message.Message
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == s.root {
+			return nil
+		}
+		// Skip handled files, hidden files and messages still being delivered to a Maildir
+		if path == s.doneDir || strings.HasPrefix(d.Name(), ".") || (d.IsDir() && d.Name() == "tmp") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		file, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		listed, err := s.listFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		messages = append(messages, listed...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Forget handled messages that are no longer in the import folder, skip the others
+	unhandled := messages[:0]
+	for _, msg := range messages {
+		if !s.Done[msg.ID] {
+			unhandled = append(unhandled, msg)
+			continue
+		}
+		s.done[s.entries[msg.ID].file]++
+	}
+	for id := range s.Done {
+		if _, ok := s.entries[id]; !ok {
+			delete(s.Done, id)
+		}
+	}
+	return unhandled, nil
+}
+
+// listFile returns the messages in a single file of the import folder
+func (s *Source) listFile(file string) ([]*message.Message, error) {
+	f, err := os.Open(filepath.Join(s.root, file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	start, _ := r.Peek(5)
+	dir := filepath.Base(filepath.Dir(file))
+	switch {
+	case string(start) == "From ":
+		return s.listMbox(file, r)
+	case strings.EqualFold(filepath.Ext(file), ".eml") || dir == "cur" || dir == "new":
+		msg := &message.Message{ID: file}
+		if err := msg.Parse(bytes.NewReader(readHeader(r))); err != nil {
+			return nil, err
+		}
+		s.entries[msg.ID] = entry{file: file}
+		s.messages[file] = 1
+		return []*message.Message{msg}, nil
+	}
+	zap.S().Debugw("Skipping file that is not an email", zap.String("file", file))
+	return nil, nil
+}
+
+// listMbox splits an mbox file into its messages, every message starts with a From line
+func (s *Source) listMbox(file string, r *bufio.Reader) ([]*message.Message, error) {
+	var messages []*message.Message
+	var offset int64
+	var current *entry
+	var header bytes.Buffer
+	inHeader, previousEmpty := false, true
+
+	finish := func(end int64) error {
+		if current == nil {
+			return nil
+		}
+		current.length = end - current.offset
+		msg := &message.Message{ID: file + "#" + strconv.Itoa(len(messages)+1)}
+		if err := msg.Parse(bytes.NewReader(header.Bytes())); err != nil {
+			return err
+		}
+		s.entries[msg.ID] = *current
+		messages = append(messages, msg)
+		return nil
+	}
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if previousEmpty && bytes.HasPrefix(line, []byte("From ")) {
+				// The empty line before the From line belongs to the separator
+				end := offset
+				if offset > 0 {
+					end--
+				}
+				if err := finish(end); err != nil {
+					return nil, err
+				}
+				current = &entry{file: file, mbox: true, offset: offset + int64(len(line))}
+				header.Reset()
+				inHeader = true
+			} else if inHeader {
+				header.Write(unescapeFrom(line))
+				inHeader = len(bytes.TrimRight(line, "\r\n")) > 0
+			}
+			previousEmpty = len(bytes.TrimRight(line, "\r\n")) == 0
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if err := finish(offset); err != nil {
+		return nil, err
+	}
+	s.messages[file] = len(messages)
+	return messages, nil
+}
+
+// readHeader returns the header of a message, up to and including the empty line ending it
+func readHeader(r *bufio.Reader) []byte {
+	var header bytes.Buffer
+	for {
+		line, err := r.ReadBytes('\n')
+		header.Write(line)
+		if err != nil || len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return header.Bytes()
+		}
+	}
+}
+
+// unescapeFrom removes the > mbox files put in front of lines starting with From inside a message
+func unescapeFrom(line []byte) []byte {
+	if trimmed := bytes.TrimLeft(line, ">"); len(trimmed) < len(line) && bytes.HasPrefix(trimmed, []byte("From ")) {
+		return line[1:]
+	}
+	return line
+}
+
+// Fetch reads the full message
+func (s *Source) Fetch(msg *message.Message) error {
+	e, ok := s.entries[msg.ID]
+	if !ok {
+		return fmt.Errorf("message %s is not in the import folder", msg.ID)
+	}
+	if !e.mbox {
+		content, err := os.ReadFile(filepath.Join(s.root, e.file))
+		if err != nil {
+			return err
+		}
+		return msg.Parse(bytes.NewReader(content))
+	}
+
+	f, err := os.Open(filepath.Join(s.root, e.file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var content bytes.Buffer
+	r := bufio.NewReader(io.NewSectionReader(f, e.offset, e.length))
+	for {
+		line, err := r.ReadBytes('\n')
+		content.Write(unescapeFrom(line))
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return msg.Parse(&content)
+}
+
+// MarkDone records the message as handled, its file is moved to the done folder on Close
+// once all messages in the file are handled
+func (s *Source) MarkDone(msg *message.Message) error {
+	e, ok := s.entries[msg.ID]
+	if !ok {
+		return fmt.Errorf("message %s is not in the import folder", msg.ID)
+	}
+	if s.ReadOnly {
+		return fmt.Errorf("cannot mark message as done on a read-only source")
+	}
+	if !s.Done[msg.ID] {
+		s.Done[msg.ID] = true
+		s.done[e.file]++
+		s.marked[e.file]++
+	}
+	return nil
+}
+
+// Delete marks the message for deletion, its file is deleted on Close instead of moved
+// once all messages in the file are handled and all messages handled in this session are deleted
+func (s *Source) Delete(msg *message.Message) error {
+	e, ok := s.entries[msg.ID]
+	if !ok {
+		return fmt.Errorf("message %s is not in the import folder", msg.ID)
+	}
+	if s.ReadOnly {
+		return fmt.Errorf("cannot delete message on a read-only source")
+	}
+	s.deleted[e.file]++
+	return nil
+}
+
+// Close moves or deletes the files of which all messages are handled.
+// Messages handled in earlier sessions were deleted as well when all messages handled in this session are.
+func (s *Source) Close() error {
+	var files []string
+	for file, done := range s.done {
+		if done >= s.messages[file] {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	var errs []error
+	for _, file := range files {
+		path := filepath.Join(s.root, file)
+		var err error
+		if s.marked[file] > 0 && s.deleted[file] >= s.marked[file] {
+			err = os.Remove(path)
+		} else {
+			err = moveFile(path, filepath.Join(s.doneDir, file))
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for id, e := range s.entries {
+			if e.file == file {
+				delete(s.Done, id)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DoneIDs returns the IDs of the handled messages in files that are still in the import folder
+func (s *Source) DoneIDs() []string {
+	ids := make([]string, 0, len(s.Done))
+	for id := range s.Done {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// moveFile moves a file, adding a number to its name when the destination already exists
+func moveFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	ext := filepath.Ext(dst)
+	base := strings.TrimSuffix(dst, ext)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(dst); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		dst = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,236 @@
+package localmail
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bjw-s/kobomail/pkg/message"
+)
+
+const mbox = "From alice@example.org Mon Jan  1 00:00:00 2024\n" +
+	"From: alice@example.org\n" +
+	"Subject: First\n" +
+	"\n" +
+	"Body one\n" +
+	"From the start, a line and not a separator\n" +
+	"\n" +
+	"From bob@example.org Mon Jan  1 00:00:00 2024\n" +
+	"From: bob@example.org\n" +
+	"Subject: Second\n" +
+	"Content-Type: text/plain\n" +
+	"Content-Disposition: attachment; filename=letter.txt\n" +
+	"\n" +
+	">From the escaped line\n" +
+	">>From the doubly escaped line\n" +
+	"last line\n" +
+	"\n" +
+	"From carol@example.org Mon Jan  1 00:00:00 2024\n" +
+	"Subject: Third\n" +
+	"\n" +
+	"Body three\n"
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestList(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"book.EML":                  "Subject: Book\r\n\r\nbody",
+		"archive.mbox":              mbox,
+		"Maildir/cur/1.host:2,S":    "Subject: Seen\n\nbody",
+		"Maildir/new/2.host":        "Subject: New\n\nbody",
+		"Maildir/tmp/3.host":        "Subject: Delivering\n\nbody",
+		"notes.txt":                 "Subject: Not an email\n\nbody",
+		".hidden.eml":               "Subject: Hidden\n\nbody",
+		"Done/handled.eml":          "Subject: Handled\n\nbody",
+		"Maildir/new/.partial.host": "Subject: Partial\n\nbody",
+	})
+	s := Open(root, filepath.Join(root, "Done"))
+
+	messages, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var got []string
+	for _, msg := range messages {
+		got = append(got, msg.ID+"="+msg.Subject)
+	}
+	sort.Strings(got)
+	want := []string{
+		"Maildir/cur/1.host:2,S=Seen",
+		"Maildir/new/2.host=New",
+		"archive.mbox#1=First",
+		"archive.mbox#2=Second",
+		"archive.mbox#3=Third",
+		"book.EML=Book",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("List() = %q, want %q", got, want)
+	}
+}
+
+func TestFetchMbox(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"archive.mbox": mbox})
+	s := Open(root, filepath.Join(root, "Done"))
+	messages, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("List() returned %d messages, want 3", len(messages))
+	}
+
+	second := messages[1]
+	if err := s.Fetch(second); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	attachments, err := second.Attachments()
+	if err != nil {
+		t.Fatalf("Attachments: %v", err)
+	}
+	want := "From the escaped line\n>From the doubly escaped line\nlast line\n"
+	if len(attachments) != 1 || string(attachments[0].Content) != want {
+		t.Errorf("Attachments() = %v, want letter.txt containing %q", attachments, want)
+	}
+
+	if err := s.Fetch(&message.Message{ID: "archive.mbox#4"}); err == nil {
+		t.Error("Fetch() of an unknown message succeeded")
+	}
+}
+
+func TestClose(t *testing.T) {
+	root := t.TempDir()
+	done := filepath.Join(root, "Done")
+	writeFiles(t, root, map[string]string{
+		"book.eml":          "Subject: Book\n\nbody",
+		"deleted.eml":       "Subject: Deleted\n\nbody",
+		"archive.mbox":      mbox,
+		"Done/book.eml":     "Subject: Earlier book\n\nbody",
+		"Maildir/new/1.eml": "Subject: New\n\nbody",
+	})
+	s := Open(root, done)
+	messages, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, msg := range messages {
+		// One message of the mbox file is left, so the file stays in place
+		if msg.ID == "archive.mbox#3" {
+			continue
+		}
+		if err := s.MarkDone(msg); err != nil {
+			t.Fatalf("MarkDone(%s): %v", msg.ID, err)
+		}
+		if msg.ID == "deleted.eml" {
+			if err := s.Delete(msg); err != nil {
+				t.Fatalf("Delete(%s): %v", msg.ID, err)
+			}
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for path, want := range map[string]bool{
+		"book.eml":               false,
+		"Done/book.eml":          true,
+		"Done/book-2.eml":        true,
+		"deleted.eml":            false,
+		"Done/deleted.eml":       false,
+		"archive.mbox":           true,
+		"Maildir/new/1.eml":      false,
+		"Done/Maildir/new/1.eml": true,
+	} {
+		if exists(filepath.Join(root, path)) != want {
+			t.Errorf("%s exists = %v, want %v", path, !want, want)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"book.eml": "Subject: Book\n\nbody"})
+	s := Open(root, filepath.Join(root, "Done"))
+	s.ReadOnly = true
+	messages, err := s.List()
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("List() = %d messages, %v", len(messages), err)
+	}
+	if err := s.MarkDone(messages[0]); err == nil {
+		t.Error("MarkDone() succeeded on a read-only source")
+	}
+	if err := s.Delete(messages[0]); err == nil {
+		t.Error("Delete() succeeded on a read-only source")
+	}
+	if err := s.Close(); err != nil || !exists(filepath.Join(root, "book.eml")) {
+		t.Errorf("Close() on a read-only source = %v, moved the file", err)
+	}
+}
+
+func TestDoneAcrossSessions(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"archive.mbox": mbox})
+
+	// The second message fails, so the file stays in place
+	s := Open(root, filepath.Join(root, "Done"))
+	messages, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, msg := range messages {
+		if msg.ID != "archive.mbox#2" {
+			s.MarkDone(msg)
+			s.Delete(msg)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	done := s.DoneIDs()
+	if strings.Join(done, " ") != "archive.mbox#1 archive.mbox#3" || !exists(filepath.Join(root, "archive.mbox")) {
+		t.Fatalf("DoneIDs() after the first session = %v, want the handled messages of the file still in place", done)
+	}
+
+	// The next session only lists the failed message, handling it removes the file
+	s = Open(root, filepath.Join(root, "Done"))
+	for _, id := range append(done, "gone.eml") {
+		s.Done[id] = true
+	}
+	messages, err = s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ID != "archive.mbox#2" {
+		t.Fatalf("List() returned %d messages, want only the failed one", len(messages))
+	}
+	s.MarkDone(messages[0])
+	s.Delete(messages[0])
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if exists(filepath.Join(root, "archive.mbox")) || exists(filepath.Join(root, "Done", "archive.mbox")) {
+		t.Error("archive.mbox was not deleted once all its messages were handled")
+	}
+	if done := s.DoneIDs(); len(done) != 0 {
+		t.Errorf("DoneIDs() after the file was deleted = %v, want none", done)
+	}
+}
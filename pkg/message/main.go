@@ -0,0 +1,156 @@
+// Package message implements reading emails and their attachments, it is shared by all mail sources
+package message
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+	"go.uber.org/zap"
+)
+
+// Message is an email collected from a mail source.
+// The headers are available after listing, Attachments can only be read after the source fetched the full email.
+type Message struct {
+	// ID identifies the email within its source, like the IMAP UID or the POP3 UIDL
+	ID         string
+	Date       time.Time
+	Sender     string
+	Recipients []string
+	Subject    string
+	// Seen is set when the email was already flagged as seen when it was listed
+	Seen bool
+
+	messageReader *mail.Reader
+	// attachments are set by sources that read the attachments without parsing the email, like JMAP
+	attachments []Attachment
+	fetched     bool
+}
+
+// Parse reads the headers of the email and prepares reading its attachments.
+// Missing or malformed headers are left empty.
+func (msg *Message) Parse(r io.Reader) error {
+	msgReader, err := mail.CreateReader(r)
+	if err != nil {
+		return err
+	}
+	msg.messageReader = msgReader
+
+	messageDate, _ := msgReader.Header.Date()
+	messageSender, _ := msgReader.Header.AddressList("from")
+	messageRecipients, _ := msgReader.Header.AddressList("to")
+	messageCc, _ := msgReader.Header.AddressList("cc")
+	messageSubject, _ := msgReader.Header.Subject()
+
+	if !messageDate.IsZero() {
+		msg.Date = messageDate
+	}
+	if len(messageSender) > 0 {
+		msg.Sender = messageSender[0].Address
+	}
+	if messageSubject != "" {
+		msg.Subject = messageSubject
+	}
+	if len(messageRecipients)+len(messageCc) > 0 {
+		msg.Recipients = nil
+		for _, recipient := range append(messageRecipients, messageCc...) {
+			msg.Recipients = append(msg.Recipients, recipient.Address)
+		}
+	}
+	return nil
+}
+
+// SetAttachments sets the attachments of the message, for sources that do not parse the email themselves
+func (msg *Message) SetAttachments(attachments []Attachment) {
+	msg.attachments = attachments
+	msg.fetched = true
+}
+
+// Attachment is a file attached to a message
+type Attachment struct {
+	Filename  string
+	Extension string
+	MIMEType  string
+	Content   []byte
+
+	// size and download are set for attachments that are only downloaded when their content is needed
+	size     int
+	download func() (io.ReadCloser, error)
+}
+
+// NewRemoteAttachment returns an attachment that is only downloaded when its content is needed,
+// so attachments that are skipped are never downloaded. The content is streamed from the reader download returns.
+func NewRemoteAttachment(filename string, mimeType string, size int, download func() (io.ReadCloser, error)) Attachment {
+	return Attachment{
+		Filename:  filename,
+		Extension: strings.Trim(filepath.Ext(filename), "."),
+		MIMEType:  mimeType,
+		size:      size,
+		download:  download,
+	}
+}
+
+// Size returns the size of the attachment in bytes
+func (a Attachment) Size() int {
+	if a.download != nil {
+		return a.size
+	}
+	return len(a.Content)
+}
+
+// Open returns a reader for the content of the attachment, remote attachments are downloaded while it is read
+func (a Attachment) Open() (io.ReadCloser, error) {
+	if a.download != nil {
+		return a.download()
+	}
+	return io.NopCloser(bytes.NewReader(a.Content)), nil
+}
+
+// Attachments reads all attachments of the message
+func (msg *Message) Attachments() ([]Attachment, error) {
+	logger := zap.S()
+	if msg.fetched {
+		return msg.attachments, nil
+	}
+	if msg.messageReader == nil {
+		return nil, fmt.Errorf("message %s was not fetched", msg.ID)
+	}
+	msgReader := msg.messageReader
+
+	var attachments []Attachment
+
+	// Process each message part, there might be multiple attachments
+	for {
+		p, err := msgReader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch h := p.Header.(type) {
+		// This is an attachment
+		case *mail.AttachmentHeader:
+			attachmentFileName, _ := h.Filename()
+			attachmentMIMEType, _, _ := h.ContentType()
+			logger.Debugw("Reading attachment", zap.String("filename", attachmentFileName))
+
+			attachmentContent, err := io.ReadAll(p.Body)
+			if err != nil {
+				return nil, err
+			}
+			attachments = append(attachments, Attachment{
+				Filename:  attachmentFileName,
+				Extension: strings.Trim(filepath.Ext(attachmentFileName), "."),
+				MIMEType:  attachmentMIMEType,
+				Content:   attachmentContent,
+			})
+		}
+	}
+
+	return attachments, nil
+}
@@ -9,7 +9,9 @@ import (
 )
 
 const nickelMenuPath = "/mnt/onboard/.adds/nm"
-const nickelMenuConfigPath = nickelMenuPath + "/kobomail"
+
+// ConfigFilePath is the location of the KoboMail NickelMenu configuration file
+const ConfigFilePath = nickelMenuPath + "/kobomail"
 
 const configTemplate = "menu_item:main:KoboMail:cmd_spawn:quiet:exec usr/local/kobomail/kobomail_launcher.sh manual"
 
@@ -21,10 +23,10 @@ func IsInstalled() (installed bool) {
 // ConfigFileFound determines if a NickelMenu configuration file is present
 func ConfigFileFound() (installed bool) {
 	logger := zap.S()
-	configPresent := helpers.FileExists(nickelMenuConfigPath)
+	configPresent := helpers.FileExists(ConfigFilePath)
 	logger.Debugw(
 		"Checking if NickelMenu configuration file is present",
-		zap.String("file", nickelMenuConfigPath),
+		zap.String("file", ConfigFilePath),
 		zap.Bool("found", configPresent),
 	)
 	return configPresent
@@ -35,9 +37,9 @@ func DeployConfigFile() (ok bool, err error) {
 	logger := zap.S()
 	logger.Debugw(
 		"Writing NickelMenu configuration file",
-		zap.String("file", nickelMenuConfigPath),
+		zap.String("file", ConfigFilePath),
 	)
-	err = os.WriteFile(nickelMenuConfigPath, []byte(configTemplate+"\n"), 0644)
+	err = os.WriteFile(ConfigFilePath, []byte(configTemplate+"\n"), 0644)
 	if err != nil {
 		return false, err
 	}
@@ -50,10 +52,10 @@ func DeleteConfigFile() (ok bool, err error) {
 	logger := zap.S()
 	logger.Debugw(
 		"Removing NickelMenu configuration file",
-		zap.String("file", nickelMenuConfigPath),
+		zap.String("file", ConfigFilePath),
 	)
 	if ConfigFileFound() {
-		_, err = helpers.DeleteFile(nickelMenuConfigPath)
+		_, err = helpers.DeleteFile(ConfigFilePath)
 		if err != nil {
 			return false, err
 		}
@@ -6,11 +6,12 @@ import (
 	"go.uber.org/zap"
 )
 
-const nickelSeriesPath = "/usr/local/Kobo/imageformats/libns.so"
+// BinaryPath is the location of the NickelSeries binary
+const BinaryPath = "/usr/local/Kobo/imageformats/libns.so"
 
 // IsInstalled determines if NickelSeries is installed
 func IsInstalled() (installed bool) {
-	return helpers.FileExists(nickelSeriesPath)
+	return helpers.FileExists(BinaryPath)
 }
 
 // Uninstall delete the NickelSeries binary if present
@@ -18,10 +19,10 @@ func Uninstall() (ok bool, err error) {
 	logger := zap.S()
 	logger.Debugw(
 		"Removing NickelSeries binary file",
-		zap.String("file", nickelSeriesPath),
+		zap.String("file", BinaryPath),
 	)
 	if IsInstalled() {
-		_, err = helpers.DeleteFile(nickelSeriesPath)
+		_, err = helpers.DeleteFile(BinaryPath)
 		if err != nil {
 			return false, err
 		}
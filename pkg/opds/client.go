@@ -0,0 +1,240 @@
+// Package opds implements reading OPDS catalogs, like the ones served by Calibre-Web, Kavita and Komga
+package opds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/pkg/helpers"
+)
+
+const requestTimeout = 60 * time.Second
+
+// maxPages limits the number of pages read of a paginated feed
+const maxPages = 20
+
+// Link relations used by OPDS catalogs
+const (
+	RelAcquisition           = "http://opds-spec.org/acquisition"
+	RelAcquisitionOpenAccess = "http://opds-spec.org/acquisition/open-access"
+	RelNext                  = "next"
+	RelSubsection            = "subsection"
+)
+
+// extensions maps the MIME types of acquisition links to the filetype of the downloaded file
+var extensions = map[string]string{
+	"application/epub+zip":           "epub",
+	"application/kepub+zip":          "kepub",
+	"application/x-kobo-epub+zip":    "kepub",
+	"application/pdf":                "pdf",
+	"application/x-mobipocket-ebook": "mobi",
+	"application/x-mobi8-ebook":      "mobi",
+	"application/x-cbz":              "cbz",
+	"application/vnd.comicbook+zip":  "cbz",
+	"application/x-cbr":              "cbr",
+	"application/vnd.comicbook-rar":  "cbr",
+	"text/plain":                     "txt",
+	"application/rtf":                "rtf",
+}
+
+// Link is a link of a feed or an entry, relative links are resolved when the feed is fetched
+type Link struct {
+	Rel   string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr"`
+	// Length is the size of the file in bytes, when the catalog provides it
+	Length int `xml:"length,attr"`
+}
+
+// Extension returns the filetype of the file the link points to, or an empty string when it is not known
+func (l Link) Extension() string {
+	mimeType, _, _ := strings.Cut(l.Type, ";")
+	return extensions[strings.TrimSpace(mimeType)]
+}
+
+// Entry is a publication or a navigation entry of a feed
+type Entry struct {
+	ID      string `xml:"id"`
+	Title   string `xml:"title"`
+	Authors []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Links []Link `xml:"link"`
+}
+
+// Author returns the name of the first author of the entry
+func (e Entry) Author() string {
+	if len(e.Authors) == 0 {
+		return ""
+	}
+	return e.Authors[0].Name
+}
+
+// Acquisitions returns the links to download the publication
+func (e Entry) Acquisitions() []Link {
+	var links []Link
+	for _, link := range e.Links {
+		if link.Rel == RelAcquisition || link.Rel == RelAcquisitionOpenAccess {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// Feed returns the link to the feed a navigation entry points to
+func (e Entry) Feed() (Link, bool) {
+	for _, link := range e.Links {
+		if strings.HasPrefix(link.Type, "application/atom+xml") && link.Rel != RelAcquisition && link.Rel != RelAcquisitionOpenAccess {
+			return link, true
+		}
+	}
+	return Link{}, false
+}
+
+// Feed is an OPDS catalog feed
+type Feed struct {
+	Title   string  `xml:"title"`
+	Links   []Link  `xml:"link"`
+	Entries []Entry `xml:"entry"`
+}
+
+// Link returns the first link of the feed with the given relation
+func (f Feed) Link(rel string) (Link, bool) {
+	for _, link := range f.Links {
+		if link.Rel == rel {
+			return link, true
+		}
+	}
+	return Link{}, false
+}
+
+// Client is a simple implementation of an OPDS client
+type Client struct {
+	http     *http.Client
+	user     string
+	password string
+}
+
+// New returns a client authenticating with user and password, leave user empty for public catalogs
+func New(user string, password string) *Client {
+	return &Client{http: helpers.NewHTTPClient(requestTimeout, ""), user: user, password: password}
+}
+
+// get sends a GET request with the credentials
+func (c *Client) get(target string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("authentication failed")
+		}
+		return nil, fmt.Errorf("GET %s: server returned %s", req.URL.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// Fetch returns the feed at feedURL, all links in the feed are made absolute
+func (c *Client) Fetch(feedURL string) (*Feed, error) {
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.get(feedURL, "application/atom+xml")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var feed Feed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("invalid OPDS feed: %w", err)
+	}
+
+	resolve := func(links []Link) {
+		for i, link := range links {
+			if href, err := base.Parse(link.Href); err == nil {
+				links[i].Href = href.String()
+			}
+		}
+	}
+	resolve(feed.Links)
+	for _, entry := range feed.Entries {
+		resolve(entry.Links)
+	}
+	return &feed, nil
+}
+
+// Download returns a reader for the content of the file the link points to, the caller must close it
+func (c *Client) Download(link Link) (io.ReadCloser, error) {
+	resp, err := c.get(link.Href, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Entries returns the entries of the feed at feedURL, the next pages of a paginated feed are read as well
+func (c *Client) Entries(feedURL string) ([]Entry, error) {
+	var entries []Entry
+	for page := 0; page < maxPages && feedURL != ""; page++ {
+		feed, err := c.Fetch(feedURL)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, feed.Entries...)
+		next, _ := feed.Link(RelNext)
+		feedURL = next.Href
+	}
+	return entries, nil
+}
+
+// Shelf returns the URL of a shelf in the catalog at feedURL.
+// The shelf is either a URL, relative to feedURL, or the titles of the navigation entries leading to it separated by a slash,
+// like "Shelves/To Read". Titles are compared case-insensitively.
+func (c *Client) Shelf(feedURL string, shelf string) (string, error) {
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return "", err
+	}
+	if u, err := url.Parse(shelf); err == nil && (u.Scheme != "" || strings.HasPrefix(shelf, "/")) {
+		return base.ResolveReference(u).String(), nil
+	}
+
+	shelfURL := feedURL
+	for _, title := range strings.Split(shelf, "/") {
+		entries, err := c.Entries(shelfURL)
+		if err != nil {
+			return "", err
+		}
+		shelfURL = ""
+		for _, entry := range entries {
+			if link, ok := entry.Feed(); ok && strings.EqualFold(strings.TrimSpace(entry.Title), strings.TrimSpace(title)) {
+				shelfURL = link.Href
+				break
+			}
+		}
+		if shelfURL == "" {
+			return "", fmt.Errorf("the catalog has no shelf %q", title)
+		}
+	}
+	return shelfURL, nil
+}
@@ -0,0 +1,248 @@
+// Package pop3 implements all POP3 interactions of KoboMail
+package pop3
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/pkg/message"
+)
+
+// Security is the way the connection to the POP3 server is secured
+type Security string
+
+// Security values
+const (
+	// SecurityTLS connects over TLS right away, usually on port 995
+	SecurityTLS Security = "tls"
+	// SecuritySTARTTLS connects in plain text and upgrades the connection with STLS, usually on port 110
+	SecuritySTARTTLS Security = "starttls"
+)
+
+const dialTimeout = 30 * time.Second
+
+// Client is a simple implementation of a POP3 connection
+type Client struct {
+	text *textproto.Conn
+
+	// ReadOnly makes sure the maildrop is not modified, messages are not deleted
+	ReadOnly bool
+	// Done holds the UIDLs of the messages that were already processed, they are not listed again.
+	// After List it only contains the UIDLs still present on the server.
+	Done map[string]bool
+
+	// numbers maps the UIDL of every message in the maildrop to its message number
+	numbers map[string]int
+}
+
+// Dial connects to the POP3 server and secures the connection
+func Dial(host string, port int, security Security) (*Client, error) {
+	addr := fmt.Sprintf("%s:%v", host, port)
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var conn net.Conn
+	var err error
+	if security == SecuritySTARTTLS {
+		conn, err = net.DialTimeout("tcp", addr, dialTimeout)
+	} else {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, tlsConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{text: textproto.NewConn(conn), Done: map[string]bool{}}
+	if _, err := c.response(); err != nil {
+		c.text.Close()
+		return nil, err
+	}
+
+	if security == SecuritySTARTTLS {
+		if _, err := c.cmd("STLS"); err != nil {
+			c.text.Close()
+			return nil, fmt.Errorf("server does not support STARTTLS: %w", err)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.text = textproto.NewConn(tlsConn)
+	}
+	return c, nil
+}
+
+// response reads a single line response, returning the text after +OK
+func (c *Client) response() (string, error) {
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "+OK") {
+		return strings.TrimSpace(strings.TrimPrefix(line, "+OK")), nil
+	}
+	return "", fmt.Errorf("%s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+}
+
+// cmd sends a command and reads its single line response
+func (c *Client) cmd(format string, args ...interface{}) (string, error) {
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.response()
+}
+
+// multiline sends a command and reads its multi-line response, the returned reader must be read completely
+func (c *Client) multiline(format string, args ...interface{}) (*textproto.Reader, error) {
+	if _, err := c.cmd(format, args...); err != nil {
+		return nil, err
+	}
+	return &c.text.Reader, nil
+}
+
+// Login authenticates with the user name and password
+func (c *Client) Login(username string, password string) error {
+	if _, err := c.cmd("USER %s", username); err != nil {
+		return err
+	}
+	_, err := c.cmd("PASS %s", password)
+	return err
+}
+
+// Quit ends the session, messages deleted during the session are only removed from the server now
+func (c *Client) Quit() error {
+	defer c.text.Close()
+	_, err := c.cmd("QUIT")
+	return err
+}
+
+// uidls returns the UIDL of every message in the maildrop mapped to its message number
+func (c *Client) uidls() (map[string]int, error) {
+	r, err := c.multiline("UIDL")
+	if err != nil {
+		return nil, fmt.Errorf("server does not support UIDL: %w", err)
+	}
+	lines, err := r.ReadDotLines()
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := map[string]int{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid UIDL response %q", line)
+		}
+		number, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid UIDL response %q", line)
+		}
+		numbers[fields[1]] = number
+	}
+	return numbers, nil
+}
+
+// List returns the messages that are not done yet, only their headers are downloaded.
+// Use Fetch to download a message.
+func (c *Client) List() ([]*message.Message, error) {
+	numbers, err := c.uidls()
+	if err != nil {
+		return nil, err
+	}
+	c.numbers = numbers
+
+	// Forget processed messages that were removed from the server
+	for uidl := range c.Done {
+		if _, ok := numbers[uidl]; !ok {
+			delete(c.Done, uidl)
+		}
+	}
+
+	var listed []*message.Message
+	for uidl, number := range numbers {
+		if c.Done[uidl] {
+			continue
+		}
+		r, err := c.multiline("TOP %d 0", number)
+		if err != nil {
+			return nil, err
+		}
+		header, err := r.ReadDotBytes()
+		if err != nil {
+			return nil, err
+		}
+		msg := &message.Message{ID: uidl}
+		if err := msg.Parse(bytes.NewReader(header)); err != nil {
+			return nil, fmt.Errorf("failed to read headers of message %s: %w", uidl, err)
+		}
+		listed = append(listed, msg)
+	}
+
+	sort.Slice(listed, func(i, j int) bool {
+		return numbers[listed[i].ID] < numbers[listed[j].ID]
+	})
+	return listed, nil
+}
+
+// number returns the message number of a listed message
+func (c *Client) number(msg *message.Message) (int, error) {
+	number, ok := c.numbers[msg.ID]
+	if !ok {
+		return 0, fmt.Errorf("message %s is not in the maildrop", msg.ID)
+	}
+	return number, nil
+}
+
+// Fetch downloads the full message
+func (c *Client) Fetch(msg *message.Message) error {
+	number, err := c.number(msg)
+	if err != nil {
+		return err
+	}
+	r, err := c.multiline("RETR %d", number)
+	if err != nil {
+		return err
+	}
+	// The whole message is read, so the connection can be used while the attachments are processed
+	body, err := r.ReadDotBytes()
+	if err != nil {
+		return err
+	}
+	return msg.Parse(bytes.NewReader(body))
+}
+
+// MarkDone records the message as processed, so it is not listed again
+func (c *Client) MarkDone(msg *message.Message) error {
+	c.Done[msg.ID] = true
+	return nil
+}
+
+// Delete marks the message for deletion, it is removed from the server when the session ends with Quit
+func (c *Client) Delete(msg *message.Message) error {
+	if c.ReadOnly {
+		return fmt.Errorf("cannot delete message on a read-only connection")
+	}
+	number, err := c.number(msg)
+	if err != nil {
+		return err
+	}
+	_, err = c.cmd("DELE %d", number)
+	return err
+}
+
+// DoneUIDLs returns the UIDLs of the processed messages that are still on the server
+func (c *Client) DoneUIDLs() []string {
+	uidls := make([]string, 0, len(c.Done))
+	for uidl := range c.Done {
+		uidls = append(uidls, uidl)
+	}
+	sort.Strings(uidls)
+	return uidls
+}
@@ -0,0 +1,201 @@
+package pop3
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const bookMessage = "From: Alice <alice@example.org>\r\n" +
+	"To: kobo+kobo@example.org\r\n" +
+	"Subject: A book\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	".A line starting with a dot\r\n" +
+	"--b\r\n" +
+	"Content-Type: application/epub+zip\r\n" +
+	"Content-Disposition: attachment; filename=dune.epub\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"UEsDBA==\r\n" +
+	"--b--\r\n"
+
+const noteMessage = "From: bob@example.com\r\n" +
+	"To: kobo@example.org\r\n" +
+	"Subject: A note\r\n" +
+	"\r\n" +
+	"No attachments\r\n"
+
+// fakeServer answers the POP3 commands of a client on the other end of a pipe
+type fakeServer struct {
+	messages []string
+	uidls    []string
+	commands []string
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	text := textproto.NewConn(conn)
+	defer text.Close()
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		s.commands = append(s.commands, line)
+		fields := strings.Fields(line)
+		number := 0
+		if len(fields) > 1 {
+			number, _ = strconv.Atoi(fields[1])
+		}
+		if number < 0 || number > len(s.messages) {
+			text.PrintfLine("-ERR no such message")
+			continue
+		}
+		switch fields[0] {
+		case "USER":
+			text.PrintfLine("+OK")
+		case "PASS":
+			if fields[1] != "secret" {
+				text.PrintfLine("-ERR invalid password")
+				continue
+			}
+			text.PrintfLine("+OK logged in")
+		case "UIDL":
+			text.PrintfLine("+OK")
+			w := text.DotWriter()
+			for i, uidl := range s.uidls {
+				fmt.Fprintf(w, "%d %s\r\n", i+1, uidl)
+			}
+			w.Close()
+		case "TOP", "RETR":
+			content := s.messages[number-1]
+			if fields[0] == "TOP" {
+				content = content[:strings.Index(content, "\r\n\r\n")+4]
+			}
+			text.PrintfLine("+OK")
+			w := text.DotWriter()
+			w.Write([]byte(content))
+			w.Close()
+		case "DELE":
+			text.PrintfLine("+OK deleted")
+		case "QUIT":
+			text.PrintfLine("+OK bye")
+			return
+		default:
+			text.PrintfLine("-ERR unknown command")
+		}
+	}
+}
+
+func newTestClient(server *fakeServer) (*Client, <-chan struct{}) {
+	clientConn, serverConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.serve(serverConn)
+		close(done)
+	}()
+	return &Client{text: textproto.NewConn(clientConn), Done: map[string]bool{}}, done
+}
+
+func TestClient(t *testing.T) {
+	server := &fakeServer{messages: []string{bookMessage, noteMessage}, uidls: []string{"uid-book", "uid-note"}}
+	c, done := newTestClient(server)
+
+	if err := c.Login("kobo@example.org", "secret"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	c.Done["uid-gone"] = true
+	messages, err := c.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(messages) != 2 || messages[0].ID != "uid-book" || messages[1].ID != "uid-note" {
+		t.Fatalf("List() returned %d messages, want the book and the note in maildrop order", len(messages))
+	}
+	if c.Done["uid-gone"] {
+		t.Error("List() kept a processed message that is no longer on the server")
+	}
+	book := messages[0]
+	if book.Subject != "A book" || book.Sender != "alice@example.org" || len(book.Recipients) != 1 || book.Recipients[0] != "kobo+kobo@example.org" {
+		t.Errorf("List() headers = %q from %q to %v", book.Subject, book.Sender, book.Recipients)
+	}
+
+	if err := c.Fetch(book); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	attachments, err := book.Attachments()
+	if err != nil {
+		t.Fatalf("Attachments: %v", err)
+	}
+	if len(attachments) != 1 || attachments[0].Filename != "dune.epub" || string(attachments[0].Content) != "PK\x03\x04" {
+		t.Errorf("Attachments() = %v, want dune.epub", attachments)
+	}
+
+	if err := c.MarkDone(book); err != nil {
+		t.Fatalf("MarkDone: %v", err)
+	}
+	if err := c.Delete(book); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatalf("Quit: %v", err)
+	}
+	<-done
+
+	want := []string{"USER kobo@example.org", "PASS secret", "UIDL", "TOP 1 0", "TOP 2 0", "RETR 1", "DELE 1", "QUIT"}
+	if strings.Join(server.commands, "|") != strings.Join(want, "|") {
+		t.Errorf("commands = %q, want %q", server.commands, want)
+	}
+	if uidls := c.DoneUIDLs(); len(uidls) != 1 || uidls[0] != "uid-book" {
+		t.Errorf("DoneUIDLs() = %v, want uid-book", uidls)
+	}
+}
+
+func TestClientSkipsDoneMessages(t *testing.T) {
+	server := &fakeServer{messages: []string{bookMessage, noteMessage}, uidls: []string{"uid-book", "uid-note"}}
+	c, done := newTestClient(server)
+	c.ReadOnly = true
+	c.Done["uid-book"] = true
+
+	messages, err := c.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ID != "uid-note" {
+		t.Fatalf("List() returned %d messages, want only the note", len(messages))
+	}
+	if err := c.Delete(messages[0]); err == nil {
+		t.Error("Delete() succeeded on a read-only connection")
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatalf("Quit: %v", err)
+	}
+	<-done
+
+	for _, command := range server.commands {
+		if strings.HasPrefix(command, "TOP 1") || strings.HasPrefix(command, "DELE") {
+			t.Errorf("client sent %q", command)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := &fakeServer{}
+	c, done := newTestClient(server)
+
+	if err := c.Login("kobo@example.org", "wrong"); err == nil || err.Error() != "invalid password" {
+		t.Errorf("Login() with a wrong password = %v, want the server error", err)
+	}
+	if _, err := c.cmd("NOOP"); err == nil {
+		t.Error("unknown command succeeded")
+	}
+	c.Quit()
+	<-done
+}
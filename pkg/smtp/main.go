@@ -0,0 +1,100 @@
+// Package smtp implements all SMTP interactions of KoboMail
+package smtp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// Connection is a simple implementation of an SMTP connection
+type Connection struct {
+	host   string
+	port   int
+	client *smtp.Client
+}
+
+func (sc *Connection) connect() error {
+	connStr := fmt.Sprintf("%s:%v", sc.host, sc.port)
+	tlsc := &tls.Config{ServerName: sc.host}
+
+	// Port 465 expects TLS from the start, every other port is upgraded with STARTTLS
+	var conn net.Conn
+	var err error
+	if sc.port == 465 {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", connStr, tlsc)
+	} else {
+		conn, err = net.DialTimeout("tcp", connStr, 30*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, sc.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	if sc.port != 465 {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return fmt.Errorf("server %s does not support STARTTLS", connStr)
+		}
+		if err := c.StartTLS(tlsc); err != nil {
+			c.Close()
+			return err
+		}
+	}
+
+	sc.client = c
+	return nil
+}
+
+// ConnectToServer instantiates a new connection to an SMTP server
+func ConnectToServer(host string, port int) (*Connection, error) {
+	connection := Connection{
+		host: host,
+		port: port,
+	}
+
+	err := connection.connect()
+	if err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+// Login authenticates to the server using PLAIN authentication
+func (sc *Connection) Login(username string, password string) error {
+	return sc.client.Auth(smtp.PlainAuth("", username, password, sc.host))
+}
+
+// Send delivers a fully formatted message to the given recipients
+func (sc *Connection) Send(from string, to []string, msg []byte) error {
+	if err := sc.client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := sc.client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := sc.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Logout gracefully closes the connection.
+func (sc *Connection) Logout() error {
+	return sc.client.Quit()
+}
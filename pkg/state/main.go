@@ -0,0 +1,67 @@
+// Package state implements a small persistent key/value store for KoboMail
+package state
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/bjw-s/kobomail/pkg/helpers"
+	"go.uber.org/zap"
+)
+
+// Store keeps state between KoboMail runs in a JSON file
+type Store struct {
+	path string
+	data map[string]json.RawMessage
+}
+
+// Load reads the state file, a missing file results in an empty store
+func Load(path string) (*Store, error) {
+	logger := zap.S()
+	store := &Store{
+		path: path,
+		data: map[string]json.RawMessage{},
+	}
+
+	if !helpers.FileExists(path) {
+		logger.Debugw("State file not found, starting with empty state", zap.String("file", path))
+		return store, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &store.data); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Get decodes the value stored under key into v and returns if the key was found
+func (s *Store) Get(key string, v interface{}) (bool, error) {
+	raw, ok := s.data[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Set stores v under key, call Save to persist the change
+func (s *Store) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.data[key] = raw
+	return nil
+}
+
+// Save writes the store back to disk
+func (s *Store) Save() error {
+	content, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, content, 0644)
+}
@@ -9,33 +9,43 @@ import (
 )
 
 var kobomailRules = udevRules{
-	`KERNEL=="eth*", ACTION=="add", RUN+="/usr/local/kobomail/kobomail_launcher.sh`,
+	`KERNEL=="eth*", ACTION=="add", RUN+="/usr/local/kobomail/kobomail_launcher.sh"`,
 	`KERNEL=="wlan*", ACTION=="add", RUN+="/usr/local/kobomail/kobomail_launcher.sh"`,
-	`KERNEL=="lo", RUN+="/usr/local/kobomail/kobomail_config_setup.sh"`,
+	`KERNEL=="lo", RUN+="/usr/local/kobomail/kobomail init"`,
 }
 
-const udevRulesFilePath = "/etc/udev/rules.d/97-kobomail.rules"
+// RulesFilePath is the location of the KoboMail udev rules file
+const RulesFilePath = "/etc/udev/rules.d/97-kobomail.rules"
 
 // RulesFileFound checks if udev rules file is present
 func RulesFileFound() (installed bool) {
 	logger := zap.S()
-	rulesPresent := helpers.FileExists(udevRulesFilePath)
+	rulesPresent := helpers.FileExists(RulesFilePath)
 	logger.Debugw(
 		"Checking if udev rules file is present",
-		zap.String("file", udevRulesFilePath),
+		zap.String("file", RulesFilePath),
 		zap.Bool("found", rulesPresent),
 	)
 	return rulesPresent
 }
 
+// RulesFileCurrent checks if the udev rules file on the device matches the rules of this KoboMail version
+func RulesFileCurrent() (current bool, err error) {
+	content, err := os.ReadFile(RulesFilePath)
+	if err != nil {
+		return false, err
+	}
+	return string(content) == kobomailRules.generateFile(), nil
+}
+
 // DeployRulesFile deploys the udev rulesfile at the correct place so KoboMail runs automatically everytime WIfi is activated
 func DeployRulesFile() (ok bool, err error) {
 	logger := zap.S()
 	logger.Debugw(
 		"Writing udev rules file",
-		zap.String("file", udevRulesFilePath),
+		zap.String("file", RulesFilePath),
 	)
-	err = os.WriteFile(udevRulesFilePath, []byte(kobomailRules.generateFile()), 0644)
+	err = os.WriteFile(RulesFilePath, []byte(kobomailRules.generateFile()), 0644)
 	if err != nil {
 		return false, err
 	}
@@ -47,10 +57,10 @@ func DeleteUdevRulesFile() (ok bool, err error) {
 	logger := zap.S()
 	logger.Debugw(
 		"Removing udev rules file",
-		zap.String("file", udevRulesFilePath),
+		zap.String("file", RulesFilePath),
 	)
 	if RulesFileFound() {
-		_, err = helpers.DeleteFile(udevRulesFilePath)
+		_, err = helpers.DeleteFile(RulesFilePath)
 		if err != nil {
 			return false, err
 		}
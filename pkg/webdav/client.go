@@ -0,0 +1,224 @@
+// Package webdav implements all WebDAV interactions of KoboMail, like listing and downloading files of a Nextcloud folder
+package webdav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bjw-s/kobomail/pkg/helpers"
+)
+
+const requestTimeout = 60 * time.Second
+
+// propfindBody requests the properties needed to detect changed files
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getetag/>
+    <d:getlastmodified/>
+    <d:getcontentlength/>
+    <d:getcontenttype/>
+  </d:prop>
+</d:propfind>`
+
+// File is a file in the remote collection
+type File struct {
+	// Href is the path of the file on the server
+	Href        string
+	Name        string
+	ETag        string
+	Modified    string
+	Size        int
+	ContentType string
+}
+
+// Version identifies the content of the file, it changes when the file changes.
+// Servers without ETags are supported by using the modification time and size instead.
+func (f File) Version() string {
+	if f.ETag != "" {
+		return f.ETag
+	}
+	return f.Modified + "/" + strconv.Itoa(f.Size)
+}
+
+// Client is a simple implementation of a WebDAV client for a single collection
+type Client struct {
+	http       *http.Client
+	collection *url.URL
+	user       string
+	password   string
+}
+
+// New returns a client for the collection at collectionURL
+func New(collectionURL string, user string, password string) (*Client, error) {
+	collection, err := url.Parse(collectionURL)
+	if err != nil {
+		return nil, err
+	}
+	// A collection URL ends with a slash, relative paths are resolved against it
+	if !strings.HasSuffix(collection.Path, "/") {
+		collection.Path += "/"
+	}
+	return &Client{
+		http:       helpers.NewHTTPClient(requestTimeout, ""),
+		collection: collection,
+		user:       user,
+		password:   password,
+	}, nil
+}
+
+// do sends the request with the credentials and returns the response when its status is one of expected
+func (c *Client) do(req *http.Request, expected ...int) (*http.Response, error) {
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("authentication failed")
+	}
+	return nil, fmt.Errorf("%s %s: server returned %s", req.Method, req.URL.Path, resp.Status)
+}
+
+// fileURL returns the URL of a file on the server
+func (c *Client) fileURL(f File) string {
+	u := *c.collection
+	u.Path = f.Href
+	u.RawPath = ""
+	return u.String()
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ETag          string `xml:"getetag"`
+				LastModified  string `xml:"getlastmodified"`
+				ContentLength string `xml:"getcontentlength"`
+				ContentType   string `xml:"getcontenttype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// List returns the files in the collection, sub collections are not listed
+func (c *Client) List() ([]File, error) {
+	req, err := http.NewRequest("PROPFIND", c.collection.String(), strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	resp, err := c.do(req, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid PROPFIND response: %w", err)
+	}
+
+	var files []File
+	for _, response := range result.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, fmt.Errorf("invalid href %q: %w", response.Href, err)
+		}
+		for _, propstat := range response.Propstat {
+			prop := propstat.Prop
+			if !strings.Contains(propstat.Status, " 200 ") || prop.ResourceType.Collection != nil {
+				continue
+			}
+			size, _ := strconv.Atoi(prop.ContentLength)
+			files = append(files, File{
+				Href:        href.Path,
+				Name:        path.Base(href.Path),
+				ETag:        strings.Trim(prop.ETag, `"`),
+				Modified:    prop.LastModified,
+				Size:        size,
+				ContentType: prop.ContentType,
+			})
+		}
+	}
+	return files, nil
+}
+
+// Download returns a reader for the content of the file, the caller must close it
+func (c *Client) Download(f File) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, c.fileURL(f), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the file from the server
+func (c *Client) Delete(f File) error {
+	req, err := http.NewRequest(http.MethodDelete, c.fileURL(f), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Move moves the file to a folder relative to the collection, the folder is created when it does not exist
+func (c *Client) Move(f File, folder string) error {
+	folderURL := c.collection.JoinPath(folder)
+	folderURL.Path += "/"
+
+	// The folder might already exist, which servers report as 405 Method Not Allowed
+	req, err := http.NewRequest("MKCOL", folderURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusCreated, http.StatusMethodNotAllowed)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	req, err = http.NewRequest("MOVE", c.fileURL(f), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", folderURL.JoinPath(f.Name).String())
+	req.Header.Set("Overwrite", "T")
+	resp, err = c.do(req, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}